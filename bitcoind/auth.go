@@ -0,0 +1,166 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+RPC Authentication
+
+Either a static user/pass from the config, or the `.cookie` file bitcoind
+writes into its datadir on startup. The cookie changes every time bitcoind
+restarts, so it is re-read whenever bitcoind answers with a 401.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gitlab.com/nolim1t/golang-httpd-test/common"
+)
+
+const (
+	DefaultDataDir    = "~/.bitcoin"
+	DefaultCookieName = ".cookie"
+)
+
+type (
+	// Shared between copies of Bitcoind so a reloaded cookie is seen everywhere
+	credentials struct {
+		mu         sync.RWMutex
+		user, pass string
+		cookieFile string // empty when using a static user/pass
+	}
+)
+
+// networkDir returns the datadir sub directory bitcoind uses for a network
+func networkDir(network string) string {
+	switch strings.ToLower(network) {
+	case "test", "testnet", "testnet3":
+		return "testnet3"
+	case "testnet4":
+		return "testnet4"
+	case "signet":
+		return "signet"
+	case "regtest":
+		return "regtest"
+	default:
+		return ""
+	}
+}
+
+// networkPort returns the default RPC port for a network
+func networkPort(network string) int64 {
+	switch networkDir(network) {
+	case "testnet3":
+		return 18332
+	case "testnet4":
+		return 48332
+	case "signet":
+		return 38332
+	case "regtest":
+		return 18443
+	default:
+		return DefaultPort
+	}
+}
+
+// findCookieFile returns the cookie file to use, or "" if static credentials should be used.
+// An explicit cookie-file always wins, otherwise the cookie is only looked up
+// in the datadir when no password is configured. The file doesn't have to
+// exist yet, bitcoind only writes it once it's up.
+func findCookieFile(conf common.Bitcoind) string {
+	if conf.CookieFile != "" {
+		return common.CleanAndExpandPath(conf.CookieFile)
+	}
+	if conf.Pass != "" {
+		return ""
+	}
+	dataDir := conf.DataDir
+	if dataDir == "" {
+		dataDir = DefaultDataDir
+	}
+	return filepath.Join(common.CleanAndExpandPath(dataDir), networkDir(conf.Network), DefaultCookieName)
+}
+
+// readCookie parses a `__cookie__:<password>` file
+func readCookie(cookieFile string) (user, pass string, err error) {
+	contents, err := ioutil.ReadFile(cookieFile)
+	if err != nil {
+		return
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(contents)), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("malformed cookie file %s", cookieFile)
+	}
+	return parts[0], parts[1], nil
+}
+
+func newCredentials(conf common.Bitcoind) (*credentials, error) {
+	cookieFile := findCookieFile(conf)
+	if cookieFile == "" {
+		return &credentials{user: conf.User, pass: conf.Pass}, nil
+	}
+	creds := &credentials{cookieFile: cookieFile}
+	// bitcoind might not be running yet, in which case the
+	// cookie is read on first use (or the first 401) instead
+	if err := creds.reload(); err != nil {
+		fmt.Println(cookieWarning(conf, err))
+	}
+	return creds, nil
+}
+
+// cookieWarning explains an unreadable cookie at startup. Configs without a
+// pass used to get the default "lncmrocks", so say why the cookie is used.
+func cookieWarning(conf common.Bitcoind, err error) string {
+	if conf.CookieFile != "" {
+		return fmt.Sprintf("Bitcoind cookie not readable yet: %s", err)
+	}
+	return fmt.Sprintf("Bitcoind cookie not readable yet: %s. No 'pass' is set for bitcoind %s, "+
+		"so the cookie file is used (there is no default pass anymore). "+
+		"Set 'pass' or 'cookie-file' in the [bitcoind] section if bitcoind doesn't write it there.", err, conf.Host)
+}
+
+func (c *credentials) get() (user, pass string) {
+	if c == nil {
+		return
+	}
+	c.mu.RLock()
+	user, pass = c.user, c.pass
+	c.mu.RUnlock()
+	// No cookie yet when the client was set up, bitcoind may have written it since
+	if pass == "" && c.usesCookie() && c.reload() == nil {
+		c.mu.RLock()
+		user, pass = c.user, c.pass
+		c.mu.RUnlock()
+	}
+	return
+}
+
+// reload re-reads the cookie file (bitcoind writes a new one on every restart)
+func (c *credentials) reload() error {
+	if c == nil || c.cookieFile == "" {
+		return fmt.Errorf("no cookie file configured")
+	}
+	user, pass, err := readCookie(c.cookieFile)
+	if err != nil {
+		return fmt.Errorf("can't read bitcoind cookie: %w", err)
+	}
+	c.mu.Lock()
+	c.user, c.pass = user, pass
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *credentials) usesCookie() bool {
+	return c != nil && c.cookieFile != ""
+}
@@ -0,0 +1,124 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gitlab.com/nolim1t/golang-httpd-test/common"
+)
+
+// A bitcoind that only accepts the password it last wrote to its cookie file
+type cookieNode struct {
+	mu       sync.Mutex
+	dataDir  string
+	password string
+}
+
+func (n *cookieNode) start(t *testing.T, password string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.password = password
+	cookie := filepath.Join(n.dataDir, "regtest", DefaultCookieName)
+	if err := ioutil.WriteFile(cookie, []byte("__cookie__:"+password), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (n *cookieNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "__cookie__" || pass == "" || pass != n.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Write([]byte(`{"result":42,"error":null,"id":null}`))
+}
+
+func TestCookieWrittenAfterStartup(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "bitcoind-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	if err := os.MkdirAll(filepath.Join(dataDir, "regtest"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	node := &cookieNode{dataDir: dataDir}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNumber, _ := strconv.ParseInt(port, 10, 64)
+
+	// bitcoind isn't up yet, so there's no cookie
+	client, err := newClient(common.Bitcoind{Host: host, Port: portNumber, DataDir: dataDir, Network: "regtest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.auth.usesCookie() {
+		t.Fatal("client doesn't use the cookie when it doesn't exist yet")
+	}
+	ctx := context.Background()
+
+	node.start(t, "first")
+	if count, err := client.BlockCount(ctx); err != nil || count != 42 {
+		t.Fatalf("after bitcoind started: %d, %v", count, err)
+	}
+
+	// bitcoind restarted with a new cookie: re-read on the 401
+	node.start(t, "second")
+	if count, err := client.BlockCount(ctx); err != nil || count != 42 {
+		t.Fatalf("after bitcoind restarted: %d, %v", count, err)
+	}
+}
+
+func TestStaticCredentialsWithPassword(t *testing.T) {
+	creds, err := newCredentials(common.Bitcoind{User: "user", Pass: "pass", DataDir: "/nonexistent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.usesCookie() {
+		t.Fatal("a configured password should not use the cookie")
+	}
+	if user, pass := creds.get(); user != "user" || pass != "pass" {
+		t.Fatalf("got %s:%s, want user:pass", user, pass)
+	}
+}
+
+func TestCookieWarning(t *testing.T) {
+	_, _, err := readCookie("/nonexistent/.cookie")
+	if err == nil {
+		t.Fatal("read a cookie that doesn't exist")
+	}
+
+	// an implicit cookie names the file and the missing pass
+	warning := cookieWarning(common.Bitcoind{Host: "node1"}, err)
+	for _, want := range []string{"/nonexistent/.cookie", "'pass'", "node1"} {
+		if !strings.Contains(warning, want) {
+			t.Errorf("got %q, want it to mention %s", warning, want)
+		}
+	}
+	warning = cookieWarning(common.Bitcoind{Host: "node1", CookieFile: "/nonexistent/.cookie"}, err)
+	if !strings.Contains(warning, "/nonexistent/.cookie") || strings.Contains(warning, "'pass'") {
+		t.Errorf("got %q for a configured cookie-file", warning)
+	}
+}
@@ -0,0 +1,203 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+JSON-RPC batching
+
+Bitcoind accepts an array of request objects in a single POST and answers with
+an array of response objects. The responses are not guaranteed to come back in
+the same order, so every call gets its index as ID and results are matched back
+by that ID.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// Maximum amount of blocks that can be requested through GetBlockRange
+	MaxBlockRange = 100
+	// Maximum amount of transactions that can be requested through GetTransactions
+	MaxTransactions = 1000
+)
+
+type (
+	// A single call inside a batch
+	BatchRequest struct {
+		Method string
+		Params []interface{}
+	}
+
+	// Result for the call at the same index of the batch.
+	// Err is set when bitcoind returned an error for this call only.
+	BatchResult struct {
+		Result json.RawMessage
+		Err    error
+	}
+)
+
+// NewBatchRequest is a shorthand for building a BatchRequest
+func NewBatchRequest(method string, params ...interface{}) BatchRequest {
+	return BatchRequest{Method: method, Params: params}
+}
+
+// SendBatch sends all calls in one round-trip.
+// err is only set if the batch as a whole failed, per call errors are in the results.
+func (b Bitcoind) SendBatch(ctx context.Context, calls []BatchRequest) (results []BatchResult, err error) {
+	if len(calls) == 0 {
+		return
+	}
+	reqs := make([]requestBody, len(calls))
+	for i, call := range calls {
+		params := call.Params
+		if params == nil {
+			params = []interface{}{}
+		}
+		reqs[i] = requestBody{
+			JSONRPC: "1.0",
+			ID:      strconv.Itoa(i),
+			Method:  call.Method,
+			Params:  params,
+		}
+	}
+	reqBody, err := json.Marshal(reqs)
+	if err != nil {
+		return
+	}
+
+	resBytes, err := b.post(ctx, reqBody)
+	if err != nil {
+		return
+	}
+
+	var resBodies []responseBody
+	err = json.Unmarshal(resBytes, &resBodies)
+	if err != nil {
+		// bitcoind answers with a single object if it rejects the whole batch
+		var resBody responseBody
+		if json.Unmarshal(resBytes, &resBody) == nil && resBody.Error != nil {
+			return nil, resBody.Error
+		}
+		return
+	}
+
+	results = make([]BatchResult, len(calls))
+	seen := make([]bool, len(calls))
+	for _, resBody := range resBodies {
+		i, convErr := strconv.Atoi(resBody.ID)
+		if convErr != nil || i < 0 || i >= len(calls) {
+			continue
+		}
+		seen[i] = true
+		if resBody.Error != nil {
+			results[i].Err = resBody.Error
+			continue
+		}
+		results[i].Result = resBody.Result
+	}
+	for i := range results {
+		if !seen[i] {
+			results[i].Err = fmt.Errorf("no response from bitcoind for %s", calls[i].Method)
+		}
+	}
+
+	return results, nil
+}
+
+// GetBlockRange fetches blocks from height `from` to `to` (inclusive)
+// using two batched round-trips: one for the hashes and one for the blocks.
+func (b Bitcoind) GetBlockRange(ctx context.Context, from, to int64) (blocks []BitcoinBlockResponse, err error) {
+	if from < 0 || to < from {
+		return nil, fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+	if to-from+1 > MaxBlockRange {
+		return nil, fmt.Errorf("block range too large (max %d blocks)", MaxBlockRange)
+	}
+
+	hashCalls := make([]BatchRequest, 0, to-from+1)
+	for height := from; height <= to; height++ {
+		hashCalls = append(hashCalls, NewBatchRequest(MethodGetHashByHeight, height))
+	}
+	hashResults, err := b.SendBatch(ctx, hashCalls)
+	if err != nil {
+		return
+	}
+
+	blockCalls := make([]BatchRequest, len(hashResults))
+	for i, res := range hashResults {
+		if res.Err != nil {
+			return nil, fmt.Errorf("block %d: %w", from+int64(i), res.Err)
+		}
+		var hash string
+		err = json.Unmarshal(res.Result, &hash)
+		if err != nil {
+			return
+		}
+		blockCalls[i] = NewBatchRequest(MethodGetBlock, hash, 1)
+	}
+	blockResults, err := b.SendBatch(ctx, blockCalls)
+	if err != nil {
+		return
+	}
+
+	blocks = make([]BitcoinBlockResponse, len(blockResults))
+	for i, res := range blockResults {
+		if res.Err != nil {
+			return nil, fmt.Errorf("block %d: %w", from+int64(i), res.Err)
+		}
+		err = json.Unmarshal(res.Result, &blocks[i])
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// GetTransactions looks up several transactions (getrawtransaction, verbose) in one round-trip.
+// Transactions bitcoind can't find (no -txindex, or unknown) are left out of the map.
+func (b Bitcoind) GetTransactions(ctx context.Context, txids []string) (txs map[string]VerboseTransactionInfo, err error) {
+	if len(txids) > MaxTransactions {
+		return nil, fmt.Errorf("too many transactions (max %d)", MaxTransactions)
+	}
+	calls := make([]BatchRequest, len(txids))
+	for i, txid := range txids {
+		calls[i] = NewBatchRequest(MethodGetRawTransaction, txid, 1)
+	}
+	results, err := b.SendBatch(ctx, calls)
+	if err != nil {
+		return
+	}
+
+	txs = make(map[string]VerboseTransactionInfo, len(results))
+	for i, res := range results {
+		var rpcErr *RPCError
+		if errors.As(res.Err, &rpcErr) && rpcErr.Code == RPCInvalidAddressOrKey {
+			continue
+		}
+		if res.Err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", txids[i], res.Err)
+		}
+		var tx VerboseTransactionInfo
+		err = json.Unmarshal(res.Result, &tx)
+		if err != nil {
+			return nil, err
+		}
+		txs[txids[i]] = tx
+	}
+
+	return
+}
@@ -0,0 +1,95 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A backend answering a batch with reply(requests)
+func batchNode(t *testing.T, reply func([]requestBody) interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []requestBody
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			t.Errorf("not a batch: %v", err)
+			return
+		}
+		json.NewEncoder(w).Encode(reply(reqs))
+	}))
+}
+
+func TestSendBatchMatchesIDs(t *testing.T) {
+	server := batchNode(t, func(reqs []requestBody) interface{} {
+		// in reverse, with an error for the second call, an unknown ID and no reply for the fourth
+		var replies []interface{}
+		for i := len(reqs) - 1; i >= 0; i-- {
+			switch i {
+			case 1:
+				replies = append(replies, map[string]interface{}{"id": reqs[i].ID, "result": nil, "error": map[string]interface{}{"code": -5, "message": "Block not found"}})
+			case 3:
+			default:
+				replies = append(replies, map[string]interface{}{"id": reqs[i].ID, "result": reqs[i].Method, "error": nil})
+			}
+		}
+		return append(replies, map[string]interface{}{"id": "99", "result": "stray", "error": nil})
+	})
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+
+	calls := []BatchRequest{
+		NewBatchRequest("a"),
+		NewBatchRequest("b", 1),
+		NewBatchRequest("c"),
+		NewBatchRequest("d"),
+	}
+	results, err := client.SendBatch(context.Background(), calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(calls) {
+		t.Fatalf("%d results for %d calls", len(results), len(calls))
+	}
+	for _, i := range []int{0, 2} {
+		var method string
+		if err := json.Unmarshal(results[i].Result, &method); err != nil || results[i].Err != nil || method != calls[i].Method {
+			t.Errorf("call %d: got %s, %v, want the result of %s", i, results[i].Result, results[i].Err, calls[i].Method)
+		}
+	}
+	var rpcErr *RPCError
+	if !errors.As(results[1].Err, &rpcErr) || rpcErr.Code != RPCInvalidAddressOrKey {
+		t.Errorf("call 1: got %v, want its RPC error", results[1].Err)
+	}
+	if results[3].Err == nil {
+		t.Error("call 3: no error without a reply")
+	}
+}
+
+func TestSendBatchRejected(t *testing.T) {
+	server := batchNode(t, func([]requestBody) interface{} {
+		return map[string]interface{}{"id": nil, "result": nil, "error": map[string]interface{}{"code": -32700, "message": "Parse error"}}
+	})
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+
+	var rpcErr *RPCError
+	_, err := client.SendBatch(context.Background(), []BatchRequest{NewBatchRequest("a")})
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPCParseError {
+		t.Errorf("got %v, want the error of the whole batch", err)
+	}
+	if results, err := client.SendBatch(context.Background(), nil); results != nil || err != nil {
+		t.Errorf("empty batch: got %v, %v", results, err)
+	}
+}
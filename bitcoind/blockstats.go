@@ -0,0 +1,170 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Block statistics over ranges
+
+getblockstats is slow (it reads the undo data of the block), so ranges are
+fetched by a few workers in parallel rather than in one batch which bitcoind
+would work through on a single thread.
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/getblockstats.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	// Maximum amount of blocks that can be requested through GetBlockStatsRange
+	MaxBlockStatsRange = 500
+	// Concurrent getblockstats calls for a range
+	blockStatsWorkers = 8
+)
+
+var (
+	// Stats getblockstats can select
+	BlockStatsFields = []string{
+		"avgfee", "avgfeerate", "avgtxsize", "blockhash", "feerate_percentiles", "height", "ins",
+		"maxfee", "maxfeerate", "maxtxsize", "medianfee", "mediantime", "mediantxsize", "minfee",
+		"minfeerate", "mintxsize", "outs", "subsidy", "swtotal_size", "swtotal_weight", "swtxs",
+		"time", "total_out", "total_size", "total_weight", "totalfee", "txs", "utxo_increase",
+		"utxo_size_inc",
+	}
+	// Stats that get aggregated over a range (fees, transaction counts and weights)
+	BlockStatsAggregateFields = []string{"totalfee", "txs", "total_weight"}
+	// Percentiles of the aggregates
+	BlockStatsPercentiles = []int{10, 25, 50, 75, 90}
+)
+
+type (
+	// Selected stats of a single block, as returned by getblockstats
+	BlockStats map[string]interface{}
+
+	// Aggregate of a stat over a range of blocks
+	BlockStatsAggregate struct {
+		Sum         float64            `json:"sum"`
+		Avg         float64            `json:"avg"`
+		Min         float64            `json:"min"`
+		Max         float64            `json:"max"`
+		Percentiles map[string]float64 `json:"percentiles"` // nearest rank, keyed by percentile
+	}
+)
+
+// GetBlockStatsSelected gets the stats of a block by height or hash, all stats when none are selected
+func (b Bitcoind) GetBlockStatsSelected(ctx context.Context, hashOrHeight interface{}, stats []string) (blockstats BlockStats, err error) {
+	params := []interface{}{hashOrHeight}
+	if len(stats) > 0 {
+		params = append(params, stats)
+	}
+	res, err := b.sendRequest(ctx, MethodGetBlockStats, params...)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &blockstats)
+
+	return
+}
+
+// GetBlockStatsRange gets the stats of the blocks from height `from` to `to` (inclusive), in order
+func (b Bitcoind) GetBlockStatsRange(ctx context.Context, from, to int64, stats []string) (blocks []BlockStats, err error) {
+	if from < 0 || to < from {
+		return nil, fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+	if to-from+1 > MaxBlockStatsRange {
+		return nil, fmt.Errorf("block range too large (max %d blocks)", MaxBlockStatsRange)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	blocks = make([]BlockStats, to-from+1)
+	heights := make(chan int64)
+	var wg sync.WaitGroup
+	var once sync.Once
+	for i := 0; i < blockStatsWorkers && i < len(blocks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for height := range heights {
+				blockstats, statsErr := b.GetBlockStatsSelected(ctx, height, stats)
+				if statsErr != nil {
+					once.Do(func() {
+						err = fmt.Errorf("block %d: %w", height, statsErr)
+						cancel()
+					})
+					continue
+				}
+				blocks[height-from] = blockstats
+			}
+		}()
+	}
+feed:
+	for height := from; height <= to; height++ {
+		select {
+		case heights <- height:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(heights)
+	wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// AggregateBlockStats sums up the numeric stats in fields over all blocks,
+// stats that are not in the blocks (not selected) are left out
+func AggregateBlockStats(blocks []BlockStats, fields []string) map[string]BlockStatsAggregate {
+	aggregates := map[string]BlockStatsAggregate{}
+	for _, field := range fields {
+		values := make([]float64, 0, len(blocks))
+		for _, block := range blocks {
+			if value, ok := block[field].(float64); ok {
+				values = append(values, value)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		sort.Float64s(values)
+		aggregate := BlockStatsAggregate{
+			Min:         values[0],
+			Max:         values[len(values)-1],
+			Percentiles: map[string]float64{},
+		}
+		for _, value := range values {
+			aggregate.Sum += value
+		}
+		aggregate.Avg = math.Round(aggregate.Sum/float64(len(values))*1000) / 1000
+		for _, p := range BlockStatsPercentiles {
+			rank := int(math.Ceil(float64(p)/100*float64(len(values)))) - 1
+			if rank < 0 {
+				rank = 0
+			}
+			aggregate.Percentiles[fmt.Sprint(p)] = values[rank]
+		}
+		aggregates[field] = aggregate
+	}
+	return aggregates
+}
@@ -0,0 +1,111 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// A node with blocks of height*10 transactions, getblockstats fails at failAt
+func blockStatsNode(t *testing.T, failAt int64, calls *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(calls, 1)
+		var req requestBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != MethodGetBlockStats {
+			t.Errorf("unexpected request %+v: %v", req, err)
+			return
+		}
+		height := int64(req.Params[0].(float64))
+		if height == failAt {
+			w.Write([]byte(`{"result":null,"error":{"code":-8,"message":"Target block height after current tip"},"id":null}`))
+			return
+		}
+		fmt.Fprintf(w, `{"result":{"height":%d,"txs":%d},"error":null,"id":null}`, height, height*10)
+	}))
+}
+
+func TestGetBlockStatsRange(t *testing.T) {
+	var calls int64
+	server := blockStatsNode(t, -1, &calls)
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+
+	blocks, err := client.GetBlockStatsRange(context.Background(), 10, 40, []string{"height", "txs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 31 || calls != 31 {
+		t.Fatalf("%d blocks in %d calls, want 31", len(blocks), calls)
+	}
+	// in order, whichever worker got them
+	for i, block := range blocks {
+		if block["height"] != float64(10+i) || block["txs"] != float64((10+i)*10) {
+			t.Errorf("block %d: got %v", i, block)
+		}
+	}
+
+	if _, err := client.GetBlockStatsRange(context.Background(), 10, 10+MaxBlockStatsRange, nil); err == nil {
+		t.Error("no error for a range that is too large")
+	}
+}
+
+func TestGetBlockStatsRangeFails(t *testing.T) {
+	var calls int64
+	server := blockStatsNode(t, 15, &calls)
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+
+	_, err := client.GetBlockStatsRange(context.Background(), 10, 400, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "block 15:") {
+		t.Fatalf("got %v, want the error of block 15", err)
+	}
+	// the remaining blocks aren't fetched
+	if calls > 15+blockStatsWorkers {
+		t.Errorf("%d calls after the error", calls)
+	}
+}
+
+func TestAggregateBlockStats(t *testing.T) {
+	var blocks []BlockStats
+	for i := 10; i >= 1; i-- {
+		blocks = append(blocks, BlockStats{"txs": float64(i), "blockhash": "hash"})
+	}
+	// a block without the stat doesn't count
+	blocks = append(blocks, BlockStats{})
+
+	aggregates := AggregateBlockStats(blocks, []string{"txs", "totalfee", "blockhash"})
+	if len(aggregates) != 1 {
+		t.Fatalf("got %v, want only txs", aggregates)
+	}
+	txs := aggregates["txs"]
+	if txs.Sum != 55 || txs.Avg != 5.5 || txs.Min != 1 || txs.Max != 10 {
+		t.Errorf("got %+v, want sum 55, avg 5.5, min 1 and max 10", txs)
+	}
+	// nearest rank
+	want := map[string]float64{"10": 1, "25": 3, "50": 5, "75": 8, "90": 9}
+	if fmt.Sprint(txs.Percentiles) != fmt.Sprint(want) {
+		t.Errorf("percentiles: got %v, want %v", txs.Percentiles, want)
+	}
+
+	single := AggregateBlockStats([]BlockStats{{"txs": float64(7)}}, []string{"txs"})["txs"]
+	for p, value := range single.Percentiles {
+		if value != 7 {
+			t.Errorf("percentile %s of a single block: got %g, want 7", p, value)
+		}
+	}
+}
@@ -24,19 +24,19 @@ import (
 
 type (
         BitcoinClient interface {
-                BlockCount() (int64, error)
-                BlockchainInfo() (bitcoind.BlockchainInfoResponse, error)
-                NetworkInfo() (bitcoind.NetworkInfoResponse, err error)
-                GetTransactionInfo(string) (bitcoind.VerboseTransactionInfo, error)
-                GetMempoolContents() (mempoolcontents []string, err error)
-                PushTransaction(hex string) (txid string, err error)
-                GetBestBlockHash() (blockhash string, err error)
-                GetBlockHashByHeight(height int64) (string, error)
-                GetBlock(hash string) (bitcoind.BitcoinBlockResponse, error)
-                GetMempoolInfo() (bitcoind.MempoolInfoResponse, error)
-                GetMiningInfo() (bitcoind.MiningInfoResponse, error)
-                GetPeerInfo() ([]PeerInfo, error)
-                GetBlockStats(int64) (bitcoind.BlockStatsResponse, error)
+                BlockCount(ctx context.Context) (int64, error)
+                BlockchainInfo(ctx context.Context) (bitcoind.BlockchainInfoResponse, error)
+                NetworkInfo(ctx context.Context) (bitcoind.NetworkInfoResponse, err error)
+                GetTransactionInfo(ctx context.Context, txid string) (bitcoind.VerboseTransactionInfo, error)
+                GetMempoolContents(ctx context.Context) (mempoolcontents []string, err error)
+                PushTransaction(ctx context.Context, hex string) (txid string, err error)
+                GetBestBlockHash(ctx context.Context) (blockhash string, err error)
+                GetBlockHashByHeight(ctx context.Context, height int64) (string, error)
+                GetBlock(ctx context.Context, hash string) (bitcoind.BitcoinBlockResponse, error)
+                GetMempoolInfo(ctx context.Context) (bitcoind.MempoolInfoResponse, error)
+                GetMiningInfo(ctx context.Context) (bitcoind.MiningInfoResponse, error)
+                GetPeerInfo(ctx context.Context) ([]PeerInfo, error)
+                GetBlockStats(ctx context.Context, height int64) (bitcoind.BlockStatsResponse, error)
         }
 )
 
@@ -47,10 +47,13 @@ var (
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"time"
 
 	// common utilities
 	// if commented out then we must redefine the following structs as outlined below
@@ -74,6 +77,11 @@ import (
        Port int64  `toml:"port"`
        User string `toml:"user"`
        Pass string `toml:"pass"`
+       CookieFile string `toml:"cookie-file"` // used instead of user/pass
+       DataDir    string `toml:"datadir"`     // where to look for .cookie if no pass is set
+       Network    string `toml:"network"`     // main, test, signet or regtest
+       ConnectTimeout int64 `toml:"connect-timeout"` // seconds
+       ReadTimeout    int64 `toml:"read-timeout"`    // seconds
    }
 
 */
@@ -81,12 +89,15 @@ const (
 	DefaultHostname = "localhost"
 	DefaultPort     = 8332
 	DefaultUsername = "lncm"
+	// Timeouts (in seconds) used when none are set under [bitcoind]
+	DefaultConnectTimeout = 5
+	DefaultReadTimeout    = 30
 
 	// Methods
 	MethodGetBlockCount         = "getblockcount"
 	MethodGetBlockchainInfo     = "getblockchaininfo"
 	MethodGetNetworkInfo        = "getnetworkinfo"
-	MethodGetNewAddress         = "getnetaddress"
+	MethodGetNewAddress         = "getnewaddress"
 	MethodImportAddress         = "importaddress"
 	MethodListReceivedByAddress = "listreceivedbyaddress"
 	MethodGetRawTransaction     = "getrawtransaction"
@@ -95,7 +106,7 @@ const (
 	// https://developer.bitcoin.org/reference/rpc/sendrawtransaction.html
 	MethodBroadcastTx = "sendrawtransaction"
 	// Blockchain hash stuff
-	MethodGetBlock        = "getblock" // verbosity = 1
+	MethodGetBlock        = "getblock" // see BlockVerbosity*
 	MethodGetBestBlock    = "getbestblockhash"
 	MethodGetHashByHeight = "getblockhash"
 	MethodGetMempool      = "getmempoolinfo"
@@ -104,26 +115,36 @@ const (
 	MethodGetBlockStats   = "getblockstats"
 
 	Bech32 = "bech32"
+
+	// getblock verbosity levels
+	BlockVerbosityHex          = 0 // serialized block as hex
+	BlockVerbosityJSON         = 1 // block header and txids
+	BlockVerbosityTransactions = 2 // block header and decoded transactions
 )
 
 type (
 	Bitcoind struct {
-		url, user, pass string
+		url  string
+		auth *credentials
+		// client carries the connect timeout, readTimeout is the deadline
+		// applied to each individual RPC call on top of the caller's context
+		client      *http.Client
+		readTimeout time.Duration
+		// called when bitcoind can't be reached at all (used by Pool for failover)
+		onFailure func(err error)
 	}
 
 	requestBody struct {
-		JSONRPC string        `json: "jsonrpc"`
+		JSONRPC string        `json:"jsonrpc"`
 		ID      string        `json:"id"`
 		Method  string        `json:"method"`
 		Params  []interface{} `json:"params"`
 	}
 
 	responseBody struct {
+		ID     string          `json:"id"`
 		Result json.RawMessage `json:"result"`
-		Error  *struct {
-			Code    int    `json:"code,omitempty"`
-			Message string `json:"message,omitempty"`
-		} `json:"error,omitempty"`
+		Error  *RPCError       `json:"error,omitempty"`
 	}
 
 	// Bitcoin structs
@@ -149,9 +170,17 @@ type (
 
 	// Input Transactions (Unspent UTXOs to build TX from)
 	TransactionInput struct {
-		TransactionID string `json:"txid"`
-		VoutID        int64  `json:"vout"`
-		Sequence      int64  `json:"sequence"`
+		TransactionID string        `json:"txid"`
+		VoutID        int64         `json:"vout"`
+		Sequence      int64         `json:"sequence"`
+		Coinbase      string        `json:"coinbase,omitempty"` // only set for the coinbase input (no txid/vout)
+		ScriptSig     *ScriptSigObj `json:"scriptSig,omitempty"`
+		Witness       []string      `json:"txinwitness,omitempty"`
+	}
+	// scriptSig struct in Transaction input
+	ScriptSigObj struct {
+		ASMCode string `json:"asm"`
+		HexCode string `json:"hex"`
 	}
 	// scriptPubKey struct in Transaction output
 	ScriptPubKeyObj struct {
@@ -160,6 +189,7 @@ type (
 		ScriptType           string   `json:"type"`
 		RequiredSigs         int64    `json:"reqsigs"`
 		TransactionAddresses []string `json:"addresses"`
+		Address              string   `json:"address,omitempty"` // bitcoind 22+ (replaces addresses)
 	}
 	// New UTXO to move transaction to
 	TransactionOutput struct {
@@ -179,6 +209,11 @@ type (
 		Time            int64               `json:"time,omitempty"`
 		Blocktime       int64               `json:"blocktime,omitempty"`
 		Blockhash       string              `json:"blockhash,omitempty"`
+		Version         int64               `json:"version"`
+		VSize           int64               `json:"vsize"`
+		Weight          int64               `json:"weight"`
+		LockTime        int64               `json:"locktime"`
+		Fee             float64             `json:"fee,omitempty"` // only set for transactions from getblock with verbosity 2
 		Vin             []TransactionInput  `json:"vin"`
 		Vout            []TransactionOutput `json:"vout"`
 	}
@@ -204,6 +239,11 @@ type (
 		PreviousBlockHash string   `json:"previousblockhash"`
 		NextBlockHash     string   `json:"nextblockhash"`
 	}
+	// Block with decoded transactions (getblock verbosity 2)
+	BitcoinBlockVerboseResponse struct {
+		BitcoinBlockResponse
+		Transactions []VerboseTransactionInfo `json:"tx"`
+	}
 	// Get mempool info struct
 	MempoolInfoResponse struct {
 		Size          int64   `json:"size"`
@@ -318,31 +358,40 @@ type (
 
 // Methods
 // GetBlockstats
-func (b Bitcoind) GetBlockStats(height int64) (blockstats BlockStatsResponse, err error) {
-	res, _ := b.sendRequest(MethodGetBlockStats, height)
+func (b Bitcoind) GetBlockStats(ctx context.Context, height int64) (blockstats BlockStatsResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBlockStats, height)
+	if err != nil {
+		return
+	}
 	err = json.Unmarshal(res, &blockstats)
 
 	return
 }
 
 // BlockCount
-func (b Bitcoind) BlockCount() (count int64, err error) {
-	res, _ := b.sendRequest(MethodGetBlockCount)
+func (b Bitcoind) BlockCount(ctx context.Context) (count int64, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBlockCount)
+	if err != nil {
+		return
+	}
 	err = json.Unmarshal(res, &count)
 
 	return
 }
 
-func (b Bitcoind) GetPeerInfo() (peerinfo []PeerInfo, err error) {
-	res, err := b.sendRequest(MethodGetPeerInfo)
+func (b Bitcoind) GetPeerInfo(ctx context.Context) (peerinfo []PeerInfo, err error) {
+	res, err := b.sendRequest(ctx, MethodGetPeerInfo)
+	if err != nil {
+		return
+	}
 	err = json.Unmarshal(res, &peerinfo)
 
 	return
 }
 
 // BlockchainInfo
-func (b Bitcoind) BlockchainInfo() (blockresp BlockchainInfoResponse, err error) {
-	res, err := b.sendRequest(MethodGetBlockchainInfo)
+func (b Bitcoind) BlockchainInfo(ctx context.Context) (blockresp BlockchainInfoResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBlockchainInfo)
 	if err != nil {
 		return
 	}
@@ -352,8 +401,8 @@ func (b Bitcoind) BlockchainInfo() (blockresp BlockchainInfoResponse, err error)
 }
 
 // NetworkInfo
-func (b Bitcoind) NetworkInfo() (nwinforesp NetworkInfoResponse, err error) {
-	res, err := b.sendRequest(MethodGetNetworkInfo)
+func (b Bitcoind) NetworkInfo(ctx context.Context) (nwinforesp NetworkInfoResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetNetworkInfo)
 	if err != nil {
 		return
 	}
@@ -363,8 +412,8 @@ func (b Bitcoind) NetworkInfo() (nwinforesp NetworkInfoResponse, err error) {
 }
 
 // Get transaction Info
-func (b Bitcoind) GetTransactionInfo(txid string) (txinfo VerboseTransactionInfo, err error) {
-	res, err := b.sendRequest(MethodGetRawTransaction, txid, 1)
+func (b Bitcoind) GetTransactionInfo(ctx context.Context, txid string) (txinfo VerboseTransactionInfo, err error) {
+	res, err := b.sendRequest(ctx, MethodGetRawTransaction, txid, 1)
 	if err != nil {
 		return
 	}
@@ -374,8 +423,8 @@ func (b Bitcoind) GetTransactionInfo(txid string) (txinfo VerboseTransactionInfo
 }
 
 // Get raw mempool
-func (b Bitcoind) GetMempoolContents() (mempoolcontents []string, err error) {
-	res, err := b.sendRequest(MethodGetMempoolContents)
+func (b Bitcoind) GetMempoolContents(ctx context.Context) (mempoolcontents []string, err error) {
+	res, err := b.sendRequest(ctx, MethodGetMempoolContents)
 	if err != nil {
 		return
 	}
@@ -385,8 +434,8 @@ func (b Bitcoind) GetMempoolContents() (mempoolcontents []string, err error) {
 }
 
 // MethodGetMempool
-func (b Bitcoind) GetMempoolInfo() (mempoolinfo MempoolInfoResponse, err error) {
-	res, err := b.sendRequest(MethodGetMempool)
+func (b Bitcoind) GetMempoolInfo(ctx context.Context) (mempoolinfo MempoolInfoResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetMempool)
 	if err != nil {
 		return
 	}
@@ -396,8 +445,13 @@ func (b Bitcoind) GetMempoolInfo() (mempoolinfo MempoolInfoResponse, err error)
 }
 
 // Broadcast TX
-func (b Bitcoind) PushTransaction(hex string) (txid string, err error) {
-	res, err := b.sendRequest(MethodBroadcastTx, hex)
+// maxFeeRate (BTC/kvB) rejects transactions paying more than that, 0 uses the bitcoind default
+func (b Bitcoind) PushTransaction(ctx context.Context, hex string, maxFeeRate float64) (txid string, err error) {
+	params := []interface{}{hex}
+	if maxFeeRate > 0 {
+		params = append(params, maxFeeRate)
+	}
+	res, err := b.sendRequest(ctx, MethodBroadcastTx, params...)
 	if err != nil {
 		return
 	}
@@ -407,8 +461,8 @@ func (b Bitcoind) PushTransaction(hex string) (txid string, err error) {
 }
 
 // Get best block hash
-func (b Bitcoind) GetBestBlockHash() (blockhash string, err error) {
-	res, err := b.sendRequest(MethodGetBestBlock)
+func (b Bitcoind) GetBestBlockHash(ctx context.Context) (blockhash string, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBestBlock)
 	if err != nil {
 		return
 	}
@@ -418,8 +472,8 @@ func (b Bitcoind) GetBestBlockHash() (blockhash string, err error) {
 }
 
 // get block hash by height
-func (b Bitcoind) GetBlockHashByHeight(height int64) (blockhash string, err error) {
-	res, err := b.sendRequest(MethodGetHashByHeight, height)
+func (b Bitcoind) GetBlockHashByHeight(ctx context.Context, height int64) (blockhash string, err error) {
+	res, err := b.sendRequest(ctx, MethodGetHashByHeight, height)
 	if err != nil {
 		return
 	}
@@ -429,8 +483,19 @@ func (b Bitcoind) GetBlockHashByHeight(height int64) (blockhash string, err erro
 }
 
 // getblock (MethodGetBlock)
-func (b Bitcoind) GetBlock(hash string) (blockinfo BitcoinBlockResponse, err error) {
-	res, err := b.sendRequest(MethodGetBlock, hash, 1)
+func (b Bitcoind) GetBlock(ctx context.Context, hash string) (blockinfo BitcoinBlockResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBlock, hash, BlockVerbosityJSON)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &blockinfo)
+
+	return
+}
+
+// getblock with decoded transactions (verbosity 2)
+func (b Bitcoind) GetBlockWithTransactions(ctx context.Context, hash string) (blockinfo BitcoinBlockVerboseResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBlock, hash, BlockVerbosityTransactions)
 	if err != nil {
 		return
 	}
@@ -439,9 +504,20 @@ func (b Bitcoind) GetBlock(hash string) (blockinfo BitcoinBlockResponse, err err
 	return
 }
 
+// getblock as serialized hex (verbosity 0)
+func (b Bitcoind) GetBlockHex(ctx context.Context, hash string) (blockhex string, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBlock, hash, BlockVerbosityHex)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &blockhex)
+
+	return
+}
+
 // GetMiningInfo
-func (b Bitcoind) GetMiningInfo() (mininginfo MiningInfoResponse, err error) {
-	res, err := b.sendRequest(MethodGetMiningInfo)
+func (b Bitcoind) GetMiningInfo(ctx context.Context) (mininginfo MiningInfoResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetMiningInfo)
 	if err != nil {
 		return
 	}
@@ -450,7 +526,7 @@ func (b Bitcoind) GetMiningInfo() (mininginfo MiningInfoResponse, err error) {
 }
 
 // sendRequest
-func (b Bitcoind) sendRequest(method string, params ...interface{}) (response []byte, err error) {
+func (b Bitcoind) sendRequest(ctx context.Context, method string, params ...interface{}) (response []byte, err error) {
 	reqBody, err := json.Marshal(requestBody{
 		JSONRPC: "1.0",
 		Method:  method,
@@ -461,61 +537,160 @@ func (b Bitcoind) sendRequest(method string, params ...interface{}) (response []
 		return
 	}
 
-	req, err := http.NewRequest("POST", b.url, bytes.NewReader(reqBody))
+	resBytes, err := b.post(ctx, reqBody)
 	if err != nil {
-		fmt.Printf("Error making request to %s", b.url)
 		return
 	}
-	req.SetBasicAuth(b.user, b.pass)
-	req.Header.Set("Content-Type", "application/json")
-	req.Close = true
+	var resBody responseBody
 
-	res, err := http.DefaultClient.Do(req)
+	err = json.Unmarshal(resBytes, &resBody)
 	if err != nil {
 		return
 	}
+	//fmt.Printf("Raw Response from %s: %s\n", method, resBody.Result)
+
+	if resBody.Error != nil {
+		return nil, resBody.Error
+	}
 
+	return resBody.Result, nil
+}
+
+// post sends an already encoded JSON-RPC payload and returns the raw response.
+// The request is bound to ctx, so cancelling it (ie. the HTTP client going away)
+// aborts the in-flight RPC. readTimeout caps how long a single call may take.
+func (b Bitcoind) post(ctx context.Context, reqBody []byte) (resBytes []byte, err error) {
+	caller := ctx
+	if b.readTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.readTimeout)
+		defer cancel()
+	}
+
+	res, err := b.roundTrip(ctx, caller, reqBody)
+	if err != nil {
+		return
+	}
 	defer func() { _ = res.Body.Close() }()
-	resBytes, err := ioutil.ReadAll(res.Body)
+	return ioutil.ReadAll(res.Body)
+}
+
+// roundTrip sends a JSON-RPC payload and returns the response with its body unread,
+// retrying once with a fresh cookie if bitcoind restarted. ctx bounds the request
+// (with the read timeout), caller is the context of the caller without it.
+func (b Bitcoind) roundTrip(ctx, caller context.Context, reqBody []byte) (res *http.Response, err error) {
+	res, err = b.do(ctx, reqBody)
 	if err != nil {
+		// the caller giving up says nothing about the health of bitcoind,
+		// running into the read timeout does
+		if b.onFailure != nil && caller.Err() == nil {
+			b.onFailure(err)
+		}
 		return
 	}
-	var resBody responseBody
+	// bitcoind rotates the cookie on restart, pick up the new one and try once more
+	if res.StatusCode == http.StatusUnauthorized && b.auth.usesCookie() {
+		_ = res.Body.Close()
+		err = b.auth.reload()
+		if err != nil {
+			return
+		}
+		res, err = b.do(ctx, reqBody)
+		if err != nil {
+			return
+		}
+	}
 
-	err = json.Unmarshal(resBytes, &resBody)
+	if res.StatusCode == http.StatusUnauthorized {
+		_ = res.Body.Close()
+		return nil, ErrUnauthorized
+	}
+	return res, nil
+}
+
+// do makes a single HTTP round-trip to bitcoind
+func (b Bitcoind) do(ctx context.Context, reqBody []byte) (res *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", b.url, bytes.NewReader(reqBody))
 	if err != nil {
+		fmt.Printf("Error making request to %s", b.url)
 		return
 	}
-	//fmt.Printf("Raw Response from %s: %s\n", method, resBody.Result)
+	user, pass := b.auth.get()
+	req.SetBasicAuth(user, pass)
+	req.Header.Set("Content-Type", "application/json")
+	req.Close = true
 
-	if resBody.Error != nil {
-		return nil, fmt.Errorf("bitcoind error (%d): %s", resBody.Error.Code, resBody.Error.Message)
+	return b.httpClient().Do(req)
+}
+
+// httpClient falls back to the default client for a zero value Bitcoind
+func (b Bitcoind) httpClient() *http.Client {
+	if b.client == nil {
+		return http.DefaultClient
 	}
+	return b.client
+}
 
-	return resBody.Result, nil
+// newHTTPClient builds the transport used to talk to bitcoind.
+// Only the connection phase is limited here, the per-call deadline is set in sendRequest.
+func newHTTPClient(connectTimeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   connectTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout: connectTimeout,
+		},
+	}
 }
 
 // Create new object of Bitcoind client
 func New(conf common.Bitcoind) (Bitcoind, error) {
+	client, err := newClient(conf)
+	if err != nil {
+		return Bitcoind{}, err
+	}
+	fmt.Printf("Creating bitcoin client... %s\n", client.url)
+	_, err = client.BlockCount(context.Background())
+	if err != nil {
+		return Bitcoind{}, fmt.Errorf("can't connect to Bitcoind: %w", err)
+	}
+
+	return client, nil
+}
+
+// newClient fills in the defaults and sets up the client without contacting bitcoind
+func newClient(conf common.Bitcoind) (Bitcoind, error) {
 	// Check if theres a bitcoin conf defined
 	if conf.Host == "" {
 		conf.Host = DefaultHostname
 	}
 	if conf.Port == 0 {
-		conf.Port = DefaultPort
+		conf.Port = networkPort(conf.Network)
 	}
 	if conf.User == "" {
 		conf.User = DefaultUsername
 	}
-	client := Bitcoind{
-		url:  fmt.Sprintf("http://%s:%d", conf.Host, conf.Port),
-		user: conf.User,
-		pass: conf.Pass,
+	if conf.ConnectTimeout == 0 {
+		conf.ConnectTimeout = DefaultConnectTimeout
 	}
-	fmt.Printf("Creating bitcoin client... %s\n", client.url)
-	_, err := client.BlockCount()
+	if conf.ReadTimeout == 0 {
+		conf.ReadTimeout = DefaultReadTimeout
+	}
+	auth, err := newCredentials(conf)
 	if err != nil {
-		return Bitcoind{}, fmt.Errorf("can't connect to Bitcoind: %w", err)
+		return Bitcoind{}, err
+	}
+	client := Bitcoind{
+		url:         fmt.Sprintf("http://%s:%d", conf.Host, conf.Port),
+		auth:        auth,
+		client:      newHTTPClient(time.Duration(conf.ConnectTimeout) * time.Second),
+		readTimeout: time.Duration(conf.ReadTimeout) * time.Second,
+	}
+	if auth.usesCookie() {
+		fmt.Printf("Using bitcoind cookie file %s\n", auth.cookieFile)
 	}
 
 	return client, nil
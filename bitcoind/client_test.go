@@ -0,0 +1,148 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/common"
+)
+
+// A backend that doesn't answer until the request is cancelled (or the test ends)
+func hangingNode(cancelled chan<- struct{}) (server *httptest.Server, stop func()) {
+	done := make(chan struct{})
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server only notices the client going away once the body is read
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		case <-done:
+		}
+	}))
+	return server, func() {
+		close(done)
+		server.Close()
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	server, stop := hangingNode(cancelled)
+	defer stop()
+	client := Bitcoind{url: server.URL, readTimeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := client.BlockCount(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the read timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("call took %s with a read timeout of 50ms", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("request to bitcoind not aborted")
+	}
+}
+
+func TestCallerCancelsCall(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	server, stop := hangingNode(cancelled)
+	defer stop()
+	client := Bitcoind{url: server.URL, readTimeout: time.Minute}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := client.BlockCount(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("request to bitcoind not aborted")
+	}
+}
+
+func TestClientTimeoutDefaults(t *testing.T) {
+	for _, test := range []struct {
+		conf          common.Bitcoind
+		connect, read time.Duration
+	}{
+		{common.Bitcoind{Pass: "pass"}, DefaultConnectTimeout * time.Second, DefaultReadTimeout * time.Second},
+		{common.Bitcoind{Pass: "pass", ConnectTimeout: 2, ReadTimeout: 120}, 2 * time.Second, 120 * time.Second},
+	} {
+		client, err := newClient(test.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := client.httpClient().Transport.(*http.Transport)
+		if transport.TLSHandshakeTimeout != test.connect || client.readTimeout != test.read {
+			t.Errorf("%+v: got connect timeout %s and read timeout %s, want %s and %s",
+				test.conf, transport.TLSHandshakeTimeout, client.readTimeout, test.connect, test.read)
+		}
+		// the read timeout is per call, not on the HTTP client
+		if client.httpClient().Timeout != 0 {
+			t.Errorf("%+v: HTTP client timeout %s", test.conf, client.httpClient().Timeout)
+		}
+	}
+}
+
+// A backend answering getblock like bitcoind does for each verbosity
+func getBlockNode(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req requestBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != MethodGetBlock || len(req.Params) != 2 {
+			t.Errorf("unexpected request %+v: %v", req, err)
+			return
+		}
+		var result string
+		switch req.Params[1] {
+		case float64(BlockVerbosityHex):
+			result = `"00ff"`
+		case float64(BlockVerbosityJSON):
+			result = `{"hash":"h","height":5,"tx":["t1"]}`
+		case float64(BlockVerbosityTransactions):
+			result = `{"hash":"h","height":5,"tx":[{"txid":"t1","vin":[{"coinbase":"03"}]}]}`
+		default:
+			t.Errorf("unexpected verbosity %v", req.Params[1])
+		}
+		w.Write([]byte(`{"result":` + result + `,"error":null,"id":null}`))
+	}))
+}
+
+func TestGetBlockVerbosity(t *testing.T) {
+	server := getBlockNode(t)
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+	ctx := context.Background()
+
+	if hex, err := client.GetBlockHex(ctx, "h"); err != nil || hex != "00ff" {
+		t.Errorf("hex: got %q, %v", hex, err)
+	}
+	if block, err := client.GetBlock(ctx, "h"); err != nil || block.Height != 5 || len(block.Transactions) != 1 || block.Transactions[0] != "t1" {
+		t.Errorf("txids: got %+v, %v", block, err)
+	}
+	block, err := client.GetBlockWithTransactions(ctx, "h")
+	if err != nil || block.Height != 5 || len(block.Transactions) != 1 || block.Transactions[0].TransactionID != "t1" || block.Transactions[0].Vin[0].Coinbase != "03" {
+		t.Errorf("transactions: got %+v, %v", block, err)
+	}
+}
@@ -0,0 +1,180 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Decoding
+
+Transactions and scripts are passed to bitcoind as hex, PSBTs as base64.
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/decoderawtransaction.html
+https://developer.bitcoin.org/reference/rpc/decodescript.html
+https://developer.bitcoin.org/reference/rpc/decodepsbt.html
+https://developer.bitcoin.org/reference/rpc/analyzepsbt.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	MethodDecodeRawTransaction = "decoderawtransaction"
+	MethodDecodeScript         = "decodescript"
+	MethodDecodePSBT           = "decodepsbt"
+	MethodAnalyzePSBT          = "analyzepsbt"
+
+	// Size limits (in bytes) of what can be decoded
+	MaxTransactionSize = 4000000 // a transaction can't be larger than a block
+	MaxScriptSize      = 10000   // MAX_SCRIPT_SIZE
+	MaxPSBTSize        = 8000000 // PSBTs carry previous transactions as well
+)
+
+type (
+	// P2SH-segwit version of a decoded script
+	DecodeScriptSegwit struct {
+		ASMCode    string `json:"asm"`
+		HexCode    string `json:"hex"`
+		ScriptType string `json:"type"`
+		Address    string `json:"address,omitempty"`
+		P2SHSegwit string `json:"p2sh-segwit,omitempty"`
+	}
+
+	// Response for decodescript
+	DecodeScriptResponse struct {
+		ASMCode              string              `json:"asm"`
+		ScriptType           string              `json:"type"`
+		RequiredSigs         int64               `json:"reqSigs,omitempty"`
+		TransactionAddresses []string            `json:"addresses,omitempty"`
+		Address              string              `json:"address,omitempty"`
+		P2SH                 string              `json:"p2sh,omitempty"`
+		Segwit               *DecodeScriptSegwit `json:"segwit,omitempty"`
+	}
+
+	// BIP32 derivation path of a key in a PSBT
+	PSBTBip32Derivation struct {
+		PubKey            string `json:"pubkey"`
+		MasterFingerprint string `json:"master_fingerprint"`
+		Path              string `json:"path"`
+	}
+
+	// Script inside a PSBT (redeem or witness script)
+	PSBTScript struct {
+		ASMCode    string `json:"asm"`
+		HexCode    string `json:"hex"`
+		ScriptType string `json:"type"`
+	}
+
+	// Output being spent by a PSBT input
+	PSBTWitnessUTXO struct {
+		Amount       float64         `json:"amount"`
+		ScriptPubKey ScriptPubKeyObj `json:"scriptPubKey"`
+	}
+
+	PSBTInput struct {
+		NonWitnessUTXO     *VerboseTransactionInfo `json:"non_witness_utxo,omitempty"`
+		WitnessUTXO        *PSBTWitnessUTXO        `json:"witness_utxo,omitempty"`
+		PartialSignatures  map[string]string       `json:"partial_signatures,omitempty"`
+		Sighash            string                  `json:"sighash,omitempty"`
+		RedeemScript       *PSBTScript             `json:"redeem_script,omitempty"`
+		WitnessScript      *PSBTScript             `json:"witness_script,omitempty"`
+		Bip32Derivations   []PSBTBip32Derivation   `json:"bip32_derivs,omitempty"`
+		FinalScriptSig     *ScriptSigObj           `json:"final_scriptSig,omitempty"`
+		FinalScriptWitness []string                `json:"final_scriptwitness,omitempty"`
+		Unknown            map[string]string       `json:"unknown,omitempty"`
+	}
+
+	PSBTOutput struct {
+		RedeemScript     *PSBTScript           `json:"redeem_script,omitempty"`
+		WitnessScript    *PSBTScript           `json:"witness_script,omitempty"`
+		Bip32Derivations []PSBTBip32Derivation `json:"bip32_derivs,omitempty"`
+		Unknown          map[string]string     `json:"unknown,omitempty"`
+	}
+
+	// Response for decodepsbt
+	DecodePSBTResponse struct {
+		Transaction VerboseTransactionInfo `json:"tx"`
+		Unknown     map[string]string      `json:"unknown"`
+		Inputs      []PSBTInput            `json:"inputs"`
+		Outputs     []PSBTOutput           `json:"outputs"`
+		Fee         float64                `json:"fee,omitempty"` // only known if all inputs have UTXO info
+	}
+
+	// What is still needed to finalize a PSBT input
+	PSBTMissing struct {
+		PubKeys       []string `json:"pubkeys,omitempty"`
+		Signatures    []string `json:"signatures,omitempty"`
+		RedeemScript  string   `json:"redeemscript,omitempty"`
+		WitnessScript string   `json:"witnessscript,omitempty"`
+	}
+
+	PSBTInputAnalysis struct {
+		HasUTXO bool         `json:"has_utxo"`
+		IsFinal bool         `json:"is_final"`
+		Missing *PSBTMissing `json:"missing,omitempty"`
+		Next    string       `json:"next,omitempty"`
+	}
+
+	// Response for analyzepsbt
+	AnalyzePSBTResponse struct {
+		Inputs           []PSBTInputAnalysis `json:"inputs"`
+		EstimatedVSize   int64               `json:"estimated_vsize,omitempty"`
+		EstimatedFeeRate float64             `json:"estimated_feerate,omitempty"`
+		Fee              float64             `json:"fee,omitempty"`
+		Next             string              `json:"next"`
+		Error            string              `json:"error,omitempty"`
+	}
+)
+
+// DecodeRawTransaction
+func (b Bitcoind) DecodeRawTransaction(ctx context.Context, hex string) (tx VerboseTransactionInfo, err error) {
+	res, err := b.sendRequest(ctx, MethodDecodeRawTransaction, hex)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &tx)
+
+	return
+}
+
+// DecodeScript
+func (b Bitcoind) DecodeScript(ctx context.Context, hex string) (script DecodeScriptResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodDecodeScript, hex)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &script)
+
+	return
+}
+
+// DecodePSBT (base64)
+func (b Bitcoind) DecodePSBT(ctx context.Context, psbt string) (decoded DecodePSBTResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodDecodePSBT, psbt)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &decoded)
+
+	return
+}
+
+// AnalyzePSBT (base64)
+func (b Bitcoind) AnalyzePSBT(ctx context.Context, psbt string) (analysis AnalyzePSBTResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodAnalyzePSBT, psbt)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &analysis)
+
+	return
+}
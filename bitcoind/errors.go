@@ -0,0 +1,138 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+RPC Errors
+
+Error codes are taken from Bitcoin Core's src/rpc/protocol.h
+Use errors.As(err, &rpcErr) to get at the code of an error returned by any method.
+*/
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// Standard JSON-RPC 2.0 errors
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCParseError     = -32700
+
+	// General application defined errors
+	RPCMiscError            = -1
+	RPCTypeError            = -3
+	RPCInvalidAddressOrKey  = -5
+	RPCOutOfMemory          = -7
+	RPCInvalidParameter     = -8
+	RPCDatabaseError        = -20
+	RPCDeserializationError = -22
+	RPCVerifyError          = -25
+	RPCVerifyRejected       = -26
+	RPCVerifyAlreadyInChain = -27
+	RPCInWarmup             = -28
+	RPCMethodDeprecated     = -32
+
+	// P2P client errors
+	RPCClientNotConnected        = -9
+	RPCClientInInitialDownload   = -10
+	RPCClientNodeAlreadyAdded    = -23
+	RPCClientNodeNotAdded        = -24
+	RPCClientNodeNotConnected    = -29
+	RPCClientInvalidIPOrSubnet   = -30
+	RPCClientP2PDisabled         = -31
+	RPCClientNodeCapacityReached = -34
+
+	// Wallet errors
+	RPCWalletError               = -4
+	RPCWalletInsufficientFunds   = -6
+	RPCWalletInvalidLabelName    = -11
+	RPCWalletKeypoolRanOut       = -12
+	RPCWalletUnlockNeeded        = -13
+	RPCWalletPassphraseIncorrect = -14
+	RPCWalletWrongEncState       = -15
+	RPCWalletEncryptionFailed    = -16
+	RPCWalletAlreadyUnlocked     = -17
+	RPCWalletNotFound            = -18
+	RPCWalletNotSpecified        = -19
+	RPCWalletAlreadyLoaded       = -35
+	RPCWalletAlreadyExists       = -36
+)
+
+type (
+	// Error returned by bitcoind itself (as opposed to not being able to reach it)
+	RPCError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+var (
+	// bitcoind didn't accept the user/pass or cookie
+	ErrUnauthorized = errors.New("bitcoind rejected the RPC credentials (401)")
+
+	// Stable names for the codes above, used as machine readable error codes
+	rpcErrorNames = map[int]string{
+		RPCInvalidRequest:            "invalid_request",
+		RPCMethodNotFound:            "method_not_found",
+		RPCInvalidParams:             "invalid_params",
+		RPCInternalError:             "internal_error",
+		RPCParseError:                "parse_error",
+		RPCMiscError:                 "misc_error",
+		RPCTypeError:                 "type_error",
+		RPCInvalidAddressOrKey:       "invalid_address_or_key",
+		RPCOutOfMemory:               "out_of_memory",
+		RPCInvalidParameter:          "invalid_parameter",
+		RPCDatabaseError:             "database_error",
+		RPCDeserializationError:      "deserialization_error",
+		RPCVerifyError:               "verify_error",
+		RPCVerifyRejected:            "verify_rejected",
+		RPCVerifyAlreadyInChain:      "verify_already_in_chain",
+		RPCInWarmup:                  "in_warmup",
+		RPCMethodDeprecated:          "method_deprecated",
+		RPCClientNotConnected:        "client_not_connected",
+		RPCClientInInitialDownload:   "client_in_initial_download",
+		RPCClientNodeAlreadyAdded:    "client_node_already_added",
+		RPCClientNodeNotAdded:        "client_node_not_added",
+		RPCClientNodeNotConnected:    "client_node_not_connected",
+		RPCClientInvalidIPOrSubnet:   "client_invalid_ip_or_subnet",
+		RPCClientP2PDisabled:         "client_p2p_disabled",
+		RPCClientNodeCapacityReached: "client_node_capacity_reached",
+		RPCWalletError:               "wallet_error",
+		RPCWalletInsufficientFunds:   "wallet_insufficient_funds",
+		RPCWalletInvalidLabelName:    "wallet_invalid_label_name",
+		RPCWalletKeypoolRanOut:       "wallet_keypool_ran_out",
+		RPCWalletUnlockNeeded:        "wallet_unlock_needed",
+		RPCWalletPassphraseIncorrect: "wallet_passphrase_incorrect",
+		RPCWalletWrongEncState:       "wallet_wrong_enc_state",
+		RPCWalletEncryptionFailed:    "wallet_encryption_failed",
+		RPCWalletAlreadyUnlocked:     "wallet_already_unlocked",
+		RPCWalletNotFound:            "wallet_not_found",
+		RPCWalletNotSpecified:        "wallet_not_specified",
+		RPCWalletAlreadyLoaded:       "wallet_already_loaded",
+		RPCWalletAlreadyExists:       "wallet_already_exists",
+	}
+)
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("bitcoind error (%d): %s", e.Code, e.Message)
+}
+
+// Name returns a stable machine readable name for the error code
+func (e *RPCError) Name() string {
+	if name, ok := rpcErrorNames[e.Code]; ok {
+		return name
+	}
+	return "rpc_error"
+}
@@ -0,0 +1,189 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Fee estimation
+
+bitcoind reports fee rates in BTC/kvB, everything returned by EstimateFees is
+converted to sat/vB (1 BTC/kvB = 100000 sat/vB).
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/estimatesmartfee.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	MethodEstimateSmartFee = "estimatesmartfee"
+
+	EstimateModeEconomical   = "ECONOMICAL"
+	EstimateModeConservative = "CONSERVATIVE"
+
+	// Highest confirmation target bitcoind can estimate for
+	MaxFeeTarget = 1008
+)
+
+var (
+	// Confirmation targets used when none are configured
+	DefaultFeeTargets = []int64{1, 2, 3, 6, 12, 24, 144, 504, 1008}
+)
+
+type (
+	// Response for estimatesmartfee
+	SmartFeeResponse struct {
+		FeeRate float64  `json:"feerate"` // BTC/kvB, 0 if no estimate is available
+		Errors  []string `json:"errors"`
+		Blocks  int64    `json:"blocks"` // target the estimate is actually for
+	}
+
+	// Estimates for a single confirmation target, in sat/vB
+	FeeEstimate struct {
+		Target             int64    `json:"target"`
+		Economical         float64  `json:"economical"`
+		Conservative       float64  `json:"conservative"`
+		EconomicalBlocks   int64    `json:"economical_blocks"`
+		ConservativeBlocks int64    `json:"conservative_blocks"`
+		Errors             []string `json:"errors,omitempty"`
+	}
+
+	FeeEstimates struct {
+		MempoolMinFee float64       `json:"mempool_min_fee"` // sat/vB, no estimate goes below this
+		Estimates     []FeeEstimate `json:"estimates"`
+	}
+)
+
+// CheckFeeTargets returns the configured confirmation targets sorted and
+// without duplicates, or an error for a target bitcoind can't estimate for
+func CheckFeeTargets(targets []int64) ([]int64, error) {
+	checked := make([]int64, 0, len(targets))
+	seen := make(map[int64]bool)
+	for _, target := range targets {
+		if target < 1 || target > MaxFeeTarget {
+			return nil, fmt.Errorf("invalid confirmation target %d (must be 1-%d)", target, MaxFeeTarget)
+		}
+		if !seen[target] {
+			seen[target] = true
+			checked = append(checked, target)
+		}
+	}
+	sort.Slice(checked, func(i, j int) bool { return checked[i] < checked[j] })
+	return checked, nil
+}
+
+// BTC/kvB (as used by bitcoind) to sat/vB, rounded to 3 decimals
+func BTCPerKvBToSatPerVB(feeRate float64) float64 {
+	return math.Round(feeRate*1e5*1000) / 1000
+}
+
+// EstimateSmartFee
+func (b Bitcoind) EstimateSmartFee(ctx context.Context, target int64, mode string) (estimate SmartFeeResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodEstimateSmartFee, target, mode)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &estimate)
+
+	return
+}
+
+// EstimateSmartFees gets the estimates for all targets in a single batch, as bitcoind returns them
+func (b Bitcoind) EstimateSmartFees(ctx context.Context, targets []int64, mode string) (estimates []SmartFeeResponse, err error) {
+	calls := make([]BatchRequest, len(targets))
+	for i, target := range targets {
+		if target < 1 || target > MaxFeeTarget {
+			return nil, fmt.Errorf("invalid confirmation target %d (must be 1-%d)", target, MaxFeeTarget)
+		}
+		calls[i] = NewBatchRequest(MethodEstimateSmartFee, target, mode)
+	}
+	results, err := b.SendBatch(ctx, calls)
+	if err != nil {
+		return
+	}
+
+	estimates = make([]SmartFeeResponse, len(targets))
+	for i, result := range results {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		err = json.Unmarshal(result.Result, &estimates[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// EstimateFees gets economical and conservative estimates for all targets in a single batch.
+// Estimates are raised to the mempool minimum fee, which is also used when bitcoind has no estimate.
+func (b Bitcoind) EstimateFees(ctx context.Context, targets []int64) (fees FeeEstimates, err error) {
+	calls := []BatchRequest{NewBatchRequest(MethodGetMempool)}
+	for _, target := range targets {
+		if target < 1 || target > MaxFeeTarget {
+			return fees, fmt.Errorf("invalid confirmation target %d (must be 1-%d)", target, MaxFeeTarget)
+		}
+		calls = append(calls,
+			NewBatchRequest(MethodEstimateSmartFee, target, EstimateModeEconomical),
+			NewBatchRequest(MethodEstimateSmartFee, target, EstimateModeConservative),
+		)
+	}
+	results, err := b.SendBatch(ctx, calls)
+	if err != nil {
+		return
+	}
+
+	if results[0].Err != nil {
+		return fees, results[0].Err
+	}
+	var mempoolinfo MempoolInfoResponse
+	err = json.Unmarshal(results[0].Result, &mempoolinfo)
+	if err != nil {
+		return
+	}
+	fees.MempoolMinFee = BTCPerKvBToSatPerVB(mempoolinfo.MempoolMinFee)
+
+	fees.Estimates = make([]FeeEstimate, len(targets))
+	for i, target := range targets {
+		var economical, conservative SmartFeeResponse
+		for _, r := range []struct {
+			result BatchResult
+			dest   *SmartFeeResponse
+		}{
+			{results[1+2*i], &economical},
+			{results[2+2*i], &conservative},
+		} {
+			if r.result.Err != nil {
+				return fees, r.result.Err
+			}
+			err = json.Unmarshal(r.result.Result, r.dest)
+			if err != nil {
+				return
+			}
+		}
+		fees.Estimates[i] = FeeEstimate{
+			Target:             target,
+			Economical:         math.Max(BTCPerKvBToSatPerVB(economical.FeeRate), fees.MempoolMinFee),
+			Conservative:       math.Max(BTCPerKvBToSatPerVB(conservative.FeeRate), fees.MempoolMinFee),
+			EconomicalBlocks:   economical.Blocks,
+			ConservativeBlocks: conservative.Blocks,
+			Errors:             append(economical.Errors, conservative.Errors...),
+		}
+	}
+
+	return
+}
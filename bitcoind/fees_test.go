@@ -0,0 +1,41 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCheckFeeTargets(t *testing.T) {
+	for _, test := range []struct {
+		targets []int64
+		want    []int64 // nil for an error
+	}{
+		{[]int64{144, 1, 6, 1, 1008, 6}, []int64{1, 6, 144, 1008}},
+		{DefaultFeeTargets, DefaultFeeTargets},
+		{[]int64{}, []int64{}},
+		{[]int64{0, 6}, nil},
+		{[]int64{6, -1}, nil},
+		{[]int64{1009}, nil},
+	} {
+		got, err := CheckFeeTargets(test.targets)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%v: no error", test.targets)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%v: got %v, %v, want %v", test.targets, got, err, test.want)
+		}
+	}
+}
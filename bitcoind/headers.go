@@ -0,0 +1,178 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Block headers and chain tips
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/getblockheader.html
+https://developer.bitcoin.org/reference/rpc/getchaintips.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	MethodGetBlockHeader = "getblockheader"
+	MethodGetChainTips   = "getchaintips"
+
+	// Most headers GetHeaders returns at once (same as a P2P headers message)
+	MaxHeaderCount = 2000
+)
+
+type (
+	// Response for getblockheader (verbose)
+	BlockHeaderResponse struct {
+		Hash              string  `json:"hash"`
+		Confirmations     int64   `json:"confirmations"`
+		Height            int64   `json:"height"`
+		Version           int64   `json:"version"`
+		VersionHex        string  `json:"versionHex"`
+		MerkleRoot        string  `json:"merkleroot"`
+		Time              int64   `json:"time"`
+		MedianTime        int64   `json:"mediantime"`
+		Nonce             int64   `json:"nonce"`
+		Bits              string  `json:"bits"`
+		Difficulty        float64 `json:"difficulty"`
+		Chainwork         string  `json:"chainwork"`
+		TransactionCount  int64   `json:"nTx"`
+		PreviousBlockHash string  `json:"previousblockhash,omitempty"`
+		NextBlockHash     string  `json:"nextblockhash,omitempty"`
+	}
+
+	// A single entry of getchaintips
+	ChainTip struct {
+		Height    int64  `json:"height"`
+		Hash      string `json:"hash"`
+		BranchLen int64  `json:"branchlen"`
+		Status    string `json:"status"` // active, valid-fork, valid-headers, headers-only or invalid
+	}
+)
+
+// GetBlockHeader
+func (b Bitcoind) GetBlockHeader(ctx context.Context, hash string) (header BlockHeaderResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBlockHeader, hash, true)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &header)
+
+	return
+}
+
+// GetBlockHeaderHex returns the serialized 80 byte header as hex
+func (b Bitcoind) GetBlockHeaderHex(ctx context.Context, hash string) (header string, err error) {
+	res, err := b.sendRequest(ctx, MethodGetBlockHeader, hash, false)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &header)
+
+	return
+}
+
+// GetChainTips
+func (b Bitcoind) GetChainTips(ctx context.Context) (tips []ChainTip, err error) {
+	res, err := b.sendRequest(ctx, MethodGetChainTips)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &tips)
+
+	return
+}
+
+// GetHeaders returns up to count headers starting at height from, fewer when the tip is reached
+func (b Bitcoind) GetHeaders(ctx context.Context, from, count int64) (headers []BlockHeaderResponse, err error) {
+	results, err := b.getHeaders(ctx, from, count, true)
+	if err != nil {
+		return
+	}
+	headers = make([]BlockHeaderResponse, len(results))
+	for i, res := range results {
+		err = json.Unmarshal(res, &headers[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// GetHeadersHex is GetHeaders with every header serialized as hex
+func (b Bitcoind) GetHeadersHex(ctx context.Context, from, count int64) (headers []string, err error) {
+	results, err := b.getHeaders(ctx, from, count, false)
+	if err != nil {
+		return
+	}
+	headers = make([]string, len(results))
+	for i, res := range results {
+		err = json.Unmarshal(res, &headers[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// getHeaders fetches the hashes and then the headers in two batched round-trips
+func (b Bitcoind) getHeaders(ctx context.Context, from, count int64, verbose bool) (headers []json.RawMessage, err error) {
+	if from < 0 || count < 1 || count > MaxHeaderCount {
+		return nil, fmt.Errorf("invalid header range, from must be >= 0 and count between 1 and %d", MaxHeaderCount)
+	}
+
+	hashCalls := make([]BatchRequest, count)
+	for i := range hashCalls {
+		hashCalls[i] = NewBatchRequest(MethodGetHashByHeight, from+int64(i))
+	}
+	hashResults, err := b.SendBatch(ctx, hashCalls)
+	if err != nil {
+		return
+	}
+
+	headerCalls := make([]BatchRequest, 0, len(hashResults))
+	for i, res := range hashResults {
+		if res.Err != nil {
+			// heights past the tip are out of range, stop there
+			var rpcErr *RPCError
+			if i > 0 && errors.As(res.Err, &rpcErr) && rpcErr.Code == RPCInvalidParameter {
+				break
+			}
+			return nil, fmt.Errorf("block %d: %w", from+int64(i), res.Err)
+		}
+		var hash string
+		err = json.Unmarshal(res.Result, &hash)
+		if err != nil {
+			return
+		}
+		headerCalls = append(headerCalls, NewBatchRequest(MethodGetBlockHeader, hash, verbose))
+	}
+	headerResults, err := b.SendBatch(ctx, headerCalls)
+	if err != nil {
+		return
+	}
+
+	headers = make([]json.RawMessage, len(headerResults))
+	for i, res := range headerResults {
+		if res.Err != nil {
+			return nil, fmt.Errorf("block %d: %w", from+int64(i), res.Err)
+		}
+		headers[i] = res.Result
+	}
+
+	return
+}
@@ -0,0 +1,90 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// A chain with blocks up to height tip, the hash of a block is its height
+func chainNode(t *testing.T, tip int64) *Bitcoind {
+	server := batchNode(t, func(reqs []requestBody) interface{} {
+		var replies []interface{}
+		for _, req := range reqs {
+			reply := map[string]interface{}{"id": req.ID, "result": nil, "error": nil}
+			switch req.Method {
+			case MethodGetHashByHeight:
+				if height := int64(req.Params[0].(float64)); height <= tip {
+					reply["result"] = fmt.Sprint(height)
+				} else {
+					reply["error"] = map[string]interface{}{"code": RPCInvalidParameter, "message": "Block height out of range"}
+				}
+			case MethodGetBlockHeader:
+				hash := req.Params[0].(string)
+				if req.Params[1] == true {
+					reply["result"] = map[string]interface{}{"hash": hash, "height": json.RawMessage(hash)}
+				} else {
+					reply["result"] = "header" + hash
+				}
+			}
+			replies = append(replies, reply)
+		}
+		return replies
+	})
+	t.Cleanup(server.Close)
+	return &Bitcoind{url: server.URL}
+}
+
+func TestGetHeadersStopsAtTip(t *testing.T) {
+	client := chainNode(t, 102)
+	ctx := context.Background()
+
+	headers, err := client.GetHeadersHex(ctx, 100, 5)
+	if err != nil || fmt.Sprint(headers) != "[header100 header101 header102]" {
+		t.Errorf("got %v, %v, want the headers up to the tip", headers, err)
+	}
+	headers, err = client.GetHeadersHex(ctx, 0, 1)
+	if err != nil || fmt.Sprint(headers) != "[header0]" {
+		t.Errorf("got %v, %v, want the genesis header", headers, err)
+	}
+	// starting past the tip is an error, not an empty range
+	_, err = client.GetHeadersHex(ctx, 103, 5)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPCInvalidParameter {
+		t.Errorf("got %v, want the out of range error", err)
+	}
+	for _, count := range []int64{0, MaxHeaderCount + 1} {
+		if _, err := client.GetHeadersHex(ctx, 100, count); err == nil {
+			t.Errorf("no error for %d headers", count)
+		}
+	}
+	if _, err := client.GetHeadersHex(ctx, -1, 1); err == nil {
+		t.Error("no error for a negative height")
+	}
+}
+
+func TestGetHeadersVerbose(t *testing.T) {
+	client := chainNode(t, 102)
+
+	headers, err := client.GetHeaders(context.Background(), 101, 5)
+	if err != nil || len(headers) != 2 {
+		t.Fatalf("got %v, %v, want 2 headers", headers, err)
+	}
+	for i, header := range headers {
+		if header.Hash != fmt.Sprint(101+i) || header.Height != int64(101+i) {
+			t.Errorf("header %d: got %+v", i, header)
+		}
+	}
+}
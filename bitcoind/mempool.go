@@ -0,0 +1,142 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Mempool entries
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/getmempoolentry.html
+https://developer.bitcoin.org/reference/rpc/getrawmempool.html
+https://developer.bitcoin.org/reference/rpc/getmempoolancestors.html
+https://developer.bitcoin.org/reference/rpc/getmempooldescendants.html
+https://developer.bitcoin.org/reference/rpc/testmempoolaccept.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	MethodGetMempoolEntry       = "getmempoolentry"
+	MethodGetMempoolAncestors   = "getmempoolancestors"
+	MethodGetMempoolDescendants = "getmempooldescendants"
+	MethodTestMempoolAccept     = "testmempoolaccept"
+
+	// Most transactions testmempoolaccept takes at once (a package)
+	MaxPackageCount = 25
+)
+
+type (
+	// Fees of a mempool entry (in BTC)
+	MempoolEntryFees struct {
+		Base       float64 `json:"base"`
+		Modified   float64 `json:"modified"`
+		Ancestor   float64 `json:"ancestor"`
+		Descendant float64 `json:"descendant"`
+	}
+
+	// Response for getmempoolentry (and the values of the verbose ancestors/descendants maps)
+	MempoolEntry struct {
+		VSize           int64            `json:"vsize"`
+		Weight          int64            `json:"weight"`
+		Time            int64            `json:"time"`
+		Height          int64            `json:"height"`
+		DescendantCount int64            `json:"descendantcount"`
+		DescendantSize  int64            `json:"descendantsize"`
+		AncestorCount   int64            `json:"ancestorcount"`
+		AncestorSize    int64            `json:"ancestorsize"`
+		WitnessTxID     string           `json:"wtxid"`
+		Fees            MempoolEntryFees `json:"fees"`
+		Depends         []string         `json:"depends"`
+		SpentBy         []string         `json:"spentby"`
+		Replaceable     bool             `json:"bip125-replaceable"`
+		Unbroadcast     bool             `json:"unbroadcast"`
+	}
+
+	// Fees of a transaction that would be accepted (in BTC and BTC/kvB)
+	MempoolAcceptFees struct {
+		Base             float64 `json:"base"`
+		EffectiveFeeRate float64 `json:"effective-feerate,omitempty"`
+	}
+
+	// Result for each transaction passed to testmempoolaccept
+	MempoolAcceptResult struct {
+		TransactionID string             `json:"txid"`
+		WitnessTxID   string             `json:"wtxid"`
+		PackageError  string             `json:"package-error,omitempty"`
+		Allowed       bool               `json:"allowed"`
+		VSize         int64              `json:"vsize,omitempty"`
+		Fees          *MempoolAcceptFees `json:"fees,omitempty"`
+		RejectReason  string             `json:"reject-reason,omitempty"`
+	}
+)
+
+// GetMempoolEntry
+func (b Bitcoind) GetMempoolEntry(ctx context.Context, txid string) (entry MempoolEntry, err error) {
+	res, err := b.sendRequest(ctx, MethodGetMempoolEntry, txid)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &entry)
+
+	return
+}
+
+// GetRawMempoolVerbose returns every mempool entry keyed by txid
+func (b Bitcoind) GetRawMempoolVerbose(ctx context.Context) (entries map[string]MempoolEntry, err error) {
+	res, err := b.sendRequest(ctx, MethodGetMempoolContents, true)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &entries)
+
+	return
+}
+
+// GetMempoolAncestors (verbose, keyed by txid)
+func (b Bitcoind) GetMempoolAncestors(ctx context.Context, txid string) (ancestors map[string]MempoolEntry, err error) {
+	res, err := b.sendRequest(ctx, MethodGetMempoolAncestors, txid, true)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &ancestors)
+
+	return
+}
+
+// GetMempoolDescendants (verbose, keyed by txid)
+func (b Bitcoind) GetMempoolDescendants(ctx context.Context, txid string) (descendants map[string]MempoolEntry, err error) {
+	res, err := b.sendRequest(ctx, MethodGetMempoolDescendants, txid, true)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &descendants)
+
+	return
+}
+
+// TestMempoolAccept checks if transactions would be accepted without broadcasting them.
+// maxFeeRate (BTC/kvB) works the same as for PushTransaction.
+func (b Bitcoind) TestMempoolAccept(ctx context.Context, rawTxs []string, maxFeeRate float64) (results []MempoolAcceptResult, err error) {
+	params := []interface{}{rawTxs}
+	if maxFeeRate > 0 {
+		params = append(params, maxFeeRate)
+	}
+	res, err := b.sendRequest(ctx, MethodTestMempoolAccept, params...)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &results)
+
+	return
+}
@@ -0,0 +1,82 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A backend answering every call with result, recording the last request
+func recordingNode(result string, last *requestBody) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(last)
+		w.Write([]byte(`{"result":` + result + `,"error":null,"id":null}`))
+	}))
+}
+
+func TestMempoolRelatives(t *testing.T) {
+	var req requestBody
+	server := recordingNode(`{"t1":{"vsize":141,"wtxid":"w1","fees":{"base":0.00000282,"ancestor":0.00000564},"depends":[],"bip125-replaceable":true}}`, &req)
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+
+	for method, call := range map[string]func(context.Context, string) (map[string]MempoolEntry, error){
+		MethodGetMempoolAncestors:   client.GetMempoolAncestors,
+		MethodGetMempoolDescendants: client.GetMempoolDescendants,
+	} {
+		entries, err := call(context.Background(), "t2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// verbose, keyed by txid
+		if req.Method != method || fmt.Sprint(req.Params) != "[t2 true]" {
+			t.Errorf("%s: sent %s %v", method, req.Method, req.Params)
+		}
+		entry := entries["t1"]
+		if len(entries) != 1 || entry.VSize != 141 || entry.WitnessTxID != "w1" || entry.Fees.Ancestor != 0.00000564 || !entry.Replaceable {
+			t.Errorf("%s: got %+v", method, entries)
+		}
+	}
+}
+
+func TestMaxFeeRateParam(t *testing.T) {
+	var req requestBody
+	server := recordingNode(`[{"txid":"t1","wtxid":"w1","allowed":true,"vsize":141,"fees":{"base":0.00000282}}]`, &req)
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+	ctx := context.Background()
+
+	// bitcoind's default applies without a maxfeerate
+	results, err := client.TestMempoolAccept(ctx, []string{"aa"}, 0)
+	if err != nil || fmt.Sprint(req.Params) != "[[aa]]" {
+		t.Errorf("sent %v, %v", req.Params, err)
+	}
+	if len(results) != 1 || !results[0].Allowed || results[0].Fees == nil || results[0].Fees.Base != 0.00000282 {
+		t.Errorf("got %+v", results)
+	}
+	client.TestMempoolAccept(ctx, []string{"aa", "bb"}, 0.5)
+	if fmt.Sprint(req.Params) != "[[aa bb] 0.5]" {
+		t.Errorf("sent %v", req.Params)
+	}
+	client.PushTransaction(ctx, "aa", 0)
+	if req.Method != MethodBroadcastTx || fmt.Sprint(req.Params) != "[aa]" {
+		t.Errorf("sent %s %v", req.Method, req.Params)
+	}
+	client.PushTransaction(ctx, "aa", 0.5)
+	if fmt.Sprint(req.Params) != "[aa 0.5]" {
+		t.Errorf("sent %v", req.Params)
+	}
+}
@@ -0,0 +1,61 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Network traffic
+
+Byte counters of all P2P traffic since bitcoind started, and the state of the
+upload target (-maxuploadtarget).
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/getnettotals.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	MethodGetNetTotals = "getnettotals"
+)
+
+type (
+	// Response for getnettotals
+	NetTotalsResponse struct {
+		TotalBytesRecv int64        `json:"totalbytesrecv"`
+		TotalBytesSent int64        `json:"totalbytessent"`
+		TimeMillis     int64        `json:"timemillis"`
+		UploadTarget   UploadTarget `json:"uploadtarget"`
+	}
+
+	// State of -maxuploadtarget, Target is 0 when there is no target
+	UploadTarget struct {
+		TimeFrame             int64 `json:"timeframe"`
+		Target                int64 `json:"target"`
+		TargetReached         bool  `json:"target_reached"`
+		ServeHistoricalBlocks bool  `json:"serve_historical_blocks"`
+		BytesLeftInCycle      int64 `json:"bytes_left_in_cycle"`
+		TimeLeftInCycle       int64 `json:"time_left_in_cycle"`
+	}
+)
+
+// GetNetTotals gets the P2P traffic counters
+func (b Bitcoind) GetNetTotals(ctx context.Context) (nettotals NetTotalsResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetNetTotals)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &nettotals)
+
+	return
+}
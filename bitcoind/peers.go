@@ -0,0 +1,117 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Peer management
+
+Calls that change the peers of the node (connect, disconnect, ban). These
+change the state of bitcoind, so only expose them to trusted users.
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/addnode.html
+https://developer.bitcoin.org/reference/rpc/disconnectnode.html
+https://developer.bitcoin.org/reference/rpc/setban.html
+https://developer.bitcoin.org/reference/rpc/listbanned.html
+https://developer.bitcoin.org/reference/rpc/setnetworkactive.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	MethodAddNode          = "addnode"
+	MethodDisconnectNode   = "disconnectnode"
+	MethodSetBan           = "setban"
+	MethodListBanned       = "listbanned"
+	MethodClearBanned      = "clearbanned"
+	MethodSetNetworkActive = "setnetworkactive"
+
+	// Commands for addnode
+	AddNodeAdd    = "add"
+	AddNodeRemove = "remove"
+	AddNodeOneTry = "onetry"
+	// Commands for setban
+	SetBanAdd    = "add"
+	SetBanRemove = "remove"
+)
+
+type (
+	// Response for listbanned
+	BannedPeer struct {
+		Address       string `json:"address"`
+		BanCreated    int64  `json:"ban_created"`
+		BannedUntil   int64  `json:"banned_until"`
+		BanDuration   int64  `json:"ban_duration,omitempty"`   // bitcoind 0.21+
+		TimeRemaining int64  `json:"time_remaining,omitempty"` // bitcoind 0.21+
+	}
+)
+
+// AddNode adds or removes a node from the addnode list, or tries a connection once (see AddNode*)
+func (b Bitcoind) AddNode(ctx context.Context, node, command string) (err error) {
+	_, err = b.sendRequest(ctx, MethodAddNode, node, command)
+
+	return
+}
+
+// DisconnectNode disconnects a peer by address, or by node id if address is empty
+func (b Bitcoind) DisconnectNode(ctx context.Context, address string, nodeID int64) (err error) {
+	if address != "" {
+		_, err = b.sendRequest(ctx, MethodDisconnectNode, address)
+		return
+	}
+	_, err = b.sendRequest(ctx, MethodDisconnectNode, "", nodeID)
+
+	return
+}
+
+// SetBan bans or unbans an IP/subnet (see SetBan*).
+// banTime is in seconds (0 for the bitcoind default of 24h), or a unix time if absolute.
+func (b Bitcoind) SetBan(ctx context.Context, subnet, command string, banTime int64, absolute bool) (err error) {
+	if command == SetBanRemove {
+		_, err = b.sendRequest(ctx, MethodSetBan, subnet, command)
+		return
+	}
+	_, err = b.sendRequest(ctx, MethodSetBan, subnet, command, banTime, absolute)
+
+	return
+}
+
+// ListBanned lists all banned IPs/subnets
+func (b Bitcoind) ListBanned(ctx context.Context) (banned []BannedPeer, err error) {
+	res, err := b.sendRequest(ctx, MethodListBanned)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &banned)
+
+	return
+}
+
+// ClearBanned removes all bans
+func (b Bitcoind) ClearBanned(ctx context.Context) (err error) {
+	_, err = b.sendRequest(ctx, MethodClearBanned)
+
+	return
+}
+
+// SetNetworkActive enables or disables all P2P network activity, returns the new state
+func (b Bitcoind) SetNetworkActive(ctx context.Context, active bool) (state bool, err error) {
+	res, err := b.sendRequest(ctx, MethodSetNetworkActive, active)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &state)
+
+	return
+}
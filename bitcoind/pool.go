@@ -0,0 +1,532 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Backend pool
+
+Pool spreads the BitcoinClient interface over one or more bitcoind backends
+(one per [[bitcoind]] entry). Every backend is asked for `getblockcount` on an
+interval and calls go to the healthy backend with the most blocks. A backend
+that can't be reached during a call is marked down straight away so the next
+call already fails over. Peer management calls are the exception, they change
+the node itself and so go to every backend, with a result per backend.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/common"
+)
+
+const (
+	DefaultHealthCheckInterval = 15 * time.Second
+)
+
+type (
+	Pool struct {
+		backends []*backend
+		interval time.Duration
+
+		mu     sync.RWMutex
+		active int // index into backends
+
+		// UTXO set scans stay on the backend they were started on
+		scanner *Scanner
+	}
+
+	backend struct {
+		name   string
+		client Bitcoind
+
+		mu        sync.RWMutex
+		healthy   bool
+		height    int64
+		lastCheck time.Time
+		lastErr   error
+	}
+
+	// Status of a single backend, as returned by /api/backends
+	BackendStatus struct {
+		Name      string    `json:"name"`
+		URL       string    `json:"url"`
+		Active    bool      `json:"active"`
+		Healthy   bool      `json:"healthy"`
+		Height    int64     `json:"height"`
+		Lag       int64     `json:"lag"` // blocks behind the most synced backend
+		LastCheck time.Time `json:"last_check"`
+		Error     string    `json:"error,omitempty"`
+	}
+
+	// Result of a call on one backend (see Each)
+	BackendResult struct {
+		Backend string      `json:"backend"`
+		Result  interface{} `json:"result,omitempty"`
+		Error   string      `json:"error,omitempty"`
+		Err     error       `json:"-"`
+	}
+)
+
+// NewPool sets up a client per backend. Unlike New it does not fail when a
+// backend is down, that is left to the health checks.
+func NewPool(confs []common.Bitcoind, interval time.Duration) (*Pool, error) {
+	if len(confs) == 0 {
+		confs = []common.Bitcoind{{}}
+	}
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	p := &Pool{interval: interval}
+	p.scanner = NewScanner(p.client)
+	for i, conf := range confs {
+		client, err := newClient(conf)
+		if err != nil {
+			return nil, fmt.Errorf("bitcoind backend %d: %w", i, err)
+		}
+		be := &backend{name: conf.Name, client: client}
+		if be.name == "" {
+			be.name = client.url
+		}
+		be.client.onFailure = be.markDown
+		p.backends = append(p.backends, be)
+		fmt.Printf("Adding bitcoind backend %s (%s)\n", be.name, client.url)
+	}
+
+	return p, nil
+}
+
+// Run health checks all backends every interval until ctx is done
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.CheckHealth(ctx)
+		}
+	}
+}
+
+// CheckHealth asks every backend for its block count and picks the active one
+func (p *Pool) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, be := range p.backends {
+		wg.Add(1)
+		go func(be *backend) {
+			defer wg.Done()
+			be.check(ctx, p.interval)
+		}(be)
+	}
+	wg.Wait()
+	p.selectActive()
+}
+
+// selectActive switches to the most synced healthy backend.
+// The current backend is kept on a tie to avoid flapping between nodes.
+func (p *Pool) selectActive() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := -1
+	var bestHeight int64
+	for i, be := range p.backends {
+		healthy, height := be.state()
+		if !healthy {
+			continue
+		}
+		if best == -1 || height > bestHeight || (height == bestHeight && i == p.active) {
+			best, bestHeight = i, height
+		}
+	}
+	if best == -1 || best == p.active {
+		return
+	}
+	fmt.Printf("Switching bitcoind backend to %s (height %d)\n", p.backends[best].name, bestHeight)
+	p.active = best
+}
+
+// client returns the client of the backend calls should go to right now
+func (p *Pool) client() Bitcoind {
+	return p.activeBackend().client
+}
+
+// activeBackend returns the backend calls should go to right now.
+// If the active backend went down since the last health check the next healthy
+// one is used, if none are healthy the active one is tried anyway.
+func (p *Pool) activeBackend() *backend {
+	p.mu.RLock()
+	active := p.active
+	p.mu.RUnlock()
+
+	if healthy, _ := p.backends[active].state(); healthy {
+		return p.backends[active]
+	}
+	p.selectActive()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.backends[p.active]
+}
+
+// Status lists all backends and how far they are behind the most synced one
+func (p *Pool) Status() (statuses []BackendStatus) {
+	p.mu.RLock()
+	active := p.active
+	p.mu.RUnlock()
+
+	var maxHeight int64
+	for i, be := range p.backends {
+		be.mu.RLock()
+		status := BackendStatus{
+			Name:      be.name,
+			URL:       be.client.url,
+			Active:    i == active,
+			Healthy:   be.healthy,
+			Height:    be.height,
+			LastCheck: be.lastCheck,
+		}
+		if be.lastErr != nil {
+			status.Error = be.lastErr.Error()
+		}
+		be.mu.RUnlock()
+		if status.Healthy && status.Height > maxHeight {
+			maxHeight = status.Height
+		}
+		statuses = append(statuses, status)
+	}
+	for i := range statuses {
+		statuses[i].Lag = maxHeight - statuses[i].Height
+	}
+
+	return
+}
+
+// Each makes a call on every backend at once, healthy or not, and returns
+// the results in the order of the backends
+func (p *Pool) Each(ctx context.Context, call func(context.Context, Bitcoind) (interface{}, error)) []BackendResult {
+	results := make([]BackendResult, len(p.backends))
+	var wg sync.WaitGroup
+	for i, be := range p.backends {
+		wg.Add(1)
+		go func(i int, be *backend) {
+			defer wg.Done()
+			result, err := call(ctx, be.client)
+			results[i] = BackendResult{Backend: be.name, Result: result, Err: err}
+			if err != nil {
+				results[i].Result, results[i].Error = nil, err.Error()
+			}
+		}(i, be)
+	}
+	wg.Wait()
+	return results
+}
+
+func (be *backend) check(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	height, err := be.client.BlockCount(ctx)
+
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.lastCheck = time.Now()
+	be.lastErr = err
+	if err != nil {
+		if be.healthy {
+			fmt.Printf("bitcoind backend %s is down: %s\n", be.name, err)
+		}
+		be.healthy = false
+		return
+	}
+	be.healthy = true
+	be.height = height
+}
+
+func (be *backend) markDown(err error) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	if be.healthy {
+		fmt.Printf("bitcoind backend %s is down: %s\n", be.name, err)
+	}
+	be.healthy = false
+	be.lastErr = err
+}
+
+func (be *backend) state() (healthy bool, height int64) {
+	be.mu.RLock()
+	defer be.mu.RUnlock()
+	return be.healthy, be.height
+}
+
+// BitcoinClient methods, each one goes to the currently active backend
+
+func (p *Pool) GetBlockStats(ctx context.Context, height int64) (BlockStatsResponse, error) {
+	return p.client().GetBlockStats(ctx, height)
+}
+
+func (p *Pool) BlockCount(ctx context.Context) (int64, error) {
+	return p.client().BlockCount(ctx)
+}
+
+func (p *Pool) GetPeerInfo(ctx context.Context) ([]PeerInfo, error) {
+	return p.client().GetPeerInfo(ctx)
+}
+
+func (p *Pool) BlockchainInfo(ctx context.Context) (BlockchainInfoResponse, error) {
+	return p.client().BlockchainInfo(ctx)
+}
+
+func (p *Pool) NetworkInfo(ctx context.Context) (NetworkInfoResponse, error) {
+	return p.client().NetworkInfo(ctx)
+}
+
+func (p *Pool) GetTransactionInfo(ctx context.Context, txid string) (VerboseTransactionInfo, error) {
+	return p.client().GetTransactionInfo(ctx, txid)
+}
+
+func (p *Pool) GetMempoolContents(ctx context.Context) ([]string, error) {
+	return p.client().GetMempoolContents(ctx)
+}
+
+func (p *Pool) GetMempoolInfo(ctx context.Context) (MempoolInfoResponse, error) {
+	return p.client().GetMempoolInfo(ctx)
+}
+
+func (p *Pool) PushTransaction(ctx context.Context, hex string, maxFeeRate float64) (string, error) {
+	return p.client().PushTransaction(ctx, hex, maxFeeRate)
+}
+
+func (p *Pool) TestMempoolAccept(ctx context.Context, rawTxs []string, maxFeeRate float64) ([]MempoolAcceptResult, error) {
+	return p.client().TestMempoolAccept(ctx, rawTxs, maxFeeRate)
+}
+
+func (p *Pool) GetBestBlockHash(ctx context.Context) (string, error) {
+	return p.client().GetBestBlockHash(ctx)
+}
+
+func (p *Pool) GetBlockHashByHeight(ctx context.Context, height int64) (string, error) {
+	return p.client().GetBlockHashByHeight(ctx, height)
+}
+
+func (p *Pool) GetBlock(ctx context.Context, hash string) (BitcoinBlockResponse, error) {
+	return p.client().GetBlock(ctx, hash)
+}
+
+func (p *Pool) GetBlockWithTransactions(ctx context.Context, hash string) (BitcoinBlockVerboseResponse, error) {
+	return p.client().GetBlockWithTransactions(ctx, hash)
+}
+
+func (p *Pool) GetBlockHex(ctx context.Context, hash string) (string, error) {
+	return p.client().GetBlockHex(ctx, hash)
+}
+
+func (p *Pool) GetMiningInfo(ctx context.Context) (MiningInfoResponse, error) {
+	return p.client().GetMiningInfo(ctx)
+}
+
+func (p *Pool) SendBatch(ctx context.Context, calls []BatchRequest) ([]BatchResult, error) {
+	return p.client().SendBatch(ctx, calls)
+}
+
+func (p *Pool) GetBlockRange(ctx context.Context, from, to int64) ([]BitcoinBlockResponse, error) {
+	return p.client().GetBlockRange(ctx, from, to)
+}
+
+// Wallets live on a single node, so these only work as expected when
+// all backends share the wallet (or there is only one backend)
+
+func (p *Pool) CreateWallet(ctx context.Context, name string, descriptors bool) (WalletResponse, error) {
+	return p.client().CreateWallet(ctx, name, descriptors)
+}
+
+func (p *Pool) LoadWallet(ctx context.Context, name string) (WalletResponse, error) {
+	return p.client().LoadWallet(ctx, name)
+}
+
+func (p *Pool) ImportAddress(ctx context.Context, walletName, address, label string, rescan bool) error {
+	return p.client().ImportAddress(ctx, walletName, address, label, rescan)
+}
+
+func (p *Pool) ImportDescriptors(ctx context.Context, walletName string, descriptors []ImportDescriptorRequest) ([]ImportDescriptorResult, error) {
+	return p.client().ImportDescriptors(ctx, walletName, descriptors)
+}
+
+func (p *Pool) ListReceivedByAddress(ctx context.Context, walletName string, minConf int64, includeEmpty bool, address string) ([]ReceivedByAddress, error) {
+	return p.client().ListReceivedByAddress(ctx, walletName, minConf, includeEmpty, address)
+}
+
+func (p *Pool) ListTransactions(ctx context.Context, walletName string, count, skip int64) ([]WalletTransaction, error) {
+	return p.client().ListTransactions(ctx, walletName, count, skip)
+}
+
+func (p *Pool) ListAddressTransactions(ctx context.Context, walletName, address string, count, skip int64) ([]WalletTransaction, error) {
+	return p.client().ListAddressTransactions(ctx, walletName, address, count, skip)
+}
+
+func (p *Pool) GetNewAddress(ctx context.Context, walletName, label, addressType string) (string, error) {
+	return p.client().GetNewAddress(ctx, walletName, label, addressType)
+}
+
+func (p *Pool) GetMempoolEntry(ctx context.Context, txid string) (MempoolEntry, error) {
+	return p.client().GetMempoolEntry(ctx, txid)
+}
+
+func (p *Pool) GetMempoolAncestors(ctx context.Context, txid string) (map[string]MempoolEntry, error) {
+	return p.client().GetMempoolAncestors(ctx, txid)
+}
+
+func (p *Pool) GetMempoolDescendants(ctx context.Context, txid string) (map[string]MempoolEntry, error) {
+	return p.client().GetMempoolDescendants(ctx, txid)
+}
+
+func (p *Pool) EstimateSmartFee(ctx context.Context, target int64, mode string) (SmartFeeResponse, error) {
+	return p.client().EstimateSmartFee(ctx, target, mode)
+}
+
+func (p *Pool) EstimateSmartFees(ctx context.Context, targets []int64, mode string) ([]SmartFeeResponse, error) {
+	return p.client().EstimateSmartFees(ctx, targets, mode)
+}
+
+func (p *Pool) EstimateFees(ctx context.Context, targets []int64) (FeeEstimates, error) {
+	return p.client().EstimateFees(ctx, targets)
+}
+
+func (p *Pool) DecodeRawTransaction(ctx context.Context, hex string) (VerboseTransactionInfo, error) {
+	return p.client().DecodeRawTransaction(ctx, hex)
+}
+
+func (p *Pool) DecodeScript(ctx context.Context, hex string) (DecodeScriptResponse, error) {
+	return p.client().DecodeScript(ctx, hex)
+}
+
+func (p *Pool) DecodePSBT(ctx context.Context, psbt string) (DecodePSBTResponse, error) {
+	return p.client().DecodePSBT(ctx, psbt)
+}
+
+func (p *Pool) AnalyzePSBT(ctx context.Context, psbt string) (AnalyzePSBTResponse, error) {
+	return p.client().AnalyzePSBT(ctx, psbt)
+}
+
+func (p *Pool) GetBlockHeader(ctx context.Context, hash string) (BlockHeaderResponse, error) {
+	return p.client().GetBlockHeader(ctx, hash)
+}
+
+func (p *Pool) GetBlockHeaderHex(ctx context.Context, hash string) (string, error) {
+	return p.client().GetBlockHeaderHex(ctx, hash)
+}
+
+func (p *Pool) GetChainTips(ctx context.Context) ([]ChainTip, error) {
+	return p.client().GetChainTips(ctx)
+}
+
+func (p *Pool) GetHeaders(ctx context.Context, from, count int64) ([]BlockHeaderResponse, error) {
+	return p.client().GetHeaders(ctx, from, count)
+}
+
+func (p *Pool) GetHeadersHex(ctx context.Context, from, count int64) ([]string, error) {
+	return p.client().GetHeadersHex(ctx, from, count)
+}
+
+func (p *Pool) GetTxOut(ctx context.Context, txid string, vout int64, includeMempool bool) (*TxOutResponse, error) {
+	return p.client().GetTxOut(ctx, txid, vout, includeMempool)
+}
+
+func (p *Pool) StartScan(ctx context.Context, descriptors []string) (ScanStatus, error) {
+	return p.scanner.Start(ctx, descriptors)
+}
+
+func (p *Pool) ScanStatus(ctx context.Context) (ScanStatus, bool) {
+	return p.scanner.Status(ctx)
+}
+
+func (p *Pool) AbortScan(ctx context.Context) (ScanStatus, error) {
+	return p.scanner.Abort(ctx)
+}
+
+func (p *Pool) GetTransactions(ctx context.Context, txids []string) (map[string]VerboseTransactionInfo, error) {
+	return p.client().GetTransactions(ctx, txids)
+}
+
+func (p *Pool) GetRawMempoolVerbose(ctx context.Context) (map[string]MempoolEntry, error) {
+	return p.client().GetRawMempoolVerbose(ctx)
+}
+
+func (p *Pool) GetBlockStatsSelected(ctx context.Context, hashOrHeight interface{}, stats []string) (BlockStats, error) {
+	return p.client().GetBlockStatsSelected(ctx, hashOrHeight, stats)
+}
+
+func (p *Pool) GetBlockStatsRange(ctx context.Context, from, to int64, stats []string) ([]BlockStats, error) {
+	return p.client().GetBlockStatsRange(ctx, from, to, stats)
+}
+
+// Peer management calls change the state of a node rather than read the
+// chain, so they go to every backend (see Each)
+
+func (p *Pool) AddNode(ctx context.Context, node, command string) []BackendResult {
+	return p.Each(ctx, func(ctx context.Context, client Bitcoind) (interface{}, error) {
+		return nil, client.AddNode(ctx, node, command)
+	})
+}
+
+func (p *Pool) DisconnectNode(ctx context.Context, address string, nodeID int64) []BackendResult {
+	return p.Each(ctx, func(ctx context.Context, client Bitcoind) (interface{}, error) {
+		return nil, client.DisconnectNode(ctx, address, nodeID)
+	})
+}
+
+func (p *Pool) SetBan(ctx context.Context, subnet, command string, banTime int64, absolute bool) []BackendResult {
+	return p.Each(ctx, func(ctx context.Context, client Bitcoind) (interface{}, error) {
+		return nil, client.SetBan(ctx, subnet, command, banTime, absolute)
+	})
+}
+
+func (p *Pool) ListBanned(ctx context.Context) []BackendResult {
+	return p.Each(ctx, func(ctx context.Context, client Bitcoind) (interface{}, error) {
+		return client.ListBanned(ctx)
+	})
+}
+
+func (p *Pool) ClearBanned(ctx context.Context) []BackendResult {
+	return p.Each(ctx, func(ctx context.Context, client Bitcoind) (interface{}, error) {
+		return nil, client.ClearBanned(ctx)
+	})
+}
+
+func (p *Pool) SetNetworkActive(ctx context.Context, active bool) []BackendResult {
+	return p.Each(ctx, func(ctx context.Context, client Bitcoind) (interface{}, error) {
+		return client.SetNetworkActive(ctx, active)
+	})
+}
+
+func (p *Pool) GetNetTotals(ctx context.Context) (NetTotalsResponse, error) {
+	return p.client().GetNetTotals(ctx)
+}
+
+// BackendNetTotals is GetNetTotals with the name of the backend that answered,
+// as the counters of different nodes can't be compared
+func (p *Pool) BackendNetTotals(ctx context.Context) (string, NetTotalsResponse, error) {
+	be := p.activeBackend()
+	totals, err := be.client.GetNetTotals(ctx)
+	return be.name, totals, err
+}
+
+func (p *Pool) StreamBlockHex(ctx context.Context, hash string) (io.ReadCloser, error) {
+	return p.client().StreamBlockHex(ctx, hash)
+}
+
+func (p *Pool) StreamRawTransactionHex(ctx context.Context, txid string) (io.ReadCloser, error) {
+	return p.client().StreamRawTransactionHex(ctx, txid)
+}
@@ -0,0 +1,198 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/common"
+)
+
+// A backend answering every call with body, counting the calls
+func staticNode(body string, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Write([]byte(body))
+	}))
+}
+
+func backendConf(name string, server *httptest.Server) common.Bitcoind {
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNumber, _ := strconv.ParseInt(port, 10, 64)
+	return common.Bitcoind{Name: name, Host: host, Port: portNumber, User: "user", Pass: "pass"}
+}
+
+func TestPeerCallsGoToEveryBackend(t *testing.T) {
+	var calls1, calls2 int
+	node1 := staticNode(`{"result":[{"address":"192.0.2.1/32"}],"error":null,"id":null}`, &calls1)
+	defer node1.Close()
+	node2 := staticNode(`{"result":null,"error":{"code":-1,"message":"broken"},"id":null}`, &calls2)
+	defer node2.Close()
+	pool, err := NewPool([]common.Bitcoind{backendConf("node1", node1), backendConf("node2", node2)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both are down as far as the pool knows, peer calls are still made
+	results := pool.ListBanned(context.Background())
+
+	if len(results) != 2 || calls1 != 1 || calls2 != 1 {
+		t.Fatalf("%d results, %d and %d calls, want one per backend", len(results), calls1, calls2)
+	}
+	if results[0].Backend != "node1" || results[0].Err != nil || results[0].Error != "" {
+		t.Errorf("node1: got %+v", results[0])
+	}
+	if banned, ok := results[0].Result.([]BannedPeer); !ok || len(banned) != 1 || banned[0].Address != "192.0.2.1/32" {
+		t.Errorf("node1: result %#v", results[0].Result)
+	}
+	var rpcErr *RPCError
+	if results[1].Backend != "node2" || !errors.As(results[1].Err, &rpcErr) || rpcErr.Code != -1 || results[1].Error == "" {
+		t.Errorf("node2: got %+v", results[1])
+	}
+	if results[1].Result != nil {
+		t.Errorf("node2: result %#v next to an error", results[1].Result)
+	}
+}
+
+// A backend at height, answering getblockcount (or anything) after delay
+type heightNode struct {
+	mu     sync.Mutex
+	height int64
+	delay  time.Duration
+	stop   chan struct{} // closed at the end of the test
+}
+
+func (n *heightNode) set(height int64, delay time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.height, n.delay = height, delay
+}
+
+func (n *heightNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	height, delay := n.height, n.delay
+	n.mu.Unlock()
+	select {
+	case <-time.After(delay):
+	case <-n.stop:
+		return
+	}
+	w.Write([]byte(`{"result":` + strconv.FormatInt(height, 10) + `,"error":null,"id":null}`))
+}
+
+// A pool of a backend per node, with the given read timeout
+func testPool(t *testing.T, readTimeout time.Duration, nodes ...*heightNode) (pool *Pool, cleanup func()) {
+	var confs []common.Bitcoind
+	var servers []*httptest.Server
+	for i, node := range nodes {
+		node.stop = make(chan struct{})
+		server := httptest.NewServer(node)
+		servers = append(servers, server)
+		confs = append(confs, backendConf("node"+strconv.Itoa(i+1), server))
+	}
+	pool, err := NewPool(confs, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, be := range pool.backends {
+		be.client.readTimeout = readTimeout
+	}
+	return pool, func() {
+		for i, server := range servers {
+			close(nodes[i].stop)
+			server.Close()
+		}
+	}
+}
+
+func activeBackend(pool *Pool) string {
+	for _, status := range pool.Status() {
+		if status.Active {
+			return status.Name
+		}
+	}
+	return ""
+}
+
+func TestSelectActive(t *testing.T) {
+	node1, node2 := &heightNode{height: 100}, &heightNode{height: 101}
+	pool, cleanup := testPool(t, time.Second, node1, node2)
+	defer cleanup()
+	ctx := context.Background()
+
+	pool.CheckHealth(ctx)
+	if got := activeBackend(pool); got != "node2" {
+		t.Fatalf("got %s, want the most synced node2", got)
+	}
+	// a tie keeps the current backend
+	node1.set(101, 0)
+	pool.CheckHealth(ctx)
+	if got := activeBackend(pool); got != "node2" {
+		t.Errorf("on a tie: got %s, want node2", got)
+	}
+	node1.set(102, 0)
+	pool.CheckHealth(ctx)
+	if got := activeBackend(pool); got != "node1" {
+		t.Errorf("got %s, want node1 once it is ahead", got)
+	}
+	// a backend that is down is skipped, however far it got
+	node1.set(200, time.Minute)
+	pool.interval = 50 * time.Millisecond
+	pool.CheckHealth(ctx)
+	if got := activeBackend(pool); got != "node2" {
+		t.Errorf("got %s, want node2 while node1 is down", got)
+	}
+}
+
+func TestFailoverOnReadTimeout(t *testing.T) {
+	node1, node2 := &heightNode{height: 101}, &heightNode{height: 100}
+	pool, cleanup := testPool(t, 50*time.Millisecond, node1, node2)
+	defer cleanup()
+	ctx := context.Background()
+	pool.CheckHealth(ctx)
+
+	// node1 stops answering: the call that times out marks it down
+	node1.set(101, time.Minute)
+	if _, err := pool.BlockCount(ctx); err == nil {
+		t.Fatal("no error from a backend that timed out")
+	}
+	height, err := pool.BlockCount(ctx)
+	if err != nil || height != 100 {
+		t.Fatalf("got %d, %v, want 100 from node2", height, err)
+	}
+	if got := activeBackend(pool); got != "node2" {
+		t.Errorf("got %s, want node2", got)
+	}
+}
+
+func TestCallerCancellationKeepsBackendUp(t *testing.T) {
+	node1, node2 := &heightNode{height: 101}, &heightNode{height: 100}
+	pool, cleanup := testPool(t, time.Second, node1, node2)
+	defer cleanup()
+	pool.CheckHealth(context.Background())
+
+	node1.set(101, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.BlockCount(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the deadline of the caller", err)
+	}
+	if healthy, _ := pool.backends[0].state(); !healthy {
+		t.Error("node1 marked down when the caller gave up")
+	}
+}
@@ -0,0 +1,164 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Streaming raw blocks and transactions
+
+sendRequest reads the whole response before decoding it, which for a raw block
+(up to 8 MB of hex) means holding it in memory several times. Here the
+response is read only up to the start of the hex "result" string, errors are
+returned before that, and the hex is then handed out as it comes in.
+
+bitcoind writes "result" before "error" and "id", anything else falls back to
+decoding the whole response.
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/getblock.html
+https://developer.bitcoin.org/reference/rpc/getrawtransaction.html
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	resultPrefix = []byte(`{"result":`)
+)
+
+type (
+	// The hex string of a response, read from the response body as it comes in
+	hexStream struct {
+		body   io.Closer
+		r      *bufio.Reader
+		cancel context.CancelFunc
+		done   bool
+	}
+)
+
+// StreamBlockHex streams the serialized block (getblock verbosity 0) as hex.
+// Close the stream when done, readTimeout applies to the whole transfer.
+func (b Bitcoind) StreamBlockHex(ctx context.Context, hash string) (io.ReadCloser, error) {
+	return b.streamHex(ctx, MethodGetBlock, hash, BlockVerbosityHex)
+}
+
+// StreamRawTransactionHex streams a serialized transaction as hex (needs -txindex for confirmed transactions)
+func (b Bitcoind) StreamRawTransactionHex(ctx context.Context, txid string) (io.ReadCloser, error) {
+	return b.streamHex(ctx, MethodGetRawTransaction, txid, false)
+}
+
+// streamHex calls a method that returns a hex string and returns a reader for
+// that string once bitcoind started sending it
+func (b Bitcoind) streamHex(ctx context.Context, method string, params ...interface{}) (stream io.ReadCloser, err error) {
+	reqBody, err := json.Marshal(requestBody{
+		JSONRPC: "1.0",
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return
+	}
+
+	caller, cancel := ctx, func() {}
+	if b.readTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.readTimeout)
+	}
+	res, err := b.roundTrip(ctx, caller, reqBody)
+	if err != nil {
+		cancel()
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = res.Body.Close()
+			cancel()
+		}
+	}()
+
+	r := bufio.NewReaderSize(res.Body, 64*1024)
+	start, err := r.Peek(len(resultPrefix) + 1)
+	if err == nil && bytes.Equal(start[:len(resultPrefix)], resultPrefix) && start[len(resultPrefix)] == '"' {
+		_, err = r.Discard(len(start))
+		if err != nil {
+			return
+		}
+		return &hexStream{body: res.Body, r: r, cancel: cancel}, nil
+	}
+
+	// an error (or an unexpected layout), decode the whole response
+	resBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	var resBody responseBody
+	err = json.Unmarshal(resBytes, &resBody)
+	if err != nil {
+		return
+	}
+	if resBody.Error != nil {
+		return nil, resBody.Error
+	}
+	var result string
+	err = json.Unmarshal(resBody.Result, &result)
+	if err != nil {
+		return nil, fmt.Errorf("%s: unexpected result: %w", method, err)
+	}
+	_ = res.Body.Close()
+	cancel()
+	return ioutil.NopCloser(strings.NewReader(result)), nil
+}
+
+// Read returns hex characters up to the closing quote of the result string
+func (s *hexStream) Read(p []byte) (n int, err error) {
+	if s.done {
+		return 0, io.EOF
+	}
+	for n < len(p) {
+		c, readErr := s.r.ReadByte()
+		if readErr == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+		if readErr != nil {
+			return n, readErr
+		}
+		if c == '"' {
+			s.done = true
+			return n, nil
+		}
+		if !isHexChar(c) {
+			return n, errors.New("unexpected character in hex result")
+		}
+		p[n] = c
+		n++
+		// return what's buffered instead of waiting for more
+		if s.r.Buffered() == 0 {
+			break
+		}
+	}
+	return n, nil
+}
+
+func (s *hexStream) Close() error {
+	s.cancel()
+	return s.body.Close()
+}
+
+func isHexChar(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
@@ -0,0 +1,77 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A backend answering with body, in two writes
+func rawNode(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		half := len(body) / 2
+		w.Write([]byte(body[:half]))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(body[half:]))
+	}))
+}
+
+func streamBody(t *testing.T, body string) (string, error) {
+	server := rawNode(body)
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+	stream, err := client.StreamBlockHex(context.Background(), "hash")
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	hex, err := ioutil.ReadAll(stream)
+	return string(hex), err
+}
+
+func TestStreamHex(t *testing.T) {
+	block := strings.Repeat("00ff", 5000)
+	for _, body := range []string{
+		`{"result":"` + block + `","error":null,"id":null}`,
+		// another layout is decoded as a whole
+		`{"error":null,"id":null,"result":"` + block + `"}`,
+	} {
+		got, err := streamBody(t, body)
+		if err != nil || got != block {
+			t.Errorf("%.30s...: got %d hex characters, %v, want %d", body, len(got), err, len(block))
+		}
+	}
+}
+
+func TestStreamHexErrors(t *testing.T) {
+	var rpcErr *RPCError
+	_, err := streamBody(t, `{"result":null,"error":{"code":-5,"message":"Block not found"},"id":null}`)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPCInvalidAddressOrKey {
+		t.Errorf("error body: got %v, want the RPC error", err)
+	}
+	// bitcoind went away in the middle of the hex
+	if _, err := streamBody(t, `{"result":"00ff00`); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated body: got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if _, err := streamBody(t, `{"result":"00zz","error":null,"id":null}`); err == nil {
+		t.Error("no error for a result that isn't hex")
+	}
+	if _, err := streamBody(t, `{"result":12,"error":null,"id":null}`); err == nil {
+		t.Error("no error for a result that isn't a string")
+	}
+}
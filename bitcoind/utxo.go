@@ -0,0 +1,289 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+UTXO set
+
+scantxoutset goes through the whole UTXO set and can take minutes, so it runs
+in the background through a Scanner: one scan at a time, which can be polled
+for progress and aborted. bitcoind itself also only runs one scan at a time.
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/gettxout.html
+https://developer.bitcoin.org/reference/rpc/scantxoutset.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	MethodGetTxOut          = "gettxout"
+	MethodScanTxOutSet      = "scantxoutset"
+	MethodGetDescriptorInfo = "getdescriptorinfo"
+	ScanActionStart         = "start"
+	ScanActionStatus        = "status"
+	ScanActionAbort         = "abort"
+	MaxScanDescriptors      = 100
+	scanProgressTimeout     = 5 * time.Second
+)
+
+type (
+	// Response for gettxout
+	TxOutResponse struct {
+		BestBlock     string          `json:"bestblock"`
+		Confirmations int64           `json:"confirmations"`
+		Value         float64         `json:"value"`
+		ScriptPubKey  ScriptPubKeyObj `json:"scriptPubKey"`
+		Coinbase      bool            `json:"coinbase"`
+	}
+
+	// An unspent output found by scantxoutset
+	ScanUnspent struct {
+		TransactionID string  `json:"txid"`
+		Vout          int64   `json:"vout"`
+		ScriptPubKey  string  `json:"scriptPubKey"`
+		Descriptor    string  `json:"desc"`
+		Amount        float64 `json:"amount"`
+		Height        int64   `json:"height"`
+	}
+
+	// Response for scantxoutset start
+	ScanTxOutSetResponse struct {
+		Success     bool          `json:"success"`
+		TxOuts      int64         `json:"txouts"`
+		Height      int64         `json:"height"`
+		BestBlock   string        `json:"bestblock"`
+		Unspents    []ScanUnspent `json:"unspents"`
+		TotalAmount float64       `json:"total_amount"`
+	}
+
+	// State of the current (or last) scan
+	ScanStatus struct {
+		Running     bool                  `json:"running"`
+		Descriptors []string              `json:"descriptors"`
+		Started     time.Time             `json:"started"`
+		Finished    *time.Time            `json:"finished,omitempty"`
+		Progress    float64               `json:"progress"` // percent
+		Result      *ScanTxOutSetResponse `json:"result,omitempty"`
+		Error       string                `json:"error,omitempty"`
+	}
+
+	Scanner struct {
+		client func() Bitcoind // picks the backend a new scan runs on
+
+		mu      sync.Mutex
+		backend Bitcoind // backend of the running scan
+		cancel  context.CancelFunc
+		done    chan struct{} // closed when the running scan is over
+		status  *ScanStatus
+	}
+)
+
+var (
+	ErrScanInProgress = errors.New("a UTXO set scan is already running")
+	ErrNoScan         = errors.New("no UTXO set scan is running")
+)
+
+// GetTxOut returns nil if the output is spent or doesn't exist.
+// Outputs created and spent in the mempool count when includeMempool is set.
+func (b Bitcoind) GetTxOut(ctx context.Context, txid string, vout int64, includeMempool bool) (txOut *TxOutResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodGetTxOut, txid, vout, includeMempool)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &txOut)
+
+	return
+}
+
+// ScanTxOutSet blocks until the scan is done, without the usual read timeout
+func (b Bitcoind) ScanTxOutSet(ctx context.Context, descriptors []string) (scan ScanTxOutSetResponse, err error) {
+	b.readTimeout = 0
+	res, err := b.sendRequest(ctx, MethodScanTxOutSet, ScanActionStart, descriptors)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &scan)
+
+	return
+}
+
+// ScanTxOutSetProgress returns the progress (percent) of the running scan, and false if there is none
+func (b Bitcoind) ScanTxOutSetProgress(ctx context.Context) (progress float64, running bool, err error) {
+	res, err := b.sendRequest(ctx, MethodScanTxOutSet, ScanActionStatus)
+	if err != nil {
+		return
+	}
+	var status *struct {
+		Progress float64 `json:"progress"`
+	}
+	err = json.Unmarshal(res, &status)
+	if err != nil || status == nil {
+		return
+	}
+
+	return status.Progress, true, nil
+}
+
+// ScanTxOutSetAbort returns false if no scan was running
+func (b Bitcoind) ScanTxOutSetAbort(ctx context.Context) (aborted bool, err error) {
+	res, err := b.sendRequest(ctx, MethodScanTxOutSet, ScanActionAbort)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &aborted)
+
+	return
+}
+
+func NewScanner(client func() Bitcoind) *Scanner {
+	return &Scanner{client: client}
+}
+
+// CheckDescriptors fails with bitcoind's error for the first invalid descriptor
+// (getdescriptorinfo, all in one round-trip)
+func (b Bitcoind) CheckDescriptors(ctx context.Context, descriptors []string) error {
+	calls := make([]BatchRequest, len(descriptors))
+	for i, descriptor := range descriptors {
+		calls[i] = NewBatchRequest(MethodGetDescriptorInfo, descriptor)
+	}
+	results, err := b.SendBatch(ctx, calls)
+	if err != nil {
+		return err
+	}
+	for i, res := range results {
+		if res.Err != nil {
+			return fmt.Errorf("descriptor %s: %w", descriptors[i], res.Err)
+		}
+	}
+	return nil
+}
+
+// Start runs a scan in the background, or fails with ErrScanInProgress. The
+// descriptors are checked first, so invalid ones (or bitcoind being
+// unreachable) fail here rather than in the status of the scan.
+func (s *Scanner) Start(ctx context.Context, descriptors []string) (status ScanStatus, err error) {
+	if status, running := s.running(); running {
+		return status, ErrScanInProgress
+	}
+	backend := s.client()
+	err = backend.CheckDescriptors(ctx, descriptors)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// another scan might have started during the check
+	if s.status != nil && s.status.Running {
+		return *s.status, ErrScanInProgress
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.backend, s.cancel, s.done = backend, cancel, make(chan struct{})
+	s.status = &ScanStatus{
+		Running:     true,
+		Descriptors: descriptors,
+		Started:     time.Now(),
+	}
+	go s.run(ctx, cancel, s.backend, s.status, s.done)
+
+	return *s.status, nil
+}
+
+func (s *Scanner) running() (status ScanStatus, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == nil || !s.status.Running {
+		return status, false
+	}
+	return *s.status, true
+}
+
+func (s *Scanner) run(ctx context.Context, cancel context.CancelFunc, backend Bitcoind, status *ScanStatus, done chan struct{}) {
+	defer close(done)
+	defer cancel()
+	result, err := backend.ScanTxOutSet(ctx, status.Descriptors)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now()
+	status.Running, status.Finished = false, &finished
+	switch {
+	case ctx.Err() != nil, err == nil && !result.Success:
+		status.Error = "scan aborted"
+	case err != nil:
+		status.Error = err.Error()
+	default:
+		status.Progress, status.Result = 100, &result
+	}
+}
+
+// Status returns the running or last scan, false if there hasn't been one
+func (s *Scanner) Status(ctx context.Context) (status ScanStatus, ok bool) {
+	s.mu.Lock()
+	if s.status == nil {
+		s.mu.Unlock()
+		return status, false
+	}
+	running, backend := s.status.Running, s.backend
+	s.mu.Unlock()
+
+	if running {
+		ctx, cancel := context.WithTimeout(ctx, scanProgressTimeout)
+		progress, _, err := backend.ScanTxOutSetProgress(ctx)
+		cancel()
+		if err == nil {
+			s.mu.Lock()
+			if s.status.Running {
+				s.status.Progress = progress
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.status, true
+}
+
+// Abort stops the running scan, or fails with ErrNoScan
+func (s *Scanner) Abort(ctx context.Context) (status ScanStatus, err error) {
+	s.mu.Lock()
+	if s.status == nil || !s.status.Running {
+		s.mu.Unlock()
+		return status, ErrNoScan
+	}
+	backend, cancel, done := s.backend, s.cancel, s.done
+	s.mu.Unlock()
+
+	// Only cancelling the call would leave bitcoind scanning, so ask it to stop.
+	// If it can't be reached (or hasn't started yet) stop waiting for it at least.
+	aborted, err := backend.ScanTxOutSetAbort(ctx)
+	if err != nil || !aborted {
+		cancel()
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return status, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.status, nil
+}
@@ -0,0 +1,235 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A backend running one scantxoutset at a time, finishing it when finish is
+// called. Descriptors containing "bad" are invalid.
+type scanNode struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	scanning    bool
+	ignoreAbort bool          // answer abort as if no scan had started
+	finished    chan struct{} // closed to finish the running scan
+	aborted     chan struct{} // closed by an abort
+	stop        chan struct{}
+}
+
+func newScanNode(t *testing.T) *scanNode {
+	node := &scanNode{stop: make(chan struct{})}
+	node.Server = httptest.NewServer(http.HandlerFunc(node.serve))
+	t.Cleanup(func() {
+		close(node.stop)
+		node.Close()
+	})
+	return node
+}
+
+func (n *scanNode) client() Bitcoind {
+	return Bitcoind{url: n.URL, readTimeout: time.Minute}
+}
+
+func (n *scanNode) finish() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.finished)
+}
+
+func (n *scanNode) reply(req requestBody) map[string]interface{} {
+	reply := map[string]interface{}{"id": req.ID, "result": nil, "error": nil}
+	if req.Method == MethodGetDescriptorInfo {
+		if strings.Contains(req.Params[0].(string), "bad") {
+			reply["error"] = map[string]interface{}{"code": RPCInvalidAddressOrKey, "message": "Invalid descriptor"}
+		} else {
+			reply["result"] = map[string]interface{}{"descriptor": req.Params[0]}
+		}
+		return reply
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch req.Params[0] {
+	case ScanActionStatus:
+		if n.scanning {
+			reply["result"] = map[string]interface{}{"progress": 42}
+		}
+	case ScanActionAbort:
+		reply["result"] = n.scanning && !n.ignoreAbort
+		if reply["result"] == true {
+			close(n.aborted)
+		}
+	}
+	return reply
+}
+
+func (n *scanNode) serve(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	json.NewDecoder(r.Body).Decode(&body)
+	if body[0] == '[' {
+		var reqs []requestBody
+		json.Unmarshal(body, &reqs)
+		var replies []interface{}
+		for _, req := range reqs {
+			replies = append(replies, n.reply(req))
+		}
+		json.NewEncoder(w).Encode(replies)
+		return
+	}
+	var req requestBody
+	json.Unmarshal(body, &req)
+	if req.Method != MethodScanTxOutSet || req.Params[0] != ScanActionStart {
+		json.NewEncoder(w).Encode(n.reply(req))
+		return
+	}
+
+	n.mu.Lock()
+	n.scanning, n.finished, n.aborted = true, make(chan struct{}), make(chan struct{})
+	finished, aborted := n.finished, n.aborted
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.scanning = false
+		n.mu.Unlock()
+	}()
+	reply := map[string]interface{}{"id": req.ID, "error": nil}
+	select {
+	case <-finished:
+		reply["result"] = ScanTxOutSetResponse{Success: true, TxOuts: 10, Height: 100, Unspents: []ScanUnspent{{TransactionID: "t1", Amount: 1}}, TotalAmount: 1}
+	case <-aborted:
+		reply["result"] = ScanTxOutSetResponse{Success: false}
+	case <-r.Context().Done():
+		return
+	case <-n.stop:
+		return
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+// scanOver waits for the running scan of s to end
+func scanOver(t *testing.T, s *Scanner) {
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan still running")
+	}
+}
+
+func TestCheckDescriptors(t *testing.T) {
+	client := newScanNode(t).client()
+	ctx := context.Background()
+
+	if err := client.CheckDescriptors(ctx, []string{"addr(a)", "raw(00)"}); err != nil {
+		t.Errorf("got %v for valid descriptors", err)
+	}
+	err := client.CheckDescriptors(ctx, []string{"addr(a)", "addr(bad)", "addr(bad2)"})
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPCInvalidAddressOrKey || !strings.Contains(err.Error(), "addr(bad)") {
+		t.Errorf("got %v, want the error of the first invalid descriptor", err)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	node := newScanNode(t)
+	s := NewScanner(node.client)
+	ctx := context.Background()
+
+	if _, ok := s.Status(ctx); ok {
+		t.Error("status without a scan")
+	}
+	if _, err := s.Abort(ctx); err != ErrNoScan {
+		t.Errorf("abort without a scan: got %v, want ErrNoScan", err)
+	}
+	// invalid descriptors never start a scan
+	if _, err := s.Start(ctx, []string{"addr(bad)"}); err == nil {
+		t.Error("scan started with an invalid descriptor")
+	}
+	if _, ok := s.Status(ctx); ok {
+		t.Error("status after a failed start")
+	}
+
+	status, err := s.Start(ctx, []string{"addr(a)"})
+	if err != nil || !status.Running {
+		t.Fatalf("got %+v, %v, want a running scan", status, err)
+	}
+	if status, err := s.Start(ctx, []string{"addr(b)"}); err != ErrScanInProgress || status.Descriptors[0] != "addr(a)" {
+		t.Errorf("got %+v, %v, want the running scan and ErrScanInProgress", status, err)
+	}
+	// wait for the call to reach the node
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		status, _ = s.Status(ctx)
+		if status.Progress == 42 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !status.Running || status.Progress != 42 {
+		t.Errorf("got %+v, want the progress of the running scan", status)
+	}
+
+	node.finish()
+	scanOver(t, s)
+	status, ok := s.Status(ctx)
+	if !ok || status.Running || status.Finished == nil || status.Progress != 100 || status.Result == nil || status.Result.Unspents[0].TransactionID != "t1" || status.Error != "" {
+		t.Errorf("got %+v, want the result of the scan", status)
+	}
+	if _, err := s.Abort(ctx); err != ErrNoScan {
+		t.Errorf("abort after the scan: got %v, want ErrNoScan", err)
+	}
+
+	// a finished scan doesn't block the next one
+	if _, err := s.Start(ctx, []string{"addr(b)"}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		node.mu.Lock()
+		scanning := node.scanning
+		node.mu.Unlock()
+		if scanning {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	status, err = s.Abort(ctx)
+	if err != nil || status.Running || status.Error != "scan aborted" || status.Result != nil {
+		t.Errorf("got %+v, %v, want an aborted scan", status, err)
+	}
+}
+
+func TestScannerAbortBeforeScanStarts(t *testing.T) {
+	node := newScanNode(t)
+	node.ignoreAbort = true
+	s := NewScanner(node.client)
+	ctx := context.Background()
+
+	if _, err := s.Start(ctx, []string{"addr(a)"}); err != nil {
+		t.Fatal(err)
+	}
+	// bitcoind doesn't know of the scan yet, so the call is cancelled instead
+	status, err := s.Abort(ctx)
+	if err != nil || status.Running || status.Error != "scan aborted" {
+		t.Errorf("got %+v, %v, want an aborted scan", status, err)
+	}
+}
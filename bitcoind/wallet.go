@@ -0,0 +1,229 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Watch-only wallets
+
+Wallets are created without private keys, so this server can only ever watch
+addresses and never spend from them. Calls for a specific wallet go to the
+`/wallet/<name>` RPC endpoint of bitcoind.
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/createwallet.html
+https://developer.bitcoin.org/reference/rpc/importdescriptors.html
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"regexp"
+)
+
+const (
+	MethodCreateWallet      = "createwallet"
+	MethodLoadWallet        = "loadwallet"
+	MethodImportDescriptors = "importdescriptors"
+	MethodListTransactions  = "listtransactions"
+
+	// Transactions read per listtransactions call when filtering by address
+	listTransactionsChunk = 500
+	// Most recent wallet transactions searched when filtering by address
+	MaxAddressScan = 20 * listTransactionsChunk
+)
+
+// Wallet names are directories in the datadir of bitcoind, only plain names are allowed
+var walletNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type (
+	// Response for createwallet and loadwallet
+	WalletResponse struct {
+		Name    string `json:"name"`
+		Warning string `json:"warning"`
+	}
+
+	// A descriptor to import, Timestamp is either "now" or a unix time to rescan from
+	ImportDescriptorRequest struct {
+		Descriptor string      `json:"desc"`
+		Timestamp  interface{} `json:"timestamp"`
+		Label      string      `json:"label,omitempty"`
+		Range      []int64     `json:"range,omitempty"`
+	}
+
+	// Result for each descriptor passed to importdescriptors
+	ImportDescriptorResult struct {
+		Success  bool      `json:"success"`
+		Warnings []string  `json:"warnings,omitempty"`
+		Error    *RPCError `json:"error,omitempty"`
+	}
+
+	// Response for listreceivedbyaddress
+	ReceivedByAddress struct {
+		InvolvesWatchOnly bool     `json:"involvesWatchonly"`
+		Address           string   `json:"address"`
+		Amount            float64  `json:"amount"`
+		Confirmations     int64    `json:"confirmations"`
+		Label             string   `json:"label"`
+		TransactionIDs    []string `json:"txids"`
+	}
+
+	// Response for listtransactions
+	WalletTransaction struct {
+		InvolvesWatchOnly bool    `json:"involvesWatchonly"`
+		Address           string  `json:"address"`
+		Category          string  `json:"category"`
+		Amount            float64 `json:"amount"`
+		Label             string  `json:"label"`
+		Vout              int64   `json:"vout"`
+		Confirmations     int64   `json:"confirmations"`
+		Blockhash         string  `json:"blockhash,omitempty"`
+		BlockHeight       int64   `json:"blockheight,omitempty"`
+		Blocktime         int64   `json:"blocktime,omitempty"`
+		TransactionID     string  `json:"txid"`
+		Time              int64   `json:"time"`
+		TimeReceived      int64   `json:"timereceived"`
+		Replaceable       string  `json:"bip125-replaceable"`
+	}
+)
+
+// wallet returns a copy of the client which sends its calls to the named wallet
+// ValidWalletName reports whether name may be used for a wallet (letters,
+// digits, '_' and '-', up to 64 characters, so no path separators)
+func ValidWalletName(name string) bool {
+	return walletNamePattern.MatchString(name)
+}
+
+func (b Bitcoind) wallet(name string) Bitcoind {
+	b.url = b.url + "/wallet/" + url.PathEscape(name)
+	return b
+}
+
+// CreateWallet creates a blank watch-only wallet (no private keys)
+func (b Bitcoind) CreateWallet(ctx context.Context, name string, descriptors bool) (wallet WalletResponse, err error) {
+	// wallet_name, disable_private_keys, blank, passphrase, avoid_reuse, descriptors
+	res, err := b.sendRequest(ctx, MethodCreateWallet, name, true, true, "", false, descriptors)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &wallet)
+
+	return
+}
+
+// LoadWallet loads an existing wallet from the bitcoind wallet dir
+func (b Bitcoind) LoadWallet(ctx context.Context, name string) (wallet WalletResponse, err error) {
+	res, err := b.sendRequest(ctx, MethodLoadWallet, name)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &wallet)
+
+	return
+}
+
+// ImportAddress adds a watch-only address (legacy wallets only, use ImportDescriptors for descriptor wallets)
+func (b Bitcoind) ImportAddress(ctx context.Context, walletName, address, label string, rescan bool) (err error) {
+	_, err = b.wallet(walletName).sendRequest(ctx, MethodImportAddress, address, label, rescan)
+
+	return
+}
+
+// ImportDescriptors imports descriptors (or addr(...) descriptors for single addresses) into a descriptor wallet
+func (b Bitcoind) ImportDescriptors(ctx context.Context, walletName string, descriptors []ImportDescriptorRequest) (results []ImportDescriptorResult, err error) {
+	for i := range descriptors {
+		if descriptors[i].Timestamp == nil {
+			descriptors[i].Timestamp = "now"
+		}
+	}
+	res, err := b.wallet(walletName).sendRequest(ctx, MethodImportDescriptors, descriptors)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &results)
+
+	return
+}
+
+// ListReceivedByAddress lists amounts received per address (including watch-only),
+// optionally limited to a single address
+func (b Bitcoind) ListReceivedByAddress(ctx context.Context, walletName string, minConf int64, includeEmpty bool, address string) (received []ReceivedByAddress, err error) {
+	// minconf, include_empty, include_watchonly, address_filter
+	params := []interface{}{minConf, includeEmpty, true}
+	if address != "" {
+		params = append(params, address)
+	}
+	res, err := b.wallet(walletName).sendRequest(ctx, MethodListReceivedByAddress, params...)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &received)
+
+	return
+}
+
+// ListTransactions lists the most recent transactions of a wallet (including watch-only)
+func (b Bitcoind) ListTransactions(ctx context.Context, walletName string, count, skip int64) (transactions []WalletTransaction, err error) {
+	// label, count, skip, include_watchonly
+	res, err := b.wallet(walletName).sendRequest(ctx, MethodListTransactions, "*", count, skip, true)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &transactions)
+
+	return
+}
+
+// ListAddressTransactions lists the most recent transactions of one address in
+// a wallet, count and skip apply to the transactions of that address. The
+// wallet history is read in chunks, most recent first, until enough matched.
+// Only the most recent MaxAddressScan wallet transactions are searched.
+func (b Bitcoind) ListAddressTransactions(ctx context.Context, walletName, address string, count, skip int64) (transactions []WalletTransaction, err error) {
+	var matched []WalletTransaction // most recent first
+	for from := int64(0); int64(len(matched)) < skip+count && from < MaxAddressScan; from += listTransactionsChunk {
+		chunk, chunkErr := b.ListTransactions(ctx, walletName, listTransactionsChunk, from)
+		if chunkErr != nil {
+			return nil, chunkErr
+		}
+		// listtransactions returns each page oldest first
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i].Address == address {
+				matched = append(matched, chunk[i])
+			}
+		}
+		if len(chunk) < listTransactionsChunk {
+			break
+		}
+	}
+
+	transactions = []WalletTransaction{}
+	for i := int64(len(matched)) - 1; i >= skip; i-- {
+		if i < skip+count {
+			transactions = append(transactions, matched[i])
+		}
+	}
+	return
+}
+
+// GetNewAddress derives the next address from a wallet with active (public) descriptors
+func (b Bitcoind) GetNewAddress(ctx context.Context, walletName, label, addressType string) (address string, err error) {
+	params := []interface{}{label}
+	if addressType != "" {
+		params = append(params, addressType)
+	}
+	res, err := b.wallet(walletName).sendRequest(ctx, MethodGetNewAddress, params...)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(res, &address)
+
+	return
+}
@@ -0,0 +1,129 @@
+package bitcoind
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A wallet with txs 0 (oldest) to n-1, every third one paying to "watched"
+func listTransactionsNode(t *testing.T, n int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req requestBody
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != MethodListTransactions {
+			t.Errorf("unexpected request %+v: %v", req, err)
+			return
+		}
+		count, skip := int(req.Params[1].(float64)), int(req.Params[2].(float64))
+		// the most recent count after skipping skip, oldest first
+		to := n - skip
+		from := to - count
+		if from < 0 {
+			from = 0
+		}
+		page := []WalletTransaction{}
+		for i := from; i < to; i++ {
+			address := "other"
+			if i%3 == 0 {
+				address = "watched"
+			}
+			page = append(page, WalletTransaction{Address: address, TransactionID: fmt.Sprint(i)})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": page})
+	}))
+}
+
+func txids(transactions []WalletTransaction) (ids []string) {
+	for _, tx := range transactions {
+		ids = append(ids, tx.TransactionID)
+	}
+	return
+}
+
+func TestListAddressTransactionsPagesMatches(t *testing.T) {
+	// 1200 transactions, 400 of them to "watched" (1197, 1194, ... 0)
+	server := listTransactionsNode(t, 1200)
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+	ctx := context.Background()
+
+	tests := []struct {
+		count, skip int64
+		want        []string // oldest first, like listtransactions
+	}{
+		{3, 0, []string{"1191", "1194", "1197"}},
+		{2, 3, []string{"1185", "1188"}},
+		// past the first chunk of 500
+		{2, 200, []string{"594", "597"}},
+		{5, 398, []string{"0", "3"}},
+		{5, 400, []string{}},
+	}
+	for _, test := range tests {
+		transactions, err := client.ListAddressTransactions(ctx, "w", "watched", test.count, test.skip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := txids(transactions)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("count %d skip %d: got %v, want %v", test.count, test.skip, got, test.want)
+		}
+	}
+}
+
+func TestListAddressTransactionsScanIsCapped(t *testing.T) {
+	var calls int
+	node := listTransactionsNode(t, MaxAddressScan+1000)
+	defer node.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		node.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client := Bitcoind{url: server.URL}
+
+	// asks for more than the latest MaxAddressScan transactions hold
+	transactions, err := client.ListAddressTransactions(context.Background(), "w", "watched", 1000, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := MaxAddressScan / listTransactionsChunk; calls != want {
+		t.Errorf("%d listtransactions calls, want %d", calls, want)
+	}
+	// 3333 matches among the latest 10000 (10998 down to 1002)
+	got := txids(transactions)
+	if len(got) != 333 || got[0] != "1002" || got[len(got)-1] != "1998" {
+		t.Errorf("got %d transactions from %v to %v, want 333 from 1002 to 1998", len(got), got[0], got[len(got)-1])
+	}
+}
+
+func TestValidWalletName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"watch-only_1":          true,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+		"":                      false,
+		"../wallets":            false,
+		"a/b":                   false,
+		`a\b`:                   false,
+		"with space":            false,
+		"wället":                false,
+	} {
+		if got := ValidWalletName(name); got != want {
+			t.Errorf("%q: got %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package chainwatch
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Chain watcher
+
+Keeps track of the chain tip and publishes block, tip and mempool stats events
+on the bus. The tip is polled on an interval, and checked straight away on a
+ZMQ hashblock notification, so new blocks are seen immediately when ZMQ is set
+up and within the poll interval otherwise.
+
+The hashes of the last TrackDepth blocks are kept per height. A new tip is
+followed back (previousblockhash) until it meets a known hash, the known blocks
+above that fork point were disconnected: that's a reorg. When the tip jumps by
+more than TrackDepth blocks (initial sync, or the server was down) the history
+starts over from the new tip.
+*/
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+)
+
+const (
+	DefaultPollInterval  = 10 * time.Second
+	DefaultStatsInterval = 10 * time.Second
+
+	// When the tip moved by more blocks than this only the newest ones get a block event
+	MaxCatchUp = 10
+	// Block hashes kept per height to find fork points, deeper reorgs are not detected
+	TrackDepth = 100
+	// Reorgs kept for Reorgs()
+	MaxReorgs = 50
+)
+
+type (
+	// The calls the watcher needs (implemented by bitcoind.Pool)
+	Client interface {
+		GetBestBlockHash(context.Context) (string, error)
+		GetBlock(ctx context.Context, hash string) (bitcoind.BitcoinBlockResponse, error)
+		GetMempoolInfo(context.Context) (bitcoind.MempoolInfoResponse, error)
+	}
+
+	Watcher struct {
+		client        Client
+		bus           *events.Bus
+		pollInterval  time.Duration
+		statsInterval time.Duration
+
+		mu        sync.RWMutex
+		tipHash   string
+		tipHeight int64
+		hashes    map[int64]string        // best chain hashes of the last TrackDepth heights
+		reorgs    []events.Reorg          // oldest first
+		last      map[string]events.Event // last event per topic
+	}
+)
+
+func New(client Client, bus *events.Bus, pollInterval, statsInterval time.Duration) *Watcher {
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	if statsInterval <= 0 {
+		statsInterval = DefaultStatsInterval
+	}
+	return &Watcher{
+		client:        client,
+		bus:           bus,
+		pollInterval:  pollInterval,
+		statsInterval: statsInterval,
+		hashes:        make(map[int64]string),
+		last:          make(map[string]events.Event),
+	}
+}
+
+// Run watches the chain until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	hashBlocks := w.bus.Subscribe(8, events.TopicHashBlock)
+	defer hashBlocks.Unsubscribe()
+
+	w.checkTip(ctx)
+	w.checkMempool(ctx)
+
+	pollTicker := time.NewTicker(w.pollInterval)
+	defer pollTicker.Stop()
+	statsTicker := time.NewTicker(w.statsInterval)
+	defer statsTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hashBlocks.C:
+			w.checkTip(ctx)
+		case <-pollTicker.C:
+			w.checkTip(ctx)
+		case <-statsTicker.C:
+			w.checkMempool(ctx)
+		}
+	}
+}
+
+// Last returns the most recent event published on a topic, so new
+// subscribers don't have to wait for the next one
+func (w *Watcher) Last(topic string) (event events.Event, ok bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	event, ok = w.last[topic]
+	return
+}
+
+// Reorgs returns the reorgs seen since the watcher started, newest first
+func (w *Watcher) Reorgs() []events.Reorg {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	reorgs := make([]events.Reorg, len(w.reorgs))
+	for i, reorg := range w.reorgs {
+		reorgs[len(reorgs)-1-i] = reorg
+	}
+	return reorgs
+}
+
+func (w *Watcher) publish(topic string, data interface{}) {
+	w.mu.Lock()
+	w.last[topic] = events.Event{Topic: topic, Time: time.Now(), Data: data}
+	w.mu.Unlock()
+	w.bus.Publish(topic, "", data)
+}
+
+// checkTip publishes the blocks since the last known tip (oldest first), a reorg
+// if blocks of the previous best chain were replaced, and then the new tip
+func (w *Watcher) checkTip(ctx context.Context) {
+	hash, err := w.client.GetBestBlockHash(ctx)
+	if err != nil {
+		return
+	}
+	w.mu.RLock()
+	current, currentHeight := w.tipHash, w.tipHeight
+	w.mu.RUnlock()
+	if hash == current {
+		return
+	}
+	block, err := w.client.GetBlock(ctx, hash)
+	if err != nil {
+		return
+	}
+
+	// Walk back from the new tip to the first block whose parent is a known block
+	// of the previous best chain, that parent is the common ancestor. The heights
+	// above the old tip aren't known yet, so they are always walked through. A tip
+	// that is known already (rolled back with invalidateblock) connects nothing.
+	var connected []bitcoind.BitcoinBlockResponse // newest first
+	forkHeight := int64(-1)
+	if current != "" && block.Height-currentHeight <= TrackDepth {
+		if w.knownHash(block.Height) == block.Hash {
+			forkHeight = block.Height
+		} else {
+			connected = append(connected, block)
+		}
+		for forkHeight < 0 {
+			oldest := connected[len(connected)-1]
+			parentHeight := oldest.Height - 1
+			if parentHeight <= currentHeight && w.knownHash(parentHeight) == oldest.PreviousBlockHash {
+				forkHeight = parentHeight
+				break
+			}
+			// Forked deeper than the tracked history (or reached genesis), start over
+			if oldest.PreviousBlockHash == "" || parentHeight <= currentHeight-TrackDepth {
+				break
+			}
+			previous, err := w.client.GetBlock(ctx, oldest.PreviousBlockHash)
+			if err != nil {
+				return
+			}
+			connected = append(connected, previous)
+		}
+	}
+
+	if forkHeight >= 0 {
+		if forkHeight < currentHeight {
+			w.recordReorg(forkHeight, currentHeight, block, connected)
+		}
+		catchUp := connected
+		if len(catchUp) > MaxCatchUp {
+			catchUp = catchUp[:MaxCatchUp]
+		}
+		for i := len(catchUp) - 1; i >= 0; i-- {
+			w.publish(events.TopicBlock, blockEvent(catchUp[i]))
+		}
+	}
+
+	w.mu.Lock()
+	if forkHeight < 0 {
+		// Nothing to compare with (first tip, or it jumped too far), start over
+		w.hashes = make(map[int64]string)
+	}
+	for height := range w.hashes {
+		if height > forkHeight || height <= block.Height-TrackDepth {
+			delete(w.hashes, height)
+		}
+	}
+	for _, connectedBlock := range connected {
+		w.hashes[connectedBlock.Height] = connectedBlock.Hash
+	}
+	w.hashes[block.Height] = block.Hash
+	w.tipHash = hash
+	w.tipHeight = block.Height
+	w.mu.Unlock()
+	w.publish(events.TopicTip, events.Tip{
+		Hash:   block.Hash,
+		Height: block.Height,
+		Time:   block.Time,
+	})
+}
+
+// knownHash is the best chain hash at height, empty when it's not tracked
+func (w *Watcher) knownHash(height int64) string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.hashes[height]
+}
+
+// recordReorg keeps and publishes a reorg from the fork point to the new tip,
+// connected is newest first (as walked back from the new tip)
+func (w *Watcher) recordReorg(forkHeight, oldHeight int64, tip bitcoind.BitcoinBlockResponse, connected []bitcoind.BitcoinBlockResponse) {
+	w.mu.Lock()
+	reorg := events.Reorg{
+		Time:       time.Now(),
+		Depth:      int(oldHeight - forkHeight),
+		ForkHash:   w.hashes[forkHeight],
+		ForkHeight: forkHeight,
+		OldTip:     events.BlockRef{Hash: w.hashes[oldHeight], Height: oldHeight},
+		NewTip:     events.BlockRef{Hash: tip.Hash, Height: tip.Height},
+		Connected:  []events.BlockRef{},
+	}
+	for height := forkHeight + 1; height <= oldHeight; height++ {
+		reorg.Disconnected = append(reorg.Disconnected, events.BlockRef{Hash: w.hashes[height], Height: height})
+	}
+	for i := len(connected) - 1; i >= 0; i-- {
+		reorg.Connected = append(reorg.Connected, events.BlockRef{Hash: connected[i].Hash, Height: connected[i].Height})
+	}
+	w.reorgs = append(w.reorgs, reorg)
+	if len(w.reorgs) > MaxReorgs {
+		w.reorgs = w.reorgs[len(w.reorgs)-MaxReorgs:]
+	}
+	w.mu.Unlock()
+	fmt.Printf("Chain watcher: reorg of %d block(s) at height %d, new tip %s\n", reorg.Depth, forkHeight, reorg.NewTip.Hash)
+	// Not kept as the last event of the topic, a new subscriber shouldn't see an old reorg as a new one
+	w.bus.Publish(events.TopicReorg, "", reorg)
+}
+
+func (w *Watcher) checkMempool(ctx context.Context) {
+	info, err := w.client.GetMempoolInfo(ctx)
+	if err != nil {
+		return
+	}
+	w.publish(events.TopicMempoolStats, events.MempoolStats{
+		Size:          info.Size,
+		Bytes:         info.Bytes,
+		Usage:         info.Usage,
+		MaxMempool:    info.MaxMempool,
+		MempoolMinFee: bitcoind.BTCPerKvBToSatPerVB(info.MempoolMinFee),
+		MinRelayTxFee: bitcoind.BTCPerKvBToSatPerVB(info.MinRelayTxFee),
+	})
+}
+
+func blockEvent(block bitcoind.BitcoinBlockResponse) events.Block {
+	return events.Block{
+		Hash:         block.Hash,
+		Height:       block.Height,
+		PreviousHash: block.PreviousBlockHash,
+		Time:         block.Time,
+		Size:         block.Size,
+		Weight:       block.Weight,
+		TxCount:      len(block.Transactions),
+	}
+}
@@ -0,0 +1,188 @@
+package chainwatch
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+)
+
+// A chain of blocks by hash with a movable tip
+type fakeChain struct {
+	blocks map[string]bitcoind.BitcoinBlockResponse
+	tip    string
+}
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{blocks: make(map[string]bitcoind.BitcoinBlockResponse)}
+}
+
+// extend adds blocks named <branch><height> on top of parent up to height to
+func (f *fakeChain) extend(parent, branch string, to int64) {
+	height := int64(0)
+	if parent != "" {
+		height = f.blocks[parent].Height + 1
+	}
+	for ; height <= to; height++ {
+		hash := fmt.Sprintf("%s%d", branch, height)
+		f.blocks[hash] = bitcoind.BitcoinBlockResponse{Hash: hash, Height: height, PreviousBlockHash: parent}
+		parent = hash
+	}
+	f.tip = parent
+}
+
+func (f *fakeChain) GetBestBlockHash(context.Context) (string, error) {
+	return f.tip, nil
+}
+
+func (f *fakeChain) GetBlock(_ context.Context, hash string) (bitcoind.BitcoinBlockResponse, error) {
+	block, ok := f.blocks[hash]
+	if !ok {
+		return block, errors.New("block not found")
+	}
+	return block, nil
+}
+
+func (f *fakeChain) GetMempoolInfo(context.Context) (bitcoind.MempoolInfoResponse, error) {
+	return bitcoind.MempoolInfoResponse{}, nil
+}
+
+// blockHashes drains the block events published so far
+func blockHashes(sub *events.Subscription) (hashes []string) {
+	for {
+		select {
+		case event := <-sub.C:
+			hashes = append(hashes, event.Data.(events.Block).Hash)
+		default:
+			return
+		}
+	}
+}
+
+func refHashes(refs []events.BlockRef) (hashes []string) {
+	for _, ref := range refs {
+		hashes = append(hashes, ref.Hash)
+	}
+	return
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCheckTipExtendAndReorgToLongerBranch(t *testing.T) {
+	ctx := context.Background()
+	chain := newFakeChain()
+	chain.extend("", "a", 4)
+	bus := events.NewBus()
+	blocks := bus.Subscribe(100, events.TopicBlock)
+	w := New(chain, bus, 0, 0)
+
+	w.checkTip(ctx)
+	if got := blockHashes(blocks); len(got) != 0 {
+		t.Fatalf("first tip published block events %v", got)
+	}
+
+	// Extend by 3
+	chain.extend("a4", "a", 7)
+	w.checkTip(ctx)
+	if got, want := blockHashes(blocks), []string{"a5", "a6", "a7"}; !equal(got, want) {
+		t.Fatalf("extend: block events %v, want %v", got, want)
+	}
+	if reorgs := w.Reorgs(); len(reorgs) != 0 {
+		t.Fatalf("extend: recorded reorgs %v", reorgs)
+	}
+
+	// Fork after height 5 onto a branch that ends above the old tip
+	chain.extend("a5", "b", 9)
+	w.checkTip(ctx)
+	if got, want := blockHashes(blocks), []string{"b6", "b7", "b8", "b9"}; !equal(got, want) {
+		t.Fatalf("reorg: block events %v, want %v", got, want)
+	}
+	reorgs := w.Reorgs()
+	if len(reorgs) != 1 {
+		t.Fatalf("reorg: %d reorgs recorded, want 1", len(reorgs))
+	}
+	reorg := reorgs[0]
+	if reorg.Depth != 2 || reorg.ForkHash != "a5" || reorg.ForkHeight != 5 {
+		t.Errorf("reorg: depth %d at %s (%d), want 2 at a5 (5)", reorg.Depth, reorg.ForkHash, reorg.ForkHeight)
+	}
+	if reorg.OldTip.Hash != "a7" || reorg.NewTip.Hash != "b9" {
+		t.Errorf("reorg: tips %s -> %s, want a7 -> b9", reorg.OldTip.Hash, reorg.NewTip.Hash)
+	}
+	if got, want := refHashes(reorg.Disconnected), []string{"a6", "a7"}; !equal(got, want) {
+		t.Errorf("reorg: disconnected %v, want %v", got, want)
+	}
+	if got, want := refHashes(reorg.Connected), []string{"b6", "b7", "b8", "b9"}; !equal(got, want) {
+		t.Errorf("reorg: connected %v, want %v", got, want)
+	}
+
+	// The new branch is tracked: extending it is not another reorg
+	chain.extend("b9", "b", 10)
+	w.checkTip(ctx)
+	if got, want := blockHashes(blocks), []string{"b10"}; !equal(got, want) {
+		t.Fatalf("extend after reorg: block events %v, want %v", got, want)
+	}
+	if reorgs := w.Reorgs(); len(reorgs) != 1 {
+		t.Fatalf("extend after reorg: %d reorgs recorded, want 1", len(reorgs))
+	}
+}
+
+func TestCheckTipCatchUpIsCapped(t *testing.T) {
+	ctx := context.Background()
+	chain := newFakeChain()
+	chain.extend("", "a", 10)
+	bus := events.NewBus()
+	blocks := bus.Subscribe(100, events.TopicBlock)
+	w := New(chain, bus, 0, 0)
+	w.checkTip(ctx)
+
+	chain.extend("a10", "a", 10+MaxCatchUp+5)
+	w.checkTip(ctx)
+	got := blockHashes(blocks)
+	if len(got) != MaxCatchUp {
+		t.Fatalf("%d block events, want %d", len(got), MaxCatchUp)
+	}
+	if want := fmt.Sprintf("a%d", 10+MaxCatchUp+5); got[len(got)-1] != want {
+		t.Errorf("last block event %s, want %s", got[len(got)-1], want)
+	}
+}
+
+func TestCheckTipRollback(t *testing.T) {
+	ctx := context.Background()
+	chain := newFakeChain()
+	chain.extend("", "a", 4)
+	w := New(chain, events.NewBus(), 0, 0)
+	w.checkTip(ctx)
+	chain.extend("a4", "a", 8)
+	w.checkTip(ctx)
+
+	// invalidateblock a7: the tip goes back to a known block
+	chain.tip = "a6"
+	w.checkTip(ctx)
+	reorgs := w.Reorgs()
+	if len(reorgs) != 1 || reorgs[0].Depth != 2 || len(reorgs[0].Connected) != 0 {
+		t.Fatalf("rollback: reorgs %+v, want one of depth 2 connecting nothing", reorgs)
+	}
+}
@@ -4,5 +4,7 @@ const (
 	DefaultConfigDir  = "~/.lncm/"
 	DefaultConfigFile = DefaultConfigDir + "httpd.conf"
 	DefaultLogFile    = DefaultConfigDir + "httpd.log"
+	DefaultIndexFile  = DefaultConfigDir + "index.db"
+	DefaultWatchFile  = DefaultConfigDir + "txwatch.db"
 	StaticFilePath    = DefaultConfigDir + "www"
 )
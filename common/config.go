@@ -5,6 +5,8 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
+
+	"github.com/pelletier/go-toml"
 )
 
 type (
@@ -18,28 +20,111 @@ type (
 		LndClient               bool   `toml:"lnd-client" default:false`
 		BtcPriceApi             string `toml:"btc-price-feed" default:"https://min-api.cryptocompare.com/data/price?fsym=BTC&tsyms=THB,USD,EUR"` // btc-price-feed (Default: https://min-api.cryptocompare.com/data/price?fsym=BTC&tsyms=THB,USD,EUR)
 
-		// [bitcoind] section in the `--config` file that defines Bitcoind's setup
-		Bitcoind Bitcoind `toml:"bitcoind"`
-		Lnd      Lnd      `toml:"lnd"` // LND  client
+		// [bitcoind] section in the `--config` file that defines Bitcoind's setup.
+		// Can also be repeated as [[bitcoind]] to define multiple backends
+		Bitcoind []Bitcoind `toml:"bitcoind"`
+		Lnd      Lnd        `toml:"lnd"` // LND  client
+
+		// How often (in seconds) the bitcoind backends are health checked
+		HealthCheckInterval int64 `toml:"health-check-interval" default:"15"`
+		// Confirmation targets (in blocks) for /api/fees
+		FeeTargets []int64 `toml:"fee-targets"`
+		// How often (in seconds) to check for a new tip (without ZMQ) and mempool stats
+		TipPollInterval      int64 `toml:"tip-poll-interval" default:"10"`
+		MempoolStatsInterval int64 `toml:"mempool-stats-interval" default:"10"`
+		// How often (in seconds) to analyze the whole mempool for /api/mempool/histogram and /stats, 0 to disable
+		MempoolAnalyticsInterval int64 `toml:"mempool-analytics-interval" default:"60"`
+		// How often (in seconds) to sample getnettotals for /api/nettotals/history, 0 to disable
+		NetTotalsInterval int64 `toml:"nettotals-interval" default:"10"`
+		// /api/stream limits
+		StreamMaxConnections   int64 `toml:"stream-max-connections" default:"100"`
+		StreamMaxSubscriptions int64 `toml:"stream-max-subscriptions" default:"4"` // topics per connection
+		// Serve the Esplora compatible API under /esplora
+		EsploraAPI bool `toml:"esplora-api" default:"false"`
 
 		// auth-scheme key
 		AuthScheme string `toml:"auth-scheme" default:"none"` // either use omitempty or default (https://godoc.org/github.com/pelletier/go-toml)
 		// [jwt] section
 		JWTConfig JwtConfig `toml:"jwt"`
+		// [indexer] section
+		Indexer Indexer `toml:"indexer"`
+		// [electrum] section
+		Electrum Electrum `toml:"electrum"`
+		// [txwatch] section
+		TxWatch TxWatch `toml:"txwatch"`
 	}
 
 	// JWT scheme struct
 	JwtConfig struct {
 		PrivKeyStore string `toml:"private-key-store"`
 		PubKeyStore  string `toml:"public-key-store"`
+		// Users that get the admin role when signing in (for /api/admin), with
+		// the bcrypt hash of their password
+		AdminUsers map[string]string `toml:"admin-users"`
+		// Users that get the user role when signing in (needed for transaction
+		// watches), with the bcrypt hash of their password. Other users get a
+		// token without a role for any password.
+		Users map[string]string `toml:"users"`
 	}
 	// Bitcoind config (enter some default values)
 	// NOTE: Keep in mind that this is **not yet encrypted**, so best to keep it _local_
 	Bitcoind struct {
+		Name string `toml:"name"` // optional, shown in /api/backends (Default: host:port)
 		Host string `toml:"host" default:"localhost"`
 		Port int64  `toml:"port" default:8332`
 		User string `toml:"user" default:"lncm"`
-		Pass string `toml:"pass" default:"lncmrocks"`
+		Pass string `toml:"pass"` // no default, an empty pass means cookie authentication
+		// Cookie authentication. If cookie-file is not set and pass is empty,
+		// the .cookie file is looked up in datadir (per network)
+		CookieFile string `toml:"cookie-file"`
+		DataDir    string `toml:"datadir" default:"~/.bitcoin"`
+		Network    string `toml:"network" default:"main"` // main, test, testnet4, signet or regtest
+		// Timeouts in seconds for connecting to and waiting on a single RPC call
+		ConnectTimeout int64 `toml:"connect-timeout" default:"5"`
+		ReadTimeout    int64 `toml:"read-timeout" default:"30"`
+		// ZMQ endpoints, same values as bitcoind's -zmqpub* options (e.g. tcp://127.0.0.1:28332)
+		ZMQPubHashBlock string `toml:"zmqpubhashblock"`
+		ZMQPubRawBlock  string `toml:"zmqpubrawblock"`
+		ZMQPubRawTx     string `toml:"zmqpubrawtx"`
+		ZMQPubSequence  string `toml:"zmqpubsequence"`
+	}
+
+	// Address indexer config
+	Indexer struct {
+		Enabled     bool   `toml:"enabled" default:"false"`
+		DBFile      string `toml:"db-file" default:"~/.lncm/index.db"`
+		StartHeight int64  `toml:"start-height" default:"0"` // blocks below are not indexed
+		// How often (in seconds) to look for new blocks, besides on every new tip
+		SyncInterval int64 `toml:"sync-interval" default:"30"`
+	}
+
+	// Electrum protocol server config (needs the address indexer)
+	Electrum struct {
+		Enabled   bool   `toml:"enabled" default:"false"`
+		Listen    string `toml:"listen" default:":50001"` // plain TCP, empty to disable
+		TLSListen string `toml:"tls-listen"`              // TLS, needs tls-cert-file and tls-key-file
+		TLSCert   string `toml:"tls-cert-file"`
+		TLSKey    string `toml:"tls-key-file"`
+		// Open connections, and script hash subscriptions per connection
+		MaxConnections   int64 `toml:"max-connections" default:"100"`
+		MaxSubscriptions int64 `toml:"max-subscriptions" default:"1000"`
+	}
+
+	// Transaction watches with callbacks (needs auth-scheme = "JWT")
+	TxWatch struct {
+		Enabled bool   `toml:"enabled" default:"false"`
+		DBFile  string `toml:"db-file" default:"~/.lncm/txwatch.db"`
+		Secret  string `toml:"secret"` // HMAC-SHA256 key of the X-Signature-256 callback header, required
+		// Delivery attempts per callback, and seconds before the first retry (doubles on every retry)
+		MaxAttempts   int64 `toml:"max-attempts" default:"8"`
+		RetryInterval int64 `toml:"retry-interval" default:"10"`
+		// Hours a transaction is watched before it confirms
+		ExpireAfter int64 `toml:"expire-after" default:"72"`
+		MaxWatches  int64 `toml:"max-watches" default:"10000"`
+		// Watches a single user may have
+		MaxWatchesPerUser int64 `toml:"max-watches-per-user" default:"100"`
+		// Callback hosts (names, IPs or CIDRs) allowed to be private or loopback addresses
+		AllowedHosts []string `toml:"allowed-callback-hosts"`
 	}
 
 	// Lnd config
@@ -69,3 +154,11 @@ func CleanAndExpandPath(path string) string {
 
 	return filepath.Clean(os.ExpandEnv(path))
 }
+
+// NormalizeConfig rewrites a single [bitcoind] table into a one element
+// [[bitcoind]] array, so older config files keep working with Config.Bitcoind
+func NormalizeConfig(tree *toml.Tree) {
+	if section, ok := tree.Get("bitcoind").(*toml.Tree); ok {
+		tree.Set("bitcoind", []*toml.Tree{section})
+	}
+}
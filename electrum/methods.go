@@ -0,0 +1,468 @@
+package electrum
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Electrum protocol methods
+
+Script hashes are the sha256 of the output script, in reverse byte order as
+hex. Amounts are in satoshis, fee rates in BTC/kB.
+
+Reference:
+https://electrumx-spesmilo.readthedocs.io/en/latest/protocol-methods.html
+*/
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+const (
+	// Error codes (JSON-RPC and ElectrumX)
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeBadRequest     = 1
+	CodeDaemonError    = 2
+)
+
+var (
+	errParse = &Error{Code: CodeParseError, Message: "invalid JSON"}
+
+	methods = map[string]method{
+		"server.version":                    serverVersion,
+		"server.ping":                       serverPing,
+		"server.features":                   serverFeatures,
+		"server.banner":                     serverBanner,
+		"server.donation_address":           serverBanner,
+		"server.peers.subscribe":            serverPeers,
+		"blockchain.headers.subscribe":      headersSubscribe,
+		"blockchain.block.header":           blockHeader,
+		"blockchain.block.headers":          blockHeaders,
+		"blockchain.scripthash.get_history": scriptHashHistory,
+		"blockchain.scripthash.get_balance": scriptHashBalance,
+		"blockchain.scripthash.listunspent": scriptHashUnspent,
+		"blockchain.scripthash.subscribe":   scriptHashSubscribe,
+		"blockchain.scripthash.unsubscribe": scriptHashUnsubscribe,
+		"blockchain.transaction.get":        transactionGet,
+		"blockchain.transaction.broadcast":  transactionBroadcast,
+		"blockchain.estimatefee":            estimateFee,
+		"blockchain.relayfee":               relayFee,
+	}
+)
+
+type (
+	method func(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error)
+
+	// Error sent back to the client
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	headerNotification struct {
+		Hex    string `json:"hex"`
+		Height int64  `json:"height"`
+	}
+
+	historyItem struct {
+		Height        int64  `json:"height"`
+		TransactionID string `json:"tx_hash"`
+	}
+
+	unspentItem struct {
+		Position      uint32 `json:"tx_pos"`
+		Value         int64  `json:"value"`
+		TransactionID string `json:"tx_hash"`
+		Height        int64  `json:"height"`
+	}
+
+	balance struct {
+		Confirmed   int64 `json:"confirmed"`
+		Unconfirmed int64 `json:"unconfirmed"`
+	}
+
+	headersResult struct {
+		Count int64  `json:"count"`
+		Hex   string `json:"hex"`
+		Max   int64  `json:"max"`
+	}
+)
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func invalidParams(format string, a ...interface{}) *Error {
+	return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf(format, a...)}
+}
+
+// handle runs a single request
+func (sess *session) handle(ctx context.Context, req request) response {
+	res := response{JSONRPC: "2.0", ID: req.ID}
+	if res.ID == nil {
+		res.ID = json.RawMessage("null")
+	}
+	m, ok := methods[req.Method]
+	if !ok {
+		res.Error = &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+		return res
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	result, err := m(ctx, sess, req.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeDaemonError, Message: err.Error()}
+		}
+		res.Error = rpcErr
+		return res
+	}
+	res.Result = result
+	return res
+}
+
+// param decodes params[i] into dest, optional params keep their value when missing
+func param(params []json.RawMessage, i int, dest interface{}, optional bool) error {
+	if i >= len(params) {
+		if optional {
+			return nil
+		}
+		return invalidParams("missing parameter %d", i)
+	}
+	if err := json.Unmarshal(params[i], dest); err != nil {
+		return invalidParams("invalid parameter %d: %s", i, err)
+	}
+	return nil
+}
+
+// scriptHashParam decodes a script hash (reversed hex) to the sha256 of the script
+func scriptHashParam(params []json.RawMessage) (string, []byte, error) {
+	var scriptHash string
+	if err := param(params, 0, &scriptHash, false); err != nil {
+		return "", nil, err
+	}
+	raw, err := hex.DecodeString(scriptHash)
+	if err != nil || len(raw) != sha256.Size {
+		return "", nil, invalidParams("invalid script hash %q", scriptHash)
+	}
+	for i, j := 0, len(raw)-1; i < j; i, j = i+1, j-1 {
+		raw[i], raw[j] = raw[j], raw[i]
+	}
+	return scriptHash, raw, nil
+}
+
+func (s *Server) history(scriptHash []byte) ([]historyItem, error) {
+	txs, err := s.index.ScriptHashTxs(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]historyItem, len(txs))
+	for i, tx := range txs {
+		history[i] = historyItem{Height: tx.Height, TransactionID: tx.TransactionID}
+	}
+	return history, nil
+}
+
+// scriptStatus is the sha256 of "txid:height:" for every transaction (hex), nil without history
+func (s *Server) scriptStatus(scriptHash string) (interface{}, error) {
+	raw, err := hex.DecodeString(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(raw)-1; i < j; i, j = i+1, j-1 {
+		raw[i], raw[j] = raw[j], raw[i]
+	}
+	history, err := s.history(raw)
+	if err != nil || len(history) == 0 {
+		return nil, err
+	}
+	h := sha256.New()
+	for _, item := range history {
+		fmt.Fprintf(h, "%s:%d:", item.TransactionID, item.Height)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// server.version(client_name, protocol_version)
+func serverVersion(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	return []string{sess.server.opts.ServerVersion, ProtocolVersion}, nil
+}
+
+// server.ping()
+func serverPing(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+// server.features()
+func serverFeatures(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	genesis, err := sess.server.client.GetBlockHashByHeight(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"genesis_hash":   genesis,
+		"hosts":          map[string]interface{}{},
+		"protocol_min":   ProtocolVersion,
+		"protocol_max":   ProtocolVersion,
+		"pruning":        nil,
+		"server_version": sess.server.opts.ServerVersion,
+		"hash_function":  "sha256",
+	}, nil
+}
+
+// server.banner() and server.donation_address()
+func serverBanner(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	return "", nil
+}
+
+// server.peers.subscribe(), this server doesn't know about other Electrum servers
+func serverPeers(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	return []interface{}{}, nil
+}
+
+// blockchain.headers.subscribe()
+func headersSubscribe(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	client := sess.server.client
+	height, err := client.BlockCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := client.GetBlockHashByHeight(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	header, err := client.GetBlockHeaderHex(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	sess.headers = true
+	sess.mu.Unlock()
+	return headerNotification{Hex: header, Height: height}, nil
+}
+
+// blockchain.block.header(height, cp_height=0)
+func blockHeader(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	var height, cpHeight int64
+	if err := param(params, 0, &height, false); err != nil {
+		return nil, err
+	}
+	if err := param(params, 1, &cpHeight, true); err != nil {
+		return nil, err
+	}
+	if cpHeight != 0 {
+		return nil, &Error{Code: CodeBadRequest, Message: "checkpoints (cp_height) are not supported"}
+	}
+	headers, err := sess.server.client.GetHeadersHex(ctx, height, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, &Error{Code: CodeBadRequest, Message: fmt.Sprintf("height %d out of range", height)}
+	}
+	return headers[0], nil
+}
+
+// blockchain.block.headers(start_height, count, cp_height=0)
+func blockHeaders(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	var start, count, cpHeight int64
+	if err := param(params, 0, &start, false); err != nil {
+		return nil, err
+	}
+	if err := param(params, 1, &count, false); err != nil {
+		return nil, err
+	}
+	if err := param(params, 2, &cpHeight, true); err != nil {
+		return nil, err
+	}
+	if cpHeight != 0 {
+		return nil, &Error{Code: CodeBadRequest, Message: "checkpoints (cp_height) are not supported"}
+	}
+	if count > bitcoind.MaxHeaderCount {
+		count = bitcoind.MaxHeaderCount
+	}
+	result := headersResult{Max: bitcoind.MaxHeaderCount}
+	if count <= 0 {
+		return result, nil
+	}
+	headers, err := sess.server.client.GetHeadersHex(ctx, start, count)
+	if err != nil {
+		return nil, err
+	}
+	result.Count = int64(len(headers))
+	for _, header := range headers {
+		result.Hex += header
+	}
+	return result, nil
+}
+
+// blockchain.scripthash.get_history(scripthash)
+func scriptHashHistory(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	_, scriptHash, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	return sess.server.history(scriptHash)
+}
+
+// blockchain.scripthash.get_balance(scripthash)
+func scriptHashBalance(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	_, scriptHash, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := sess.server.index.ScriptHashUTXOs(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	var b balance
+	for _, utxo := range utxos {
+		b.Confirmed += utxo.Value
+	}
+	return b, nil
+}
+
+// blockchain.scripthash.listunspent(scripthash)
+func scriptHashUnspent(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	_, scriptHash, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := sess.server.index.ScriptHashUTXOs(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	unspent := make([]unspentItem, len(utxos))
+	for i, utxo := range utxos {
+		unspent[i] = unspentItem{
+			Position:      utxo.Vout,
+			Value:         utxo.Value,
+			TransactionID: utxo.TransactionID,
+			Height:        utxo.Height,
+		}
+	}
+	return unspent, nil
+}
+
+// blockchain.scripthash.subscribe(scripthash)
+func scriptHashSubscribe(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	scriptHash, _, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	_, subscribed := sess.scripts[scriptHash]
+	full := len(sess.scripts) >= sess.server.opts.MaxSubscriptions
+	sess.mu.Unlock()
+	if !subscribed && full {
+		return nil, &Error{Code: CodeBadRequest, Message: fmt.Sprintf("too many subscriptions (max %d)", sess.server.opts.MaxSubscriptions)}
+	}
+	status, err := sess.server.scriptStatus(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	sess.scripts[scriptHash], _ = status.(string)
+	sess.mu.Unlock()
+	return status, nil
+}
+
+// blockchain.scripthash.unsubscribe(scripthash)
+func scriptHashUnsubscribe(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	scriptHash, _, err := scriptHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	_, subscribed := sess.scripts[scriptHash]
+	delete(sess.scripts, scriptHash)
+	return subscribed, nil
+}
+
+// blockchain.transaction.get(tx_hash, verbose=false)
+func transactionGet(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	var txid string
+	var verbose bool
+	if err := param(params, 0, &txid, false); err != nil {
+		return nil, err
+	}
+	if err := param(params, 1, &verbose, true); err != nil {
+		return nil, err
+	}
+	if raw, err := hex.DecodeString(txid); err != nil || len(raw) != 32 {
+		return nil, invalidParams("invalid transaction hash %q", txid)
+	}
+	tx, err := sess.server.client.GetTransactionInfo(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		return tx, nil
+	}
+	return tx.TransactionHex, nil
+}
+
+// blockchain.transaction.broadcast(raw_tx)
+func transactionBroadcast(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	var rawTx string
+	if err := param(params, 0, &rawTx, false); err != nil {
+		return nil, err
+	}
+	txid, err := sess.server.client.PushTransaction(ctx, rawTx, 0)
+	var rpcErr *bitcoind.RPCError
+	if errors.As(err, &rpcErr) {
+		// rejected by the node, not a problem with the node itself
+		return nil, &Error{Code: CodeBadRequest, Message: rpcErr.Message}
+	}
+	return txid, err
+}
+
+// blockchain.estimatefee(number), -1 if there is no estimate
+func estimateFee(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	var target int64
+	if err := param(params, 0, &target, false); err != nil {
+		return nil, err
+	}
+	if target < 1 {
+		target = 1
+	}
+	if target > bitcoind.MaxFeeTarget {
+		target = bitcoind.MaxFeeTarget
+	}
+	fees, err := sess.server.client.EstimateFees(ctx, []int64{target})
+	if err != nil {
+		return nil, err
+	}
+	if len(fees.Estimates) == 0 || fees.Estimates[0].Conservative <= 0 {
+		return -1, nil
+	}
+	// sat/vB to BTC/kB
+	return fees.Estimates[0].Conservative / 1e5, nil
+}
+
+// blockchain.relayfee(), in BTC/kB
+func relayFee(ctx context.Context, sess *session, params []json.RawMessage) (interface{}, error) {
+	info, err := sess.server.client.NetworkInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return info.RelayFee, nil
+}
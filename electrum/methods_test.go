@@ -0,0 +1,121 @@
+package electrum
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"gitlab.com/nolim1t/golang-httpd-test/indexer"
+)
+
+// An index of script hashes (the hex of the sha256, not reversed)
+type fakeIndex struct {
+	mu  sync.Mutex
+	txs map[string][]indexer.AddressTx
+}
+
+func (f *fakeIndex) set(scriptHash []byte, txs ...indexer.AddressTx) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.txs[hex.EncodeToString(scriptHash)] = txs
+}
+
+func (f *fakeIndex) ScriptHashTxs(scriptHash []byte) ([]indexer.AddressTx, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.txs[hex.EncodeToString(scriptHash)], nil
+}
+
+func (f *fakeIndex) ScriptHashUTXOs(scriptHash []byte) ([]indexer.UTXO, error) {
+	return nil, errors.New("not implemented")
+}
+
+// A script hash as sent by Electrum clients (reversed) and as kept in the index
+func testScript() (electrumHash string, scriptHash []byte) {
+	scriptHash = make([]byte, 32)
+	reversed := make([]byte, 32)
+	for i := range scriptHash {
+		scriptHash[i] = byte(i + 1)
+		reversed[31-i] = byte(i + 1)
+	}
+	return hex.EncodeToString(reversed), scriptHash
+}
+
+func rawParams(params ...string) []json.RawMessage {
+	raw := make([]json.RawMessage, len(params))
+	for i, p := range params {
+		raw[i] = json.RawMessage(p)
+	}
+	return raw
+}
+
+func TestParam(t *testing.T) {
+	params := rawParams(`5`, `"five"`)
+	var n int64
+	if err := param(params, 0, &n, false); err != nil || n != 5 {
+		t.Errorf("got %d, %v, want 5", n, err)
+	}
+	// optional params keep their value
+	n = 7
+	if err := param(params, 2, &n, true); err != nil || n != 7 {
+		t.Errorf("missing optional: got %d, %v, want 7", n, err)
+	}
+	var rpcErr *Error
+	if err := param(params, 2, &n, false); !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+		t.Errorf("missing: got %v, want invalid params", err)
+	}
+	if err := param(params, 1, &n, false); !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+		t.Errorf("wrong type: got %v, want invalid params", err)
+	}
+}
+
+func TestScriptHashParam(t *testing.T) {
+	electrumHash, want := testScript()
+	gotHash, got, err := scriptHashParam(rawParams(`"` + electrumHash + `"`))
+	if err != nil || gotHash != electrumHash || hex.EncodeToString(got) != hex.EncodeToString(want) {
+		t.Errorf("got %s %x, %v, want %s %x", gotHash, got, err, electrumHash, want)
+	}
+	for _, params := range [][]json.RawMessage{
+		nil,
+		rawParams(`"` + electrumHash[2:] + `"`),
+		rawParams(`"` + strings.Repeat("zz", 32) + `"`),
+		rawParams(`32`),
+	} {
+		var rpcErr *Error
+		if _, _, err := scriptHashParam(params); !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+			t.Errorf("%s: got %v, want invalid params", params, err)
+		}
+	}
+}
+
+func TestScriptStatus(t *testing.T) {
+	electrumHash, scriptHash := testScript()
+	index := &fakeIndex{txs: map[string][]indexer.AddressTx{}}
+	s := New(nil, index, nil, Options{})
+
+	if status, err := s.scriptStatus(electrumHash); status != nil || err != nil {
+		t.Errorf("without history: got %v, %v, want null", status, err)
+	}
+	index.set(scriptHash,
+		indexer.AddressTx{TransactionID: strings.Repeat("11", 32), Height: 100},
+		indexer.AddressTx{TransactionID: strings.Repeat("22", 32), Height: 101},
+	)
+	// sha256 of "<txid 1>:100:<txid 2>:101:"
+	want := "6e4a06dc49e3e27ce420d65a34d6d6c232282d240202ca70e2557e7064dc63b0"
+	if status, err := s.scriptStatus(electrumHash); status != want || err != nil {
+		t.Errorf("got %v, %v, want %s", status, err, want)
+	}
+}
@@ -0,0 +1,393 @@
+package electrum
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Electrum protocol server
+
+Newline delimited JSON-RPC 2.0 over TCP (or TLS, see Serve), single requests
+and batches of up to MaxBatchSize requests. Script hash queries are answered
+from the address index, so only confirmed transactions show up. Subscribers
+are notified about new headers on every new tip and about script hash status
+changes whenever the index caught up to a new block.
+
+Reference:
+https://electrumx-spesmilo.readthedocs.io/en/latest/protocol.html
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+	"gitlab.com/nolim1t/golang-httpd-test/indexer"
+)
+
+const (
+	ProtocolVersion = "1.4"
+
+	// Longest request line, enough for broadcasting the largest transaction as hex
+	MaxLineSize = 2*bitcoind.MaxTransactionSize + 4096
+	// Requests in a single batch, a larger batch is rejected as a whole
+	MaxBatchSize = 100
+
+	// Clients are expected to send server.ping well within this
+	idleTimeout    = 10 * time.Minute
+	writeTimeout   = 10 * time.Second
+	requestTimeout = 30 * time.Second
+	// Messages queued for a slow client before it is disconnected
+	sendBuffer = 100
+)
+
+type (
+	// The calls the Electrum server needs (implemented by bitcoind.Pool)
+	Client interface {
+		BlockCount(context.Context) (int64, error)
+		GetBlockHashByHeight(ctx context.Context, height int64) (string, error)
+		GetBlockHeaderHex(ctx context.Context, hash string) (string, error)
+		GetHeadersHex(ctx context.Context, from, count int64) ([]string, error)
+		GetTransactionInfo(context.Context, string) (bitcoind.VerboseTransactionInfo, error)
+		PushTransaction(ctx context.Context, hex string, maxFeeRate float64) (string, error)
+		EstimateFees(ctx context.Context, targets []int64) (bitcoind.FeeEstimates, error)
+		NetworkInfo(context.Context) (bitcoind.NetworkInfoResponse, error)
+	}
+
+	// Script hash lookups (implemented by indexer.Indexer)
+	Index interface {
+		ScriptHashTxs(scriptHash []byte) ([]indexer.AddressTx, error)
+		ScriptHashUTXOs(scriptHash []byte) ([]indexer.UTXO, error)
+	}
+
+	Options struct {
+		// Reported by server.version and server.features
+		ServerVersion string
+		// Open connections, 0 for no limit
+		MaxConnections int
+		// Script hash subscriptions per connection
+		MaxSubscriptions int
+	}
+
+	Server struct {
+		client Client
+		index  Index
+		bus    *events.Bus
+		opts   Options
+
+		mu       sync.Mutex
+		sessions map[*session]struct{}
+	}
+
+	// A single client connection
+	session struct {
+		server *Server
+		conn   net.Conn
+		send   chan []byte
+		done   chan struct{}
+		close  sync.Once
+
+		mu      sync.Mutex
+		headers bool              // subscribed to blockchain.headers.subscribe
+		scripts map[string]string // subscribed script hash => last status sent
+	}
+
+	request struct {
+		JSONRPC string            `json:"jsonrpc"`
+		ID      json.RawMessage   `json:"id"`
+		Method  string            `json:"method"`
+		Params  []json.RawMessage `json:"params"`
+	}
+
+	response struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result"`
+		Error   *Error          `json:"error,omitempty"`
+	}
+
+	// Without result on errors
+	errorResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Error   *Error          `json:"error"`
+	}
+
+	notification struct {
+		JSONRPC string        `json:"jsonrpc"`
+		Method  string        `json:"method"`
+		Params  []interface{} `json:"params"`
+	}
+)
+
+func New(client Client, index Index, bus *events.Bus, opts Options) *Server {
+	if opts.MaxSubscriptions <= 0 {
+		opts.MaxSubscriptions = 1000
+	}
+	if opts.ServerVersion == "" {
+		opts.ServerVersion = "golang-httpd-test"
+	}
+	return &Server{
+		client:   client,
+		index:    index,
+		bus:      bus,
+		opts:     opts,
+		sessions: map[*session]struct{}{},
+	}
+}
+
+// Serve accepts connections until the listener is closed or ctx is done.
+// Pass a tls.NewListener for TLS.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		sess := s.open(conn)
+		if sess == nil {
+			conn.Close()
+			continue
+		}
+		go sess.writeLoop()
+		go sess.readLoop(ctx)
+	}
+}
+
+// A response has either a result (which may be null) or an error
+func (r response) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(errorResponse{JSONRPC: r.JSONRPC, ID: r.ID, Error: r.Error})
+	}
+	type plain response
+	return json.Marshal(plain(r))
+}
+
+// Run sends notifications to subscribed clients until ctx is done
+func (s *Server) Run(ctx context.Context) {
+	sub := s.bus.Subscribe(events.DefaultBufferSize, events.TopicTip, events.TopicIndexed)
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			switch data := event.Data.(type) {
+			case events.Tip:
+				s.notifyHeader(ctx, data)
+			case events.Indexed:
+				s.notifyScripts()
+			}
+		}
+	}
+}
+
+// Connections returns the amount of open connections
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func (s *Server) open(conn net.Conn) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.MaxConnections > 0 && len(s.sessions) >= s.opts.MaxConnections {
+		return nil
+	}
+	sess := &session{
+		server:  s,
+		conn:    conn,
+		send:    make(chan []byte, sendBuffer),
+		done:    make(chan struct{}),
+		scripts: map[string]string{},
+	}
+	s.sessions[sess] = struct{}{}
+	return sess
+}
+
+func (s *Server) all() []*session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+func (s *Server) notifyHeader(ctx context.Context, tip events.Tip) {
+	var header interface{}
+	for _, sess := range s.all() {
+		sess.mu.Lock()
+		subscribed := sess.headers
+		sess.mu.Unlock()
+		if !subscribed {
+			continue
+		}
+		if header == nil {
+			ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+			headerHex, err := s.client.GetBlockHeaderHex(ctx, tip.Hash)
+			cancel()
+			if err != nil {
+				fmt.Printf("Electrum: can't get header %s: %s\n", tip.Hash, err)
+				return
+			}
+			header = headerNotification{Hex: headerHex, Height: tip.Height}
+		}
+		sess.notify("blockchain.headers.subscribe", header)
+	}
+}
+
+// notifyScripts sends the new status of every subscribed script hash that changed
+func (s *Server) notifyScripts() {
+	statuses := map[string]interface{}{} // shared between sessions
+	for _, sess := range s.all() {
+		sess.mu.Lock()
+		scripts := make(map[string]string, len(sess.scripts))
+		for scriptHash, status := range sess.scripts {
+			scripts[scriptHash] = status
+		}
+		sess.mu.Unlock()
+
+		for scriptHash, last := range scripts {
+			status, ok := statuses[scriptHash]
+			if !ok {
+				var err error
+				status, err = s.scriptStatus(scriptHash)
+				if err != nil {
+					fmt.Printf("Electrum: can't get status of %s: %s\n", scriptHash, err)
+					continue
+				}
+				statuses[scriptHash] = status
+			}
+			if current, _ := status.(string); current == last {
+				continue
+			}
+			sess.mu.Lock()
+			if _, ok := sess.scripts[scriptHash]; ok {
+				sess.scripts[scriptHash], _ = status.(string)
+			}
+			sess.mu.Unlock()
+			sess.notify("blockchain.scripthash.subscribe", scriptHash, status)
+		}
+	}
+}
+
+func (sess *session) readLoop(ctx context.Context) {
+	defer sess.shutdown()
+	scanner := bufio.NewScanner(sess.conn)
+	scanner.Buffer(make([]byte, 4096), MaxLineSize)
+	for {
+		sess.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if !scanner.Scan() {
+			return
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var reply interface{}
+		if line[0] == '[' {
+			var reqs []request
+			if err := json.Unmarshal(line, &reqs); err != nil || len(reqs) == 0 {
+				reply = response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: errParse}
+			} else if len(reqs) > MaxBatchSize {
+				reply = response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{
+					Code:    CodeInvalidRequest,
+					Message: fmt.Sprintf("batch of %d requests, at most %d are allowed", len(reqs), MaxBatchSize),
+				}}
+			} else {
+				replies := make([]response, len(reqs))
+				for i, req := range reqs {
+					replies[i] = sess.handle(ctx, req)
+				}
+				reply = replies
+			}
+		} else {
+			var req request
+			if err := json.Unmarshal(line, &req); err != nil {
+				reply = response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: errParse}
+			} else {
+				reply = sess.handle(ctx, req)
+			}
+		}
+		if !sess.write(reply) {
+			return
+		}
+	}
+}
+
+func (sess *session) writeLoop() {
+	defer sess.shutdown()
+	for {
+		select {
+		case <-sess.done:
+			return
+		case msg := <-sess.send:
+			sess.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := sess.conn.Write(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// write queues a message, a client that doesn't keep up is disconnected
+func (sess *session) write(msg interface{}) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Printf("Electrum: can't encode reply: %s\n", err)
+		return false
+	}
+	select {
+	case <-sess.done:
+		return false
+	case sess.send <- append(data, '\n'):
+		return true
+	default:
+		sess.shutdown()
+		return false
+	}
+}
+
+func (sess *session) notify(method string, params ...interface{}) {
+	sess.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (sess *session) shutdown() {
+	sess.close.Do(func() {
+		close(sess.done)
+		sess.conn.Close()
+		sess.server.mu.Lock()
+		delete(sess.server.sessions, sess)
+		sess.server.mu.Unlock()
+	})
+}
@@ -0,0 +1,177 @@
+package electrum
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+	"gitlab.com/nolim1t/golang-httpd-test/indexer"
+)
+
+// A node answering the calls for headers, the others aren't implemented
+type fakeNode struct {
+	Client
+}
+
+func (fakeNode) BlockCount(context.Context) (int64, error) {
+	return 100, nil
+}
+
+func (fakeNode) GetBlockHashByHeight(_ context.Context, height int64) (string, error) {
+	return fmt.Sprintf("hash%d", height), nil
+}
+
+func (fakeNode) GetBlockHeaderHex(_ context.Context, hash string) (string, error) {
+	return "header of " + hash, nil
+}
+
+// A connection to a test server
+type testConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func serve(t *testing.T, s *Server) (c *testConn, cleanup func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Serve(ctx, listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	return &testConn{conn: conn, r: bufio.NewReader(conn)}, func() {
+		conn.Close()
+		cancel()
+	}
+}
+
+// call sends a line and returns the reply line
+func (c *testConn) call(t *testing.T, line string) string {
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	return c.read(t)
+}
+
+func (c *testConn) read(t *testing.T) string {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(reply)
+}
+
+func TestResponseJSON(t *testing.T) {
+	for _, test := range []struct {
+		res  response
+		want string
+	}{
+		{response{JSONRPC: "2.0", ID: json.RawMessage("1")}, `{"jsonrpc":"2.0","id":1,"result":null}`},
+		{response{JSONRPC: "2.0", ID: json.RawMessage(`"a"`), Result: []int{1}}, `{"jsonrpc":"2.0","id":"a","result":[1]}`},
+		// no result next to an error
+		{response{JSONRPC: "2.0", ID: json.RawMessage("2"), Error: &Error{Code: CodeBadRequest, Message: "bad"}},
+			`{"jsonrpc":"2.0","id":2,"error":{"code":1,"message":"bad"}}`},
+	} {
+		got, err := json.Marshal(test.res)
+		if err != nil || string(got) != test.want {
+			t.Errorf("got %s, %v, want %s", got, err, test.want)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	c, cleanup := serve(t, New(fakeNode{}, &fakeIndex{}, nil, Options{}))
+	defer cleanup()
+
+	reply := c.call(t, `[{"jsonrpc":"2.0","id":1,"method":"server.ping"},{"jsonrpc":"2.0","id":"x","method":"no.such"},{"jsonrpc":"2.0","method":"server.version"}]`)
+	want := `[{"jsonrpc":"2.0","id":1,"result":null},` +
+		`{"jsonrpc":"2.0","id":"x","error":{"code":-32601,"message":"unknown method \"no.such\""}},` +
+		`{"jsonrpc":"2.0","id":null,"result":["golang-httpd-test","1.4"]}]`
+	if reply != want {
+		t.Errorf("got  %s\nwant %s", reply, want)
+	}
+
+	for _, line := range []string{`[]`, `[{"id":1`, `{"id":`} {
+		if got, want := c.call(t, line), `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid JSON"}}`; got != want {
+			t.Errorf("%s: got %s, want %s", line, got, want)
+		}
+	}
+}
+
+func TestBatchTooLarge(t *testing.T) {
+	c, cleanup := serve(t, New(fakeNode{}, &fakeIndex{}, nil, Options{}))
+	defer cleanup()
+
+	reqs := make([]string, MaxBatchSize+1)
+	for i := range reqs {
+		reqs[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"server.ping"}`, i)
+	}
+	var reply response
+	json.Unmarshal([]byte(c.call(t, "["+strings.Join(reqs, ",")+"]")), &reply)
+	if reply.Error == nil || reply.Error.Code != CodeInvalidRequest {
+		t.Fatalf("got %+v, want an invalid request error", reply)
+	}
+	// a batch of the largest size is answered
+	var replies []response
+	json.Unmarshal([]byte(c.call(t, "["+strings.Join(reqs[1:], ",")+"]")), &replies)
+	if len(replies) != MaxBatchSize {
+		t.Errorf("%d replies, want %d", len(replies), MaxBatchSize)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	electrumHash, scriptHash := testScript()
+	index := &fakeIndex{txs: map[string][]indexer.AddressTx{}}
+	s := New(fakeNode{}, index, nil, Options{})
+	c, cleanup := serve(t, s)
+	defer cleanup()
+
+	if got, want := c.call(t, `{"jsonrpc":"2.0","id":1,"method":"blockchain.headers.subscribe"}`),
+		`{"jsonrpc":"2.0","id":1,"result":{"hex":"header of hash100","height":100}}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if got, want := c.call(t, `{"jsonrpc":"2.0","id":2,"method":"blockchain.scripthash.subscribe","params":["`+electrumHash+`"]}`),
+		`{"jsonrpc":"2.0","id":2,"result":null}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	s.notifyHeader(context.Background(), events.Tip{Hash: "hash101", Height: 101})
+	if got, want := c.read(t), `{"jsonrpc":"2.0","method":"blockchain.headers.subscribe","params":[{"hex":"header of hash101","height":101}]}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// only a changed status is sent
+	s.notifyScripts()
+	index.set(scriptHash, indexer.AddressTx{TransactionID: strings.Repeat("11", 32), Height: 100})
+	s.notifyScripts()
+	want := `{"jsonrpc":"2.0","method":"blockchain.scripthash.subscribe","params":["` + electrumHash +
+		`","b464a7e7093a870ab2162fe8b91e608dfc846fe815a3d16100c986435794654e"]}`
+	if got := c.read(t); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	s.notifyScripts()
+	if got, want := c.call(t, `{"jsonrpc":"2.0","id":3,"method":"server.ping"}`), `{"jsonrpc":"2.0","id":3,"result":null}`; got != want {
+		t.Errorf("got %s, want %s (no notification for an unchanged status)", got, want)
+	}
+}
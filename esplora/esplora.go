@@ -0,0 +1,322 @@
+package esplora
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Esplora compatible REST API
+
+A subset of the Blockstream Esplora / mempool.space API on top of bitcoind, so
+wallets and tools speaking that dialect can use this server as a backend.
+Unlike the rest of /api, responses are not wrapped in a "message" object:
+plain text endpoints return plain text and errors are plain text too.
+
+Transactions need -txindex on the node (or to be in the mempool), prevouts of
+mempool transactions fall back to gettxout.
+
+Reference:
+https://github.com/Blockstream/esplora/blob/master/API.md
+*/
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+const (
+	// Mempool histogram bins are at least this many vbytes (same as electrs)
+	histogramBinSize = 50000
+)
+
+var (
+	// Confirmation targets of /fee-estimates
+	FeeTargets = []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 144, 504, 1008}
+)
+
+type (
+	// The calls the Esplora API needs (implemented by bitcoind.Pool)
+	Client interface {
+		BlockCount(context.Context) (int64, error)
+		GetBlockHashByHeight(ctx context.Context, height int64) (string, error)
+		GetBlock(ctx context.Context, hash string) (bitcoind.BitcoinBlockResponse, error)
+		GetBlockHeader(ctx context.Context, hash string) (bitcoind.BlockHeaderResponse, error)
+		GetTransactionInfo(context.Context, string) (bitcoind.VerboseTransactionInfo, error)
+		GetTransactions(ctx context.Context, txids []string) (map[string]bitcoind.VerboseTransactionInfo, error)
+		GetTxOut(ctx context.Context, txid string, vout int64, includeMempool bool) (*bitcoind.TxOutResponse, error)
+		PushTransaction(ctx context.Context, hex string, maxFeeRate float64) (string, error)
+		GetRawMempoolVerbose(context.Context) (map[string]bitcoind.MempoolEntry, error)
+		EstimateSmartFees(ctx context.Context, targets []int64, mode string) ([]bitcoind.SmartFeeResponse, error)
+	}
+
+	API struct {
+		client Client
+	}
+)
+
+func New(client Client) *API {
+	return &API{client: client}
+}
+
+// Register adds the endpoints to a router group (e.g. /esplora)
+func (a *API) Register(r gin.IRoutes) {
+	r.GET("/block/:hash", a.getBlock)
+	r.GET("/block-height/:height", a.getBlockHeight)
+	r.GET("/blocks/tip/height", a.getTipHeight)
+	r.GET("/tx/:txid", a.getTransaction)
+	r.GET("/tx/:txid/hex", a.getTransactionHex)
+	r.POST("/tx", a.broadcast)
+	r.GET("/mempool", a.getMempool)
+	r.GET("/fee-estimates", a.getFeeEstimates)
+}
+
+// 32 byte hashes (txids, block hashes) as hex
+func validHash(hash string) bool {
+	_, err := hex.DecodeString(hash)
+	return err == nil && len(hash) == 64
+}
+
+// Reply with a plain text error like Esplora: notFound with a 404 for unknown
+// blocks and transactions (if set) and a 400 for parameters bitcoind rejected
+func fail(c *gin.Context, err error, notFound string) {
+	var rpcErr *bitcoind.RPCError
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case bitcoind.RPCInvalidAddressOrKey:
+			if notFound != "" {
+				c.String(http.StatusNotFound, notFound)
+				return
+			}
+		case bitcoind.RPCInvalidParameter, bitcoind.RPCInvalidParams, bitcoind.RPCTypeError, bitcoind.RPCDeserializationError:
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	c.String(http.StatusInternalServerError, err.Error())
+}
+
+func (a *API) getBlock(c *gin.Context) {
+	hash := c.Param("hash")
+	if !validHash(hash) {
+		c.String(http.StatusBadRequest, "Invalid hex string")
+		return
+	}
+	block, err := a.client.GetBlock(c.Request.Context(), hash)
+	if err != nil {
+		fail(c, err, "Block not found")
+		return
+	}
+	c.JSON(http.StatusOK, newBlock(block))
+}
+
+func (a *API) getBlockHeight(c *gin.Context) {
+	height, err := strconv.ParseInt(c.Param("height"), 10, 64)
+	if err != nil || height < 0 {
+		c.String(http.StatusBadRequest, "Invalid height")
+		return
+	}
+	hash, err := a.client.GetBlockHashByHeight(c.Request.Context(), height)
+	var rpcErr *bitcoind.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == bitcoind.RPCInvalidParameter {
+		// the height is valid, so it is above the tip
+		c.String(http.StatusNotFound, "Block not found")
+		return
+	}
+	if err != nil {
+		fail(c, err, "Block not found")
+		return
+	}
+	c.String(http.StatusOK, hash)
+}
+
+func (a *API) getTipHeight(c *gin.Context) {
+	height, err := a.client.BlockCount(c.Request.Context())
+	if err != nil {
+		fail(c, err, "")
+		return
+	}
+	c.String(http.StatusOK, strconv.FormatInt(height, 10))
+}
+
+func (a *API) getTransaction(c *gin.Context) {
+	txid := c.Param("txid")
+	if !validHash(txid) {
+		c.String(http.StatusBadRequest, "Invalid hex string")
+		return
+	}
+	ctx := c.Request.Context()
+	tx, err := a.client.GetTransactionInfo(ctx, txid)
+	if err != nil {
+		fail(c, err, "Transaction not found")
+		return
+	}
+	prevouts, err := a.prevouts(ctx, tx)
+	if err != nil {
+		fail(c, err, "Transaction not found")
+		return
+	}
+	t := newTransaction(tx, prevouts)
+	if tx.Blockhash != "" {
+		header, err := a.client.GetBlockHeader(ctx, tx.Blockhash)
+		if err != nil {
+			fail(c, err, "Block not found")
+			return
+		}
+		t.Status = TxStatus{
+			Confirmed:   true,
+			BlockHeight: header.Height,
+			BlockHash:   tx.Blockhash,
+			BlockTime:   tx.Blocktime,
+		}
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// prevouts looks up the outputs spent by a transaction, keyed by "txid:vout"
+func (a *API) prevouts(ctx context.Context, tx bitcoind.VerboseTransactionInfo) (prevouts map[string]Vout, err error) {
+	var txids []string
+	seen := map[string]bool{}
+	for _, vin := range tx.Vin {
+		if vin.Coinbase == "" && !seen[vin.TransactionID] {
+			seen[vin.TransactionID] = true
+			txids = append(txids, vin.TransactionID)
+		}
+	}
+	prevouts = map[string]Vout{}
+	if len(txids) == 0 {
+		return
+	}
+	prevTxs, err := a.client.GetTransactions(ctx, txids)
+	if err != nil {
+		return
+	}
+	for _, vin := range tx.Vin {
+		if vin.Coinbase != "" {
+			continue
+		}
+		if prevTx, ok := prevTxs[vin.TransactionID]; ok {
+			if vin.VoutID >= 0 && vin.VoutID < int64(len(prevTx.Vout)) {
+				out := prevTx.Vout[vin.VoutID]
+				prevouts[outpoint(vin.TransactionID, vin.VoutID)] = newVout(out.TransactionValue, out.ScriptPubKey)
+			}
+			continue
+		}
+		// no -txindex, the output is still in the UTXO set if tx is unconfirmed
+		txOut, err := a.client.GetTxOut(ctx, vin.TransactionID, vin.VoutID, false)
+		if err != nil {
+			return nil, err
+		}
+		if txOut != nil {
+			prevouts[outpoint(vin.TransactionID, vin.VoutID)] = newVout(txOut.Value, txOut.ScriptPubKey)
+		}
+	}
+	return
+}
+
+func (a *API) getTransactionHex(c *gin.Context) {
+	txid := c.Param("txid")
+	if !validHash(txid) {
+		c.String(http.StatusBadRequest, "Invalid hex string")
+		return
+	}
+	tx, err := a.client.GetTransactionInfo(c.Request.Context(), txid)
+	if err != nil {
+		fail(c, err, "Transaction not found")
+		return
+	}
+	c.String(http.StatusOK, tx.TransactionHex)
+}
+
+// The raw transaction is the request body (hex), replies with the txid
+func (a *API) broadcast(c *gin.Context) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, 2*bitcoind.MaxTransactionSize+2))
+	if err != nil {
+		c.String(http.StatusRequestEntityTooLarge, "Transaction too large")
+		return
+	}
+	txid, err := a.client.PushTransaction(c.Request.Context(), strings.TrimSpace(string(body)), 0)
+	if err != nil {
+		var rpcErr *bitcoind.RPCError
+		if errors.As(err, &rpcErr) {
+			rpcJSON, _ := json.Marshal(rpcErr)
+			c.String(http.StatusBadRequest, fmt.Sprintf("sendrawtransaction RPC error: %s", rpcJSON))
+			return
+		}
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, txid)
+}
+
+func (a *API) getMempool(c *gin.Context) {
+	entries, err := a.client.GetRawMempoolVerbose(c.Request.Context())
+	if err != nil {
+		fail(c, err, "")
+		return
+	}
+	type feeRate struct {
+		rate  float64
+		vsize int64
+	}
+	mempool := Mempool{Count: int64(len(entries)), FeeHistogram: [][2]float64{}}
+	rates := make([]feeRate, 0, len(entries))
+	for _, entry := range entries {
+		fee := sats(entry.Fees.Base)
+		mempool.VSize += entry.VSize
+		mempool.TotalFee += fee
+		if entry.VSize > 0 {
+			rates = append(rates, feeRate{float64(fee) / float64(entry.VSize), entry.VSize})
+		}
+	}
+	// highest fee rate first, a bin is closed once it is larger than histogramBinSize
+	sort.Slice(rates, func(i, j int) bool { return rates[i].rate > rates[j].rate })
+	var binSize int64
+	var lastRate float64
+	for _, r := range rates {
+		if binSize > histogramBinSize && r.rate != lastRate {
+			mempool.FeeHistogram = append(mempool.FeeHistogram, [2]float64{lastRate, float64(binSize)})
+			binSize = 0
+		}
+		lastRate = r.rate
+		binSize += r.vsize
+	}
+	if binSize > 0 {
+		mempool.FeeHistogram = append(mempool.FeeHistogram, [2]float64{lastRate, float64(binSize)})
+	}
+	c.JSON(http.StatusOK, mempool)
+}
+
+// Conservative estimates in sat/vB keyed by confirmation target, as bitcoind
+// returns them: targets it has no estimate for are left out
+func (a *API) getFeeEstimates(c *gin.Context) {
+	fees, err := a.client.EstimateSmartFees(c.Request.Context(), FeeTargets, bitcoind.EstimateModeConservative)
+	if err != nil {
+		fail(c, err, "")
+		return
+	}
+	estimates := make(map[string]float64, len(fees))
+	for i, fee := range fees {
+		if fee.FeeRate > 0 {
+			estimates[strconv.FormatInt(FeeTargets[i], 10)] = bitcoind.BTCPerKvBToSatPerVB(fee.FeeRate)
+		}
+	}
+	c.JSON(http.StatusOK, estimates)
+}
@@ -0,0 +1,110 @@
+package esplora
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+// A node answering the calls under test, the others aren't implemented
+type fakeNode struct {
+	Client
+	err       error // returned by every call
+	estimates []bitcoind.SmartFeeResponse
+}
+
+func (f *fakeNode) BlockCount(context.Context) (int64, error) {
+	return 100, f.err
+}
+
+func (f *fakeNode) GetBlockHashByHeight(context.Context, int64) (string, error) {
+	return "", f.err
+}
+
+func (f *fakeNode) GetBlock(context.Context, string) (bitcoind.BitcoinBlockResponse, error) {
+	return bitcoind.BitcoinBlockResponse{}, f.err
+}
+
+func (f *fakeNode) EstimateSmartFees(_ context.Context, targets []int64, mode string) ([]bitcoind.SmartFeeResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if mode != bitcoind.EstimateModeConservative || len(targets) != len(f.estimates) {
+		return nil, errors.New("unexpected call")
+	}
+	return f.estimates, nil
+}
+
+func get(node *fakeNode, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	New(node).Register(r)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestErrorStatus(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	for _, test := range []struct {
+		path   string
+		err    error
+		status int
+		body   string // prefix
+	}{
+		{"/block/" + hash, &bitcoind.RPCError{Code: bitcoind.RPCInvalidAddressOrKey, Message: "Block not found"}, 404, "Block not found"},
+		{"/block/" + hash, &bitcoind.RPCError{Code: bitcoind.RPCInvalidParameter, Message: "bad"}, 400, "bitcoind error (-8)"},
+		{"/block/xyz", nil, 400, "Invalid hex string"},
+		{"/block-height/1000", &bitcoind.RPCError{Code: bitcoind.RPCInvalidParameter, Message: "Block height out of range"}, 404, "Block not found"},
+		{"/block-height/-1", nil, 400, "Invalid height"},
+		{"/blocks/tip/height", &bitcoind.RPCError{Code: bitcoind.RPCInWarmup, Message: "Loading block index..."}, 500, "bitcoind error (-28)"},
+		{"/blocks/tip/height", errors.New("connection refused"), 500, "connection refused"},
+		{"/fee-estimates", &bitcoind.RPCError{Code: bitcoind.RPCInvalidAddressOrKey, Message: "x"}, 500, "bitcoind error (-5)"},
+	} {
+		w := get(&fakeNode{err: test.err}, test.path)
+		if w.Code != test.status || !strings.HasPrefix(w.Body.String(), test.body) {
+			t.Errorf("%s with %v: got %d %q, want %d %q", test.path, test.err, w.Code, w.Body.String(), test.status, test.body)
+		}
+	}
+}
+
+func TestFeeEstimatesAsEstimated(t *testing.T) {
+	node := &fakeNode{estimates: make([]bitcoind.SmartFeeResponse, len(FeeTargets))}
+	for i := range node.estimates {
+		node.estimates[i].Errors = []string{"Insufficient data or no feerate found"}
+	}
+	// below any mempool minimum fee, reported as is
+	node.estimates[0] = bitcoind.SmartFeeResponse{FeeRate: 0.00002, Blocks: 2}
+	node.estimates[len(FeeTargets)-1] = bitcoind.SmartFeeResponse{FeeRate: 0.0000025, Blocks: 1008}
+
+	w := get(node, "/fee-estimates")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	var estimates map[string]float64
+	if err := json.Unmarshal(w.Body.Bytes(), &estimates); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"1": 2, "1008": 0.25}
+	if len(estimates) != len(want) || estimates["1"] != want["1"] || estimates["1008"] != want["1008"] {
+		t.Errorf("got %v, want %v", estimates, want)
+	}
+}
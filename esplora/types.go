@@ -0,0 +1,220 @@
+package esplora
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Esplora response shapes
+
+Amounts are in satoshis and fee rates in sat/vB, like Esplora.
+
+Reference:
+https://github.com/Blockstream/esplora/blob/master/API.md
+*/
+
+import (
+	"math"
+	"strconv"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+type (
+	// GET /block/:hash
+	Block struct {
+		ID                string  `json:"id"`
+		Height            int64   `json:"height"`
+		Version           int64   `json:"version"`
+		Timestamp         int64   `json:"timestamp"`
+		TxCount           int64   `json:"tx_count"`
+		Size              int64   `json:"size"`
+		Weight            int64   `json:"weight"`
+		MerkleRoot        string  `json:"merkle_root"`
+		PreviousBlockHash *string `json:"previousblockhash"` // null for the genesis block
+		MedianTime        int64   `json:"mediantime"`
+		Nonce             int64   `json:"nonce"`
+		Bits              uint32  `json:"bits"`
+		Difficulty        float64 `json:"difficulty"`
+	}
+
+	// GET /tx/:txid
+	Transaction struct {
+		TransactionID string   `json:"txid"`
+		Version       int64    `json:"version"`
+		LockTime      int64    `json:"locktime"`
+		Vin           []Vin    `json:"vin"`
+		Vout          []Vout   `json:"vout"`
+		Size          int64    `json:"size"`
+		Weight        int64    `json:"weight"`
+		Fee           int64    `json:"fee"`
+		Status        TxStatus `json:"status"`
+	}
+
+	Vin struct {
+		TransactionID string   `json:"txid"`
+		Vout          int64    `json:"vout"`
+		Prevout       *Vout    `json:"prevout"` // null for coinbase inputs
+		ScriptSig     string   `json:"scriptsig"`
+		ScriptSigASM  string   `json:"scriptsig_asm"`
+		Witness       []string `json:"witness,omitempty"`
+		IsCoinbase    bool     `json:"is_coinbase"`
+		Sequence      int64    `json:"sequence"`
+	}
+
+	Vout struct {
+		ScriptPubKey        string `json:"scriptpubkey"`
+		ScriptPubKeyASM     string `json:"scriptpubkey_asm"`
+		ScriptPubKeyType    string `json:"scriptpubkey_type"`
+		ScriptPubKeyAddress string `json:"scriptpubkey_address,omitempty"`
+		Value               int64  `json:"value"`
+	}
+
+	// Only confirmed is set for mempool transactions
+	TxStatus struct {
+		Confirmed   bool   `json:"confirmed"`
+		BlockHeight int64  `json:"block_height,omitempty"`
+		BlockHash   string `json:"block_hash,omitempty"`
+		BlockTime   int64  `json:"block_time,omitempty"`
+	}
+
+	// GET /mempool
+	Mempool struct {
+		Count        int64        `json:"count"`
+		VSize        int64        `json:"vsize"`
+		TotalFee     int64        `json:"total_fee"`
+		FeeHistogram [][2]float64 `json:"fee_histogram"` // [fee rate, vsize] from the highest fee rate down
+	}
+)
+
+const (
+	// Esplora's outpoint for coinbase inputs
+	coinbaseTxID = "0000000000000000000000000000000000000000000000000000000000000000"
+	coinbaseVout = 0xffffffff
+)
+
+var (
+	// bitcoind script types to Esplora's
+	scriptTypes = map[string]string{
+		"pubkey":                "p2pk",
+		"pubkeyhash":            "p2pkh",
+		"scripthash":            "p2sh",
+		"witness_v0_keyhash":    "v0_p2wpkh",
+		"witness_v0_scripthash": "v0_p2wsh",
+		"witness_v1_taproot":    "v1_p2tr",
+		"nulldata":              "op_return",
+		"multisig":              "multisig",
+		"anchor":                "anchor",
+	}
+)
+
+// BTC to satoshis
+func sats(btc float64) int64 {
+	return int64(math.Round(btc * 1e8))
+}
+
+func newBlock(block bitcoind.BitcoinBlockResponse) Block {
+	bits, _ := strconv.ParseUint(block.Bits, 16, 32)
+	b := Block{
+		ID:         block.Hash,
+		Height:     block.Height,
+		Version:    block.Version,
+		Timestamp:  block.Time,
+		TxCount:    int64(len(block.Transactions)),
+		Size:       block.Size,
+		Weight:     block.Weight,
+		MerkleRoot: block.MerkleRoot,
+		MedianTime: block.MedianTime,
+		Nonce:      block.Nonce,
+		Bits:       uint32(bits),
+		Difficulty: block.Difficulty,
+	}
+	if block.PreviousBlockHash != "" {
+		b.PreviousBlockHash = &block.PreviousBlockHash
+	}
+	return b
+}
+
+func newVout(value float64, script bitcoind.ScriptPubKeyObj) Vout {
+	scriptType, ok := scriptTypes[script.ScriptType]
+	if !ok {
+		scriptType = "unknown"
+	}
+	if script.HexCode == "" {
+		scriptType = "empty"
+	}
+	address := script.Address
+	if address == "" && len(script.TransactionAddresses) == 1 {
+		address = script.TransactionAddresses[0]
+	}
+	return Vout{
+		ScriptPubKey:        script.HexCode,
+		ScriptPubKeyASM:     script.ASMCode,
+		ScriptPubKeyType:    scriptType,
+		ScriptPubKeyAddress: address,
+		Value:               sats(value),
+	}
+}
+
+// newTransaction converts a getrawtransaction result, prevouts are keyed by "txid:vout".
+// The fee is only known when every prevout is.
+func newTransaction(tx bitcoind.VerboseTransactionInfo, prevouts map[string]Vout) Transaction {
+	t := Transaction{
+		TransactionID: tx.TransactionID,
+		Version:       tx.Version,
+		LockTime:      tx.LockTime,
+		Vin:           make([]Vin, len(tx.Vin)),
+		Vout:          make([]Vout, len(tx.Vout)),
+		Size:          tx.TransactionSize,
+		Weight:        tx.Weight,
+	}
+	var in, out int64
+	complete := true
+	for i, vin := range tx.Vin {
+		if vin.Coinbase != "" {
+			t.Vin[i] = Vin{
+				TransactionID: coinbaseTxID,
+				Vout:          coinbaseVout,
+				ScriptSig:     vin.Coinbase,
+				Witness:       vin.Witness,
+				IsCoinbase:    true,
+				Sequence:      vin.Sequence,
+			}
+			complete = false
+			continue
+		}
+		t.Vin[i] = Vin{
+			TransactionID: vin.TransactionID,
+			Vout:          vin.VoutID,
+			Witness:       vin.Witness,
+			Sequence:      vin.Sequence,
+		}
+		if vin.ScriptSig != nil {
+			t.Vin[i].ScriptSig, t.Vin[i].ScriptSigASM = vin.ScriptSig.HexCode, vin.ScriptSig.ASMCode
+		}
+		if prevout, ok := prevouts[outpoint(vin.TransactionID, vin.VoutID)]; ok {
+			t.Vin[i].Prevout = &prevout
+			in += prevout.Value
+		} else {
+			complete = false
+		}
+	}
+	for i, vout := range tx.Vout {
+		t.Vout[i] = newVout(vout.TransactionValue, vout.ScriptPubKey)
+		out += t.Vout[i].Value
+	}
+	if complete {
+		t.Fee = in - out
+	}
+	return t
+}
+
+func outpoint(txid string, vout int64) string {
+	return txid + ":" + strconv.FormatInt(vout, 10)
+}
@@ -0,0 +1,123 @@
+package events
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Event bus
+
+In-process publish/subscribe for chain events. Publishing never blocks: every
+subscriber has its own buffered channel and events that don't fit are dropped
+for that subscriber only (and counted), so a slow consumer can't hold up the
+ZMQ subscriber or any other consumer.
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Buffer size used when Subscribe is called with a buffer <= 0
+	DefaultBufferSize = 64
+)
+
+type (
+	Event struct {
+		Topic  string      `json:"topic"`
+		Time   time.Time   `json:"time"`
+		Source string      `json:"source,omitempty"` // bitcoind backend the event came from
+		Data   interface{} `json:"data"`
+	}
+
+	Bus struct {
+		mu   sync.RWMutex
+		subs map[*Subscription]struct{}
+	}
+
+	Subscription struct {
+		// Events are received from C, it is closed on Unsubscribe
+		C <-chan Event
+
+		c       chan Event
+		topics  map[string]bool // nil means all topics
+		bus     *Bus
+		dropped uint64
+		once    sync.Once
+	}
+)
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe to the given topics, or to everything if no topics are given
+func (b *Bus) Subscribe(buffer int, topics ...string) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBufferSize
+	}
+	s := &Subscription{c: make(chan Event, buffer), bus: b}
+	s.C = s.c
+	if len(topics) > 0 {
+		s.topics = make(map[string]bool, len(topics))
+		for _, topic := range topics {
+			s.topics[topic] = true
+		}
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish sends an event to every subscriber of its topic without blocking
+func (b *Bus) Publish(topic, source string, data interface{}) {
+	event := Event{
+		Topic:  topic,
+		Time:   time.Now(),
+		Source: source,
+		Data:   data,
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.topics != nil && !s.topics[topic] {
+			continue
+		}
+		select {
+		case s.c <- event:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Subscribers returns the amount of active subscriptions
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
+
+// Unsubscribe stops delivery and closes C. Safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.c)
+	})
+}
+
+// Dropped returns how many events didn't fit in the buffer so far
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
@@ -0,0 +1,135 @@
+package events
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Event topics and their Data types
+*/
+
+import "time"
+
+const (
+	TopicHashBlock = "hashblock" // BlockHash
+	TopicRawBlock  = "rawblock"  // RawBlock
+	TopicRawTx     = "rawtx"     // RawTx
+	TopicSequence  = "sequence"  // Sequence
+	TopicZMQGap    = "zmqgap"    // Gap
+
+	// A rawtx that came with a tx_added sequence, so it entered the mempool (not from a block)
+	TopicMempoolTx = "mempool_tx" // RawTx
+
+	// Published by chainwatch
+	TopicBlock        = "block"         // Block
+	TopicTip          = "tip"           // Tip
+	TopicMempoolStats = "mempool_stats" // MempoolStats
+	TopicReorg        = "reorg"         // Reorg
+
+	// Published by the address indexer
+	TopicIndexed = "indexed" // Indexed
+
+	// Labels of a Sequence event
+	SequenceBlockConnected    = "block_connected"
+	SequenceBlockDisconnected = "block_disconnected"
+	SequenceTxAdded           = "tx_added"
+	SequenceTxRemoved         = "tx_removed"
+)
+
+type (
+	// A new chain tip
+	BlockHash struct {
+		Hash string `json:"hash"`
+	}
+
+	// A new block, Raw is the serialized block
+	RawBlock struct {
+		Hash         string `json:"hash"`
+		PreviousHash string `json:"previousblockhash"`
+		Time         int64  `json:"time"`
+		Size         int    `json:"size"`
+		TxCount      int    `json:"txcount"`
+		Raw          []byte `json:"-"`
+	}
+
+	// A transaction entering the mempool or being mined, Raw is the serialized transaction
+	RawTx struct {
+		TxID  string `json:"txid"`
+		WTxID string `json:"wtxid"`
+		Size  int    `json:"size"`
+		VSize int    `json:"vsize"`
+		Raw   []byte `json:"-"`
+	}
+
+	// Block (dis)connected or transaction added to/removed from the mempool
+	Sequence struct {
+		Hash            string `json:"hash"`
+		Label           string `json:"label"`
+		MempoolSequence uint64 `json:"mempool_sequence,omitempty"` // only for tx_added and tx_removed
+	}
+
+	// Notifications were missed on a ZMQ topic (or bitcoind restarted)
+	Gap struct {
+		Endpoint string `json:"endpoint"`
+		ZMQTopic string `json:"zmq_topic"`
+		Expected uint32 `json:"expected"`
+		Received uint32 `json:"received"`
+	}
+
+	// A block connected to the best chain
+	Block struct {
+		Hash         string `json:"hash"`
+		Height       int64  `json:"height"`
+		PreviousHash string `json:"previousblockhash"`
+		Time         int64  `json:"time"`
+		Size         int64  `json:"size"`
+		Weight       int64  `json:"weight"`
+		TxCount      int    `json:"txcount"`
+	}
+
+	// The best chain has a new tip
+	Tip struct {
+		Hash   string `json:"hash"`
+		Height int64  `json:"height"`
+		Time   int64  `json:"time"`
+	}
+
+	// The address index caught up to a new block (or rolled back to it)
+	Indexed struct {
+		Hash   string `json:"hash"`
+		Height int64  `json:"height"`
+	}
+
+	// Blocks of the best chain were replaced, Disconnected and Connected are ordered by height
+	Reorg struct {
+		Time         time.Time  `json:"time"`
+		Depth        int        `json:"depth"` // blocks disconnected
+		ForkHash     string     `json:"fork_hash"`
+		ForkHeight   int64      `json:"fork_height"`
+		OldTip       BlockRef   `json:"old_tip"`
+		NewTip       BlockRef   `json:"new_tip"`
+		Disconnected []BlockRef `json:"disconnected"`
+		Connected    []BlockRef `json:"connected"`
+	}
+
+	BlockRef struct {
+		Hash   string `json:"hash"`
+		Height int64  `json:"height"`
+	}
+
+	// Mempool size and fees (sat/vB)
+	MempoolStats struct {
+		Size          int64   `json:"size"`
+		Bytes         int64   `json:"bytes"`
+		Usage         int64   `json:"usage"`
+		MaxMempool    int64   `json:"maxmempool"`
+		MempoolMinFee float64 `json:"mempoolminfee"`
+		MinRelayTxFee float64 `json:"minrelaytxfee"`
+	}
+)
@@ -3,13 +3,18 @@ module gitlab.com/nolim1t/golang-httpd-test
 go 1.15
 
 require (
+	github.com/btcsuite/btcd v0.20.1-beta.0.20200515232429-9f0179fd2c46
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-contrib/gzip v0.0.3
 	github.com/gin-gonic/gin v1.6.3
+	github.com/gorilla/websocket v1.4.2
+	github.com/lightninglabs/gozmq v0.0.0-20191113021534-d20a764486bf
 	github.com/lightninglabs/lndclient v1.0.0 // indirect
 	github.com/pelletier/go-toml v1.8.1
 	github.com/sirupsen/logrus v1.4.2
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.0.0-20200510223506-06a226fb4e37
 	google.golang.org/grpc v1.33.2 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
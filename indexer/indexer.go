@@ -0,0 +1,628 @@
+package indexer
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Address indexer
+
+Walks the chain block by block (getblockhash + getblock with verbosity 2) and
+stores, per address and per script hash (as used by Electrum), which outputs
+funded it and which inputs spent from it, in a bbolt database. Only confirmed
+transactions are indexed.
+
+Buckets:
+	meta        version, height, hash  format and tip of the index
+	outputs     outpoint             => value, height, script hash, address
+	spends      outpoint             => spending txid, input index, height
+	history     address|0|entry      => value (and the spent outpoint for spends)
+	scripthash  script hash|entry    => same as history
+	undo        height               => what the block added, to roll it back on a reorg
+
+An entry is height|position in block|txid|kind|index. Outpoints are the 32 byte
+txid (as displayed) followed by the 4 byte output index, integers are big
+endian so keys sort by height and position. Script hashes are sha256 of the
+script (not reversed like Electrum displays them). Undo data is kept for the
+last MaxReorgDepth blocks, a deeper reorg needs a reindex. Changing the format
+bumps dbVersion, which drops and rebuilds the index.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+)
+
+const (
+	// Blocks that can be rolled back
+	MaxReorgDepth = 100
+
+	DefaultInterval = 30 * time.Second
+
+	// Bump when the layout of the buckets changes
+	dbVersion = 2
+
+	kindFunding = 'F'
+	kindSpend   = 'S'
+)
+
+var (
+	bucketMeta       = []byte("meta")
+	bucketOutputs    = []byte("outputs")
+	bucketSpends     = []byte("spends")
+	bucketHistory    = []byte("history")
+	bucketScriptHash = []byte("scripthash")
+	bucketUndo       = []byte("undo")
+	// everything but meta
+	dataBuckets = [][]byte{bucketOutputs, bucketSpends, bucketHistory, bucketScriptHash, bucketUndo}
+
+	keyVersion = []byte("version")
+	keyHeight  = []byte("height")
+	keyHash    = []byte("hash")
+
+	ErrReorgTooDeep = errors.New("reorg deeper than the index can roll back, please reindex")
+)
+
+type (
+	// The calls the indexer needs (implemented by bitcoind.Pool)
+	Client interface {
+		BlockCount(context.Context) (int64, error)
+		GetBlockHashByHeight(ctx context.Context, height int64) (string, error)
+		GetBlockWithTransactions(ctx context.Context, hash string) (bitcoind.BitcoinBlockVerboseResponse, error)
+	}
+
+	Indexer struct {
+		db          *bolt.DB
+		client      Client
+		bus         *events.Bus
+		startHeight int64
+		interval    time.Duration
+
+		mu     sync.RWMutex
+		status Status
+	}
+
+	Status struct {
+		Height    int64     `json:"height"` // -1 before the first block is indexed
+		Hash      string    `json:"hash"`
+		Synced    bool      `json:"synced"`
+		LastSync  time.Time `json:"last_sync"`
+		LastError string    `json:"error,omitempty"`
+	}
+
+	// A transaction involving an address (or script), amounts in satoshis
+	AddressTx struct {
+		TransactionID string `json:"txid"`
+		Height        int64  `json:"height"`
+		Received      int64  `json:"received"` // outputs paying to the address
+		Sent          int64  `json:"sent"`     // inputs spending from the address
+	}
+
+	// An unspent output of an address (or script), value in satoshis
+	UTXO struct {
+		TransactionID string `json:"txid"`
+		Vout          uint32 `json:"vout"`
+		Value         int64  `json:"value"`
+		Height        int64  `json:"height"`
+	}
+
+	// Everything a block added, keys per bucket
+	undo struct {
+		Hash         string   `json:"hash"`
+		PreviousHash string   `json:"previousblockhash"`
+		Outputs      [][]byte `json:"outputs"`
+		Spends       [][]byte `json:"spends"`
+		History      [][]byte `json:"history"`
+		ScriptHashes [][]byte `json:"scripthashes"`
+	}
+)
+
+// Open opens (or creates) the index database. Blocks below startHeight are
+// skipped, so funds received before it are unknown to the index.
+func Open(path string, client Client, bus *events.Bus, startHeight int64, interval time.Duration) (*Indexer, error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if startHeight < 0 {
+		startHeight = 0
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can't open index %s: %w", path, err)
+	}
+	ix := &Indexer{
+		db:          db,
+		client:      client,
+		bus:         bus,
+		startHeight: startHeight,
+		interval:    interval,
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		version := meta.Get(keyVersion)
+		if meta.Get(keyHeight) != nil && (version == nil || binary.BigEndian.Uint32(version) != dbVersion) {
+			fmt.Printf("Address index: database format changed, reindexing\n")
+			for _, name := range dataBuckets {
+				if tx.Bucket(name) == nil {
+					continue
+				}
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			if err := meta.Delete(keyHeight); err != nil {
+				return err
+			}
+			if err := meta.Delete(keyHash); err != nil {
+				return err
+			}
+		}
+		if err := meta.Put(keyVersion, uint32Bytes(dbVersion)); err != nil {
+			return err
+		}
+		for _, name := range dataBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	height, hash := ix.tip()
+	ix.status = Status{Height: height, Hash: hash}
+
+	return ix, nil
+}
+
+func (ix *Indexer) Close() error {
+	return ix.db.Close()
+}
+
+// Run syncs on an interval and whenever the chain watcher sees a new tip
+func (ix *Indexer) Run(ctx context.Context) {
+	var tips <-chan events.Event
+	if ix.bus != nil {
+		sub := ix.bus.Subscribe(8, events.TopicTip)
+		defer sub.Unsubscribe()
+		tips = sub.C
+	}
+	ticker := time.NewTicker(ix.interval)
+	defer ticker.Stop()
+	for {
+		err := ix.Sync(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Address index: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tips:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ix *Indexer) Status() Status {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.status
+}
+
+// setStatus records the result of a sync and publishes the new tip if it changed
+func (ix *Indexer) setStatus(synced bool, err error) {
+	height, hash := ix.tip()
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if hash != ix.status.Hash && hash != "" && ix.bus != nil {
+		ix.bus.Publish(events.TopicIndexed, "indexer", events.Indexed{Hash: hash, Height: height})
+	}
+	ix.status.Height, ix.status.Hash, ix.status.Synced = height, hash, synced
+	ix.status.LastSync = time.Now()
+	ix.status.LastError = ""
+	if err != nil {
+		ix.status.LastError = err.Error()
+	}
+}
+
+// tip returns the last indexed block, startHeight-1 and "" if there is none
+func (ix *Indexer) tip() (height int64, hash string) {
+	height = ix.startHeight - 1
+	_ = ix.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if h := meta.Get(keyHeight); h != nil {
+			height = int64(binary.BigEndian.Uint64(h))
+			hash = string(meta.Get(keyHash))
+		}
+		return nil
+	})
+	return
+}
+
+// Sync indexes blocks up to the tip of the node, rolling back blocks that left the best chain
+func (ix *Indexer) Sync(ctx context.Context) (err error) {
+	synced := false
+	defer func() { ix.setStatus(synced, err) }()
+
+	nodeHeight, err := ix.client.BlockCount(ctx)
+	if err != nil {
+		return
+	}
+	for ctx.Err() == nil {
+		height, hash := ix.tip()
+		if height >= nodeHeight {
+			// caught up, make sure the tip is still in the best chain
+			if hash != "" {
+				nodeHash, err := ix.client.GetBlockHashByHeight(ctx, height)
+				if err == nil && nodeHash != hash {
+					if err = ix.disconnect(height); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			synced = true
+			return nil
+		}
+
+		nextHash, err := ix.client.GetBlockHashByHeight(ctx, height+1)
+		if err != nil {
+			return err
+		}
+		block, err := ix.client.GetBlockWithTransactions(ctx, nextHash)
+		if err != nil {
+			return err
+		}
+		if hash != "" && block.PreviousBlockHash != hash {
+			if err = ix.disconnect(height); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = ix.connect(block); err != nil {
+			return err
+		}
+		if block.Height%1000 == 0 {
+			fmt.Printf("Address index at block %d\n", block.Height)
+		}
+	}
+	return ctx.Err()
+}
+
+// connect indexes a block on top of the current tip
+func (ix *Indexer) connect(block bitcoind.BitcoinBlockVerboseResponse) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		outputs, spends := tx.Bucket(bucketOutputs), tx.Bucket(bucketSpends)
+		history, scriptHistory := tx.Bucket(bucketHistory), tx.Bucket(bucketScriptHash)
+		u := undo{Hash: block.Hash, PreviousHash: block.PreviousBlockHash}
+		height := block.Height
+
+		// adds an entry to the address (if any) and script hash history
+		addHistory := func(address string, scriptHash []byte, entry, value []byte) error {
+			if address != "" {
+				key := append(append([]byte(address), 0), entry...)
+				if err := history.Put(key, value); err != nil {
+					return err
+				}
+				u.History = append(u.History, key)
+			}
+			key := append(append([]byte{}, scriptHash...), entry...)
+			u.ScriptHashes = append(u.ScriptHashes, key)
+			return scriptHistory.Put(key, value)
+		}
+
+		for pos, t := range block.Transactions {
+			txid, err := hex.DecodeString(t.TransactionID)
+			if err != nil || len(txid) != 32 {
+				return fmt.Errorf("block %d: invalid txid %q", height, t.TransactionID)
+			}
+			for i, in := range t.Vin {
+				if in.Coinbase != "" {
+					continue
+				}
+				spent, err := outpoint(in.TransactionID, uint32(in.VoutID))
+				if err != nil {
+					return err
+				}
+				output := outputs.Get(spent)
+				if output == nil {
+					// OP_RETURN, or created before the start height
+					continue
+				}
+				address, scriptHash, value, _ := decodeOutput(output)
+				spend := make([]byte, 32+4+8)
+				copy(spend, txid)
+				binary.BigEndian.PutUint32(spend[32:], uint32(i))
+				binary.BigEndian.PutUint64(spend[36:], uint64(height))
+				if err := spends.Put(spent, spend); err != nil {
+					return err
+				}
+				u.Spends = append(u.Spends, spent)
+				entry := historyEntry(height, uint32(pos), txid, kindSpend, uint32(i))
+				if err := addHistory(address, scriptHash, entry, historyValue(value, spent)); err != nil {
+					return err
+				}
+			}
+			for _, out := range t.Vout {
+				script, err := hex.DecodeString(out.ScriptPubKey.HexCode)
+				if err != nil || out.ScriptPubKey.ScriptType == "nulldata" {
+					continue
+				}
+				scriptHash := sha256.Sum256(script)
+				address := outputAddress(out.ScriptPubKey)
+				value := int64(math.Round(out.TransactionValue * 1e8))
+				created := append(append([]byte{}, txid...), uint32Bytes(uint32(out.TransactionIndex))...)
+				if err := outputs.Put(created, encodeOutput(address, scriptHash[:], value, height)); err != nil {
+					return err
+				}
+				u.Outputs = append(u.Outputs, created)
+				entry := historyEntry(height, uint32(pos), txid, kindFunding, uint32(out.TransactionIndex))
+				if err := addHistory(address, scriptHash[:], entry, historyValue(value, nil)); err != nil {
+					return err
+				}
+			}
+		}
+
+		undoBucket := tx.Bucket(bucketUndo)
+		undoData, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if err := undoBucket.Put(uint64Bytes(uint64(height)), undoData); err != nil {
+			return err
+		}
+		if height >= MaxReorgDepth {
+			if err := undoBucket.Delete(uint64Bytes(uint64(height - MaxReorgDepth))); err != nil {
+				return err
+			}
+		}
+		return setTip(tx, height, block.Hash)
+	})
+}
+
+// disconnect rolls back the block at height (the current tip)
+func (ix *Indexer) disconnect(height int64) error {
+	err := ix.db.Update(func(tx *bolt.Tx) error {
+		undoBucket := tx.Bucket(bucketUndo)
+		undoData := undoBucket.Get(uint64Bytes(uint64(height)))
+		if undoData == nil {
+			return ErrReorgTooDeep
+		}
+		var u undo
+		if err := json.Unmarshal(undoData, &u); err != nil {
+			return err
+		}
+		for bucket, keys := range map[string][][]byte{
+			string(bucketHistory):    u.History,
+			string(bucketScriptHash): u.ScriptHashes,
+			string(bucketSpends):     u.Spends,
+			string(bucketOutputs):    u.Outputs,
+		} {
+			b := tx.Bucket([]byte(bucket))
+			for _, key := range keys {
+				if err := b.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		if err := undoBucket.Delete(uint64Bytes(uint64(height))); err != nil {
+			return err
+		}
+		if height <= ix.startHeight {
+			meta := tx.Bucket(bucketMeta)
+			if err := meta.Delete(keyHeight); err != nil {
+				return err
+			}
+			return meta.Delete(keyHash)
+		}
+		return setTip(tx, height-1, u.PreviousHash)
+	})
+	if err == nil {
+		fmt.Printf("Address index: rolled back block %d\n", height)
+	}
+	return err
+}
+
+// AddressTxs returns the transactions of an address, newest first
+func (ix *Indexer) AddressTxs(address string, skip, limit int) ([]AddressTx, error) {
+	return ix.txs(bucketHistory, append([]byte(address), 0), skip, limit, true)
+}
+
+// AddressUTXOs returns the unspent outputs of an address, newest first
+func (ix *Indexer) AddressUTXOs(address string) ([]UTXO, error) {
+	return ix.utxos(bucketHistory, append([]byte(address), 0))
+}
+
+// ScriptHashTxs returns all transactions of a script, oldest first (in block order)
+func (ix *Indexer) ScriptHashTxs(scriptHash []byte) ([]AddressTx, error) {
+	return ix.txs(bucketScriptHash, scriptHash, 0, math.MaxInt32, false)
+}
+
+// ScriptHashUTXOs returns the unspent outputs of a script, newest first
+func (ix *Indexer) ScriptHashUTXOs(scriptHash []byte) ([]UTXO, error) {
+	return ix.utxos(bucketScriptHash, scriptHash)
+}
+
+// txs aggregates the history entries under prefix per transaction
+func (ix *Indexer) txs(bucket, prefix []byte, skip, limit int, newestFirst bool) (txs []AddressTx, err error) {
+	txs = []AddressTx{}
+	err = ix.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		var k, v []byte
+		next := c.Next
+		if newestFirst {
+			next = c.Prev
+			k, v = c.Seek(append(append([]byte{}, prefix...), bytes.Repeat([]byte{0xff}, 8)...))
+			if k == nil {
+				k, v = c.Last()
+			} else if !bytes.HasPrefix(k, prefix) {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Seek(prefix)
+		}
+		current, count := "", 0
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = next() {
+			height, _, txid, kind, _ := parseHistoryEntry(k[len(prefix):])
+			// entries of the same transaction are next to each other
+			if id := hex.EncodeToString(txid); id != current {
+				current = id
+				count++
+				if count > skip+limit {
+					break
+				}
+				if count > skip {
+					txs = append(txs, AddressTx{TransactionID: id, Height: height})
+				}
+			}
+			if count <= skip {
+				continue
+			}
+			value := int64(binary.BigEndian.Uint64(v))
+			if kind == kindFunding {
+				txs[len(txs)-1].Received += value
+			} else {
+				txs[len(txs)-1].Sent += value
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// utxos returns the funding entries under prefix which are not spent, newest first
+func (ix *Indexer) utxos(bucket, prefix []byte) (utxos []UTXO, err error) {
+	utxos = []UTXO{}
+	err = ix.db.View(func(tx *bolt.Tx) error {
+		spends := tx.Bucket(bucketSpends)
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			height, _, txid, kind, index := parseHistoryEntry(k[len(prefix):])
+			if kind != kindFunding {
+				continue
+			}
+			created := append(append([]byte{}, txid...), uint32Bytes(index)...)
+			if spends.Get(created) != nil {
+				continue
+			}
+			utxos = append(utxos, UTXO{
+				TransactionID: hex.EncodeToString(txid),
+				Vout:          index,
+				Value:         int64(binary.BigEndian.Uint64(v)),
+				Height:        height,
+			})
+		}
+		return nil
+	})
+	// the cursor walks the entries oldest first
+	for i, j := 0, len(utxos)-1; i < j; i, j = i+1, j-1 {
+		utxos[i], utxos[j] = utxos[j], utxos[i]
+	}
+	return
+}
+
+func setTip(tx *bolt.Tx, height int64, hash string) error {
+	meta := tx.Bucket(bucketMeta)
+	if err := meta.Put(keyHeight, uint64Bytes(uint64(height))); err != nil {
+		return err
+	}
+	return meta.Put(keyHash, []byte(hash))
+}
+
+// outputAddress returns "" for outputs without an address (bare multisig, ...)
+func outputAddress(script bitcoind.ScriptPubKeyObj) string {
+	if script.Address != "" {
+		return script.Address
+	}
+	// bitcoind before 22
+	if len(script.TransactionAddresses) == 1 {
+		return script.TransactionAddresses[0]
+	}
+	return ""
+}
+
+func outpoint(txid string, vout uint32) ([]byte, error) {
+	raw, err := hex.DecodeString(txid)
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("invalid txid %q", txid)
+	}
+	return append(raw, uint32Bytes(vout)...), nil
+}
+
+// height(8) position(4) txid(32) kind(1) index(4)
+func historyEntry(height int64, pos uint32, txid []byte, kind byte, index uint32) []byte {
+	entry := make([]byte, 0, 8+4+32+1+4)
+	entry = append(entry, uint64Bytes(uint64(height))...)
+	entry = append(entry, uint32Bytes(pos)...)
+	entry = append(entry, txid...)
+	entry = append(entry, kind)
+	return append(entry, uint32Bytes(index)...)
+}
+
+// parseHistoryEntry parses a history key without the address or script hash prefix
+func parseHistoryEntry(entry []byte) (height int64, pos uint32, txid []byte, kind byte, index uint32) {
+	height = int64(binary.BigEndian.Uint64(entry[:8]))
+	pos = binary.BigEndian.Uint32(entry[8:12])
+	txid = entry[12:44]
+	kind = entry[44]
+	index = binary.BigEndian.Uint32(entry[45:49])
+	return
+}
+
+// value(8) [spent outpoint(36)]
+func historyValue(value int64, spent []byte) []byte {
+	return append(uint64Bytes(uint64(value)), spent...)
+}
+
+// value(8) height(8) script hash(32) address
+func encodeOutput(address string, scriptHash []byte, value, height int64) []byte {
+	data := append(uint64Bytes(uint64(value)), uint64Bytes(uint64(height))...)
+	data = append(data, scriptHash...)
+	return append(data, address...)
+}
+
+func decodeOutput(data []byte) (address string, scriptHash []byte, value, height int64) {
+	return string(data[48:]), data[16:48], int64(binary.BigEndian.Uint64(data[:8])), int64(binary.BigEndian.Uint64(data[8:16]))
+}
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func uint32Bytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
@@ -0,0 +1,292 @@
+package indexer
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+// A node with a best chain and every block it has seen
+type fakeNode struct {
+	best   []string // hashes by height
+	blocks map[string]bitcoind.BitcoinBlockVerboseResponse
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{blocks: make(map[string]bitcoind.BitcoinBlockVerboseResponse)}
+}
+
+// mine adds a block on top of the best chain at height (dropping the blocks above it)
+func (f *fakeNode) mine(height int64, hash string, txs ...bitcoind.VerboseTransactionInfo) {
+	block := bitcoind.BitcoinBlockVerboseResponse{Transactions: txs}
+	block.Hash, block.Height = hash, height
+	if height > 0 {
+		block.PreviousBlockHash = f.best[height-1]
+	}
+	f.best = append(f.best[:height], hash)
+	f.blocks[hash] = block
+}
+
+func (f *fakeNode) BlockCount(context.Context) (int64, error) {
+	return int64(len(f.best) - 1), nil
+}
+
+func (f *fakeNode) GetBlockHashByHeight(_ context.Context, height int64) (string, error) {
+	if height < 0 || height >= int64(len(f.best)) {
+		return "", errors.New("block height out of range")
+	}
+	return f.best[height], nil
+}
+
+func (f *fakeNode) GetBlockWithTransactions(_ context.Context, hash string) (bitcoind.BitcoinBlockVerboseResponse, error) {
+	block, ok := f.blocks[hash]
+	if !ok {
+		return block, errors.New("block not found")
+	}
+	return block, nil
+}
+
+// txid returns a 32 byte txid named n
+func txid(n byte) string {
+	return strings.Repeat(fmt.Sprintf("%02x", n), 32)
+}
+
+// An output of value BTC to address
+type out struct {
+	address string
+	value   float64
+}
+
+// transaction spends the given outpoints ("txid:vout"), none makes it a coinbase
+func transaction(id string, spends []string, outs ...out) bitcoind.VerboseTransactionInfo {
+	t := bitcoind.VerboseTransactionInfo{TransactionID: id}
+	if len(spends) == 0 {
+		t.Vin = []bitcoind.TransactionInput{{Coinbase: "03"}}
+	}
+	for _, spent := range spends {
+		var in bitcoind.TransactionInput
+		fmt.Sscanf(strings.Replace(spent, ":", " ", 1), "%s %d", &in.TransactionID, &in.VoutID)
+		t.Vin = append(t.Vin, in)
+	}
+	for n, o := range outs {
+		t.Vout = append(t.Vout, bitcoind.TransactionOutput{
+			TransactionValue: o.value,
+			TransactionIndex: int64(n),
+			ScriptPubKey: bitcoind.ScriptPubKeyObj{
+				HexCode:    "0014" + hex.EncodeToString([]byte(fmt.Sprintf("%-20s", o.address))),
+				ScriptType: "witness_v0_keyhash",
+				Address:    o.address,
+			},
+		})
+	}
+	return t
+}
+
+func openIndex(t *testing.T, path string, client Client) *Indexer {
+	ix, err := Open(path, client, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+func tempIndexPath(t *testing.T) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "indexer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "index.db"), func() { os.RemoveAll(dir) }
+}
+
+// summary lists the transactions of an address, newest first, as txid byte:received:sent (satoshis)
+func summary(t *testing.T, ix *Indexer, address string) string {
+	txs, err := ix.AddressTxs(address, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []string
+	for _, tx := range txs {
+		parts = append(parts, fmt.Sprintf("%s@%d:%d:%d", tx.TransactionID[:2], tx.Height, tx.Received, tx.Sent))
+	}
+	return strings.Join(parts, " ")
+}
+
+func utxoSummary(t *testing.T, ix *Indexer, address string) string {
+	utxos, err := ix.AddressUTXOs(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []string
+	for _, utxo := range utxos {
+		parts = append(parts, fmt.Sprintf("%s:%d=%d", utxo.TransactionID[:2], utxo.Vout, utxo.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// A chain where alice mines block 0 and pays bob in block 1
+func aliceAndBob() *fakeNode {
+	node := newFakeNode()
+	node.mine(0, "a0", transaction(txid(1), nil, out{"alice", 50}))
+	node.mine(1, "a1",
+		transaction(txid(2), nil, out{"miner", 50}),
+		transaction(txid(3), []string{txid(1) + ":0"}, out{"bob", 30}, out{"alice", 20}),
+	)
+	return node
+}
+
+func TestSyncAndRollback(t *testing.T) {
+	path, cleanup := tempIndexPath(t)
+	defer cleanup()
+	node := aliceAndBob()
+	// bob pays carol in block 2
+	node.mine(2, "a2", transaction(txid(4), []string{txid(3) + ":0"}, out{"carol", 29}))
+	ix := openIndex(t, path, node)
+	defer ix.Close()
+	ctx := context.Background()
+
+	if err := ix.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := ix.Status(); status.Height != 2 || status.Hash != "a2" || !status.Synced {
+		t.Fatalf("status %+v, want synced at a2", status)
+	}
+	if got, want := summary(t, ix, "bob"), "04@2:0:3000000000 03@1:3000000000:0"; got != want {
+		t.Errorf("bob: got %s, want %s", got, want)
+	}
+	if got, want := summary(t, ix, "alice"), "03@1:2000000000:5000000000 01@0:5000000000:0"; got != want {
+		t.Errorf("alice: got %s, want %s", got, want)
+	}
+	if got := utxoSummary(t, ix, "bob"); got != "" {
+		t.Errorf("bob's utxos: got %s, want none", got)
+	}
+
+	// block 2 is replaced by two blocks without bob's payment, which rolls it back
+	node.mine(2, "b2", transaction(txid(5), nil, out{"miner", 50}))
+	node.mine(3, "b3", transaction(txid(6), nil, out{"alice", 50}))
+	if err := ix.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := ix.Status(); status.Height != 3 || status.Hash != "b3" {
+		t.Fatalf("status %+v, want b3", status)
+	}
+	if got := summary(t, ix, "carol"); got != "" {
+		t.Errorf("carol after the reorg: got %s, want nothing", got)
+	}
+	if got, want := summary(t, ix, "bob"), "03@1:3000000000:0"; got != want {
+		t.Errorf("bob after the reorg: got %s, want %s", got, want)
+	}
+	if got, want := utxoSummary(t, ix, "bob"), "03:0=3000000000"; got != want {
+		t.Errorf("bob's utxos after the reorg: got %s, want %s", got, want)
+	}
+	if got, want := utxoSummary(t, ix, "alice"), "06:0=5000000000 03:1=2000000000"; got != want {
+		t.Errorf("alice's utxos after the reorg: got %s, want %s", got, want)
+	}
+
+	// a tip replaced at the same height is rolled back too
+	node.mine(3, "c3", transaction(txid(7), nil, out{"miner", 50}))
+	if err := ix.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := ix.Status(); status.Hash != "c3" {
+		t.Fatalf("status %+v, want c3", status)
+	}
+	if got, want := utxoSummary(t, ix, "alice"), "03:1=2000000000"; got != want {
+		t.Errorf("alice's utxos after replacing the tip: got %s, want %s", got, want)
+	}
+}
+
+func TestReorgDeeperThanUndoData(t *testing.T) {
+	path, cleanup := tempIndexPath(t)
+	defer cleanup()
+	node := newFakeNode()
+	for height := int64(0); height <= MaxReorgDepth+1; height++ {
+		node.mine(height, fmt.Sprintf("a%d", height))
+	}
+	ix := openIndex(t, path, node)
+	defer ix.Close()
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// rolls back MaxReorgDepth blocks, then runs out of undo data
+	node.mine(0, "b0")
+	for height := int64(1); height <= MaxReorgDepth+2; height++ {
+		node.mine(height, fmt.Sprintf("b%d", height))
+	}
+	if err := ix.Sync(context.Background()); !errors.Is(err, ErrReorgTooDeep) {
+		t.Fatalf("got %v, want ErrReorgTooDeep", err)
+	}
+	if status := ix.Status(); status.Height != 1 || status.Synced || status.LastError == "" {
+		t.Errorf("status %+v, want stuck at 1 with an error", status)
+	}
+}
+
+func TestFormatChangeReindexes(t *testing.T) {
+	path, cleanup := tempIndexPath(t)
+	defer cleanup()
+	node := aliceAndBob()
+	ix := openIndex(t, path, node)
+	if err := ix.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := summary(t, ix, "alice")
+	ix.Close()
+
+	for name, downgrade := range map[string]func(meta *bolt.Bucket) error{
+		"version 1": func(meta *bolt.Bucket) error { return meta.Put(keyVersion, uint32Bytes(1)) },
+		// indexes from before the version was stored
+		"no version": func(meta *bolt.Bucket) error { return meta.Delete(keyVersion) },
+	} {
+		db, err := bolt.Open(path, 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Update(func(tx *bolt.Tx) error { return downgrade(tx.Bucket(bucketMeta)) })
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ix = openIndex(t, path, node)
+		if status := ix.Status(); status.Height != -1 || status.Hash != "" {
+			t.Errorf("%s: status %+v, want an empty index", name, status)
+		}
+		if got := summary(t, ix, "alice"); got != "" {
+			t.Errorf("%s: alice before reindexing: got %s, want nothing", name, got)
+		}
+		if err := ix.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := summary(t, ix, "alice"); got != want {
+			t.Errorf("%s: alice after reindexing: got %s, want %s", name, got, want)
+		}
+		ix.Close()
+	}
+
+	// the current version is kept as is
+	ix = openIndex(t, path, node)
+	defer ix.Close()
+	if status := ix.Status(); status.Height != 1 || status.Hash != "a1" {
+		t.Errorf("reopened: status %+v, want a1", status)
+	}
+}
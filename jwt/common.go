@@ -22,6 +22,11 @@ func stringtofile(filename string) string {
 
 // Methods
 func SignKey(keyfile string, Username string) string {
+	return SignKeyWithRole(keyfile, Username, "")
+}
+
+// Sign a key with a role claim (e.g. "admin"), no role claim if Role is empty
+func SignKeyWithRole(keyfile string, Username string, Role string) string {
 	// Get byte output for filename
 	signed_key, err := ioutil.ReadFile(keyfile)
 	if err != nil {
@@ -31,6 +36,9 @@ func SignKey(keyfile string, Username string) string {
 	// If no error
 	claims := jwt.MapClaims{}
 	claims["user"] = Username
+	if Role != "" {
+		claims["role"] = Role
+	}
 	// 2 hours
 	claims["exp"] = time.Now().Add(time.Minute * 60 * 2).Unix()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -50,7 +58,7 @@ func ValidateKey(keyfile string, Token string) (string, error) {
 	token, err := jwt.Parse(Token, func(token *jwt.Token) (interface{}, error) {
 		return jwtfile, nil
 	})
-	if token.Valid {
+	if token != nil && token.Valid {
 		// TODO: Return a struct with valid and error
 		claims := token.Claims.(jwt.MapClaims)
 		fmt.Printf("user: %s\n", claims["user"])
@@ -67,3 +75,25 @@ func ValidateKey(keyfile string, Token string) (string, error) {
 		return "-2", errors.New("Token not valid")
 	}
 }
+
+// Validate a key and return its user and role claims (role is empty if the key has none)
+func KeyClaims(keyfile string, Token string) (user string, role string, err error) {
+	validate_key, err := ValidateKey(keyfile, Token)
+	if validate_key != "valid" {
+		return "", "", err
+	}
+	jwtfile, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		return "", "", errors.New("Error reading file")
+	}
+	token, err := jwt.Parse(Token, func(token *jwt.Token) (interface{}, error) {
+		return jwtfile, nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	user, _ = claims["user"].(string)
+	role, _ = claims["role"].(string)
+	return user, role, nil
+}
@@ -12,21 +12,42 @@ OTHER DEALINGS IN THE SOFTWARE.
 */
 import (
 	// System Libraries
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	//"io/ioutil"
-	//"net/http"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	// External libraries
 	// mine
 	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
 	"gitlab.com/nolim1t/golang-httpd-test/btcprice"
+	"gitlab.com/nolim1t/golang-httpd-test/chainwatch"
 	"gitlab.com/nolim1t/golang-httpd-test/common"
+	"gitlab.com/nolim1t/golang-httpd-test/electrum"
+	"gitlab.com/nolim1t/golang-httpd-test/esplora"
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+	"gitlab.com/nolim1t/golang-httpd-test/indexer"
 	"gitlab.com/nolim1t/golang-httpd-test/jwt"
+	"gitlab.com/nolim1t/golang-httpd-test/mempool"
+	"gitlab.com/nolim1t/golang-httpd-test/nettotals"
 	"gitlab.com/nolim1t/golang-httpd-test/pineclient"
+	"gitlab.com/nolim1t/golang-httpd-test/stream"
+	"gitlab.com/nolim1t/golang-httpd-test/txwatch"
+	"gitlab.com/nolim1t/golang-httpd-test/zmq"
 
 	// github
 	"github.com/gin-contrib/cors"
@@ -35,6 +56,7 @@ import (
 	"github.com/pelletier/go-toml"
 	log "github.com/sirupsen/logrus"
 	// non-github
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
@@ -42,19 +64,67 @@ import (
 type (
 	// How to read from Bitcoin client
 	BitcoinClient interface {
-		BlockCount() (int64, error)
-		BlockchainInfo() (bitcoind.BlockchainInfoResponse, error)
-		NetworkInfo() (bitcoind.NetworkInfoResponse, error)
-		GetTransactionInfo(string) (bitcoind.VerboseTransactionInfo, error)
-		GetMempoolContents() ([]string, error)
-		PushTransaction(hex string) (string, error)
-		GetBestBlockHash() (string, error)
-		GetBlockHashByHeight(height int64) (string, error)
-		GetBlock(hash string) (bitcoind.BitcoinBlockResponse, error)
-		GetMempoolInfo() (bitcoind.MempoolInfoResponse, error)
-		GetMiningInfo() (bitcoind.MiningInfoResponse, error)
-		GetPeerInfo() ([]bitcoind.PeerInfo, error)
-		GetBlockStats(int64) (bitcoind.BlockStatsResponse, error)
+		BlockCount(context.Context) (int64, error)
+		BlockchainInfo(context.Context) (bitcoind.BlockchainInfoResponse, error)
+		NetworkInfo(context.Context) (bitcoind.NetworkInfoResponse, error)
+		GetTransactionInfo(context.Context, string) (bitcoind.VerboseTransactionInfo, error)
+		GetTransactions(ctx context.Context, txids []string) (map[string]bitcoind.VerboseTransactionInfo, error)
+		GetMempoolContents(context.Context) ([]string, error)
+		PushTransaction(ctx context.Context, hex string, maxFeeRate float64) (string, error)
+		TestMempoolAccept(ctx context.Context, rawTxs []string, maxFeeRate float64) ([]bitcoind.MempoolAcceptResult, error)
+		GetBestBlockHash(context.Context) (string, error)
+		GetBlockHashByHeight(ctx context.Context, height int64) (string, error)
+		GetBlock(ctx context.Context, hash string) (bitcoind.BitcoinBlockResponse, error)
+		GetBlockRange(ctx context.Context, from, to int64) ([]bitcoind.BitcoinBlockResponse, error)
+		GetBlockWithTransactions(ctx context.Context, hash string) (bitcoind.BitcoinBlockVerboseResponse, error)
+		GetBlockHex(ctx context.Context, hash string) (string, error)
+		StreamBlockHex(ctx context.Context, hash string) (io.ReadCloser, error)
+		StreamRawTransactionHex(ctx context.Context, txid string) (io.ReadCloser, error)
+		GetMempoolInfo(context.Context) (bitcoind.MempoolInfoResponse, error)
+		GetRawMempoolVerbose(context.Context) (map[string]bitcoind.MempoolEntry, error)
+		GetMempoolEntry(ctx context.Context, txid string) (bitcoind.MempoolEntry, error)
+		GetMempoolAncestors(ctx context.Context, txid string) (map[string]bitcoind.MempoolEntry, error)
+		GetMempoolDescendants(ctx context.Context, txid string) (map[string]bitcoind.MempoolEntry, error)
+		GetMiningInfo(context.Context) (bitcoind.MiningInfoResponse, error)
+		EstimateFees(ctx context.Context, targets []int64) (bitcoind.FeeEstimates, error)
+		EstimateSmartFees(ctx context.Context, targets []int64, mode string) ([]bitcoind.SmartFeeResponse, error)
+		GetPeerInfo(context.Context) ([]bitcoind.PeerInfo, error)
+		GetNetTotals(context.Context) (bitcoind.NetTotalsResponse, error)
+		// Peer management
+		// on every backend
+		AddNode(ctx context.Context, node, command string) []bitcoind.BackendResult
+		DisconnectNode(ctx context.Context, address string, nodeID int64) []bitcoind.BackendResult
+		SetBan(ctx context.Context, subnet, command string, banTime int64, absolute bool) []bitcoind.BackendResult
+		ListBanned(ctx context.Context) []bitcoind.BackendResult
+		ClearBanned(ctx context.Context) []bitcoind.BackendResult
+		SetNetworkActive(ctx context.Context, active bool) []bitcoind.BackendResult
+		GetBlockStats(context.Context, int64) (bitcoind.BlockStatsResponse, error)
+		GetBlockStatsRange(ctx context.Context, from, to int64, stats []string) ([]bitcoind.BlockStats, error)
+		// Watch-only wallets
+		CreateWallet(ctx context.Context, name string, descriptors bool) (bitcoind.WalletResponse, error)
+		LoadWallet(ctx context.Context, name string) (bitcoind.WalletResponse, error)
+		ImportAddress(ctx context.Context, walletName, address, label string, rescan bool) error
+		ImportDescriptors(ctx context.Context, walletName string, descriptors []bitcoind.ImportDescriptorRequest) ([]bitcoind.ImportDescriptorResult, error)
+		ListReceivedByAddress(ctx context.Context, walletName string, minConf int64, includeEmpty bool, address string) ([]bitcoind.ReceivedByAddress, error)
+		ListTransactions(ctx context.Context, walletName string, count, skip int64) ([]bitcoind.WalletTransaction, error)
+		ListAddressTransactions(ctx context.Context, walletName, address string, count, skip int64) ([]bitcoind.WalletTransaction, error)
+		GetNewAddress(ctx context.Context, walletName, label, addressType string) (string, error)
+		// Decoding
+		DecodeRawTransaction(ctx context.Context, hex string) (bitcoind.VerboseTransactionInfo, error)
+		DecodeScript(ctx context.Context, hex string) (bitcoind.DecodeScriptResponse, error)
+		DecodePSBT(ctx context.Context, psbt string) (bitcoind.DecodePSBTResponse, error)
+		AnalyzePSBT(ctx context.Context, psbt string) (bitcoind.AnalyzePSBTResponse, error)
+		// Headers
+		GetBlockHeader(ctx context.Context, hash string) (bitcoind.BlockHeaderResponse, error)
+		GetBlockHeaderHex(ctx context.Context, hash string) (string, error)
+		GetHeaders(ctx context.Context, from, count int64) ([]bitcoind.BlockHeaderResponse, error)
+		GetHeadersHex(ctx context.Context, from, count int64) ([]string, error)
+		GetChainTips(ctx context.Context) ([]bitcoind.ChainTip, error)
+		// UTXO set
+		GetTxOut(ctx context.Context, txid string, vout int64, includeMempool bool) (*bitcoind.TxOutResponse, error)
+		StartScan(ctx context.Context, descriptors []string) (bitcoind.ScanStatus, error)
+		ScanStatus(ctx context.Context) (bitcoind.ScanStatus, bool)
+		AbortScan(ctx context.Context) (bitcoind.ScanStatus, error)
 	}
 )
 
@@ -63,16 +133,31 @@ var (
 	version, gitHash string
 	// Accessing bitcoinclient
 	btcClient BitcoinClient
+	// All bitcoind backends (btcClient goes through this)
+	btcPool *bitcoind.Pool
+	// Chain events (ZMQ notifications, chain watcher)
+	eventBus     = events.NewBus()
+	chainWatcher *chainwatch.Watcher
+	// Mempool histogram and stats (nil when disabled)
+	mempoolAnalyzer *mempool.Analyzer
+	// Bandwidth history (nil when disabled)
+	netTotalsSampler *nettotals.Sampler
+	// Address index (nil unless [indexer] is enabled)
+	addressIndex *indexer.Indexer
+	// Transaction watches (nil unless [txwatch] is enabled)
+	txWatcher *txwatch.Watcher
 
 	conf           common.Config
 	showVersion    = flag.Bool("version", false, "Show version and exit")
 	configFilePath = flag.String("config", common.DefaultConfigFile, "Path to a config file in TOML format")
+	hashPassword   = flag.Bool("hash-password", false, "Read a password from stdin, print its bcrypt hash (for users and admin-users) and exit")
 )
 
 // Functions
 
-// Init function
-func init() {
+// Load the config and start the clients (called from main, so tests of the
+// handlers don't need a config file)
+func setup() {
 	flag.Parse()
 	versionString := "debug"
 
@@ -84,6 +169,18 @@ func init() {
 		fmt.Println(versionString)
 		os.Exit(0)
 	}
+	if *hashPassword {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			panic(err)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(password, "\r\n")), bcrypt.DefaultCost)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(hash))
+		os.Exit(0)
+	}
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp: true,
 	})
@@ -93,6 +190,7 @@ func init() {
 	if err != nil {
 		panic(fmt.Errorf("unable to load %s:\n\t%w", *configFilePath, err))
 	}
+	common.NormalizeConfig(configFile)
 	err = configFile.Unmarshal(&conf)
 	if err != nil {
 		panic(fmt.Errorf("unable to process %s:\n\t%w", *configFilePath, err))
@@ -117,12 +215,146 @@ func init() {
 		})
 		log.WithFields(fields).Println("server started")
 	}
+	if len(conf.FeeTargets) == 0 {
+		conf.FeeTargets = bitcoind.DefaultFeeTargets
+	}
+	if conf.FeeTargets, err = bitcoind.CheckFeeTargets(conf.FeeTargets); err != nil {
+		panic(fmt.Errorf("fee-targets in %s: %w", *configFilePath, err))
+	}
+	for user, hash := range conf.JWTConfig.AdminUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			panic(fmt.Errorf("admin-users: '%s' needs the bcrypt hash of a password (see -hash-password): %w", user, err))
+		}
+	}
+	for user, hash := range conf.JWTConfig.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			panic(fmt.Errorf("users: '%s' needs the bcrypt hash of a password (see -hash-password): %w", user, err))
+		}
+	}
 	// if bitcoin client enabled
 	if conf.BitcoinClient {
-		btcClient, err = bitcoind.New(conf.Bitcoind)
+		btcPool, err = bitcoind.NewPool(conf.Bitcoind, time.Duration(conf.HealthCheckInterval)*time.Second)
 		if err != nil {
 			panic(err)
 		}
+		// Initial health check so calls go to the most synced node right away.
+		// Backends which are down are not fatal, they get picked up once they come back.
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		btcPool.CheckHealth(ctx)
+		cancel()
+		btcClient = btcPool
+		chainWatcher = chainwatch.New(btcPool, eventBus,
+			time.Duration(conf.TipPollInterval)*time.Second,
+			time.Duration(conf.MempoolStatsInterval)*time.Second)
+		if conf.MempoolAnalyticsInterval > 0 {
+			mempoolAnalyzer = mempool.New(btcPool, time.Duration(conf.MempoolAnalyticsInterval)*time.Second)
+		}
+		if conf.NetTotalsInterval > 0 {
+			netTotalsSampler = nettotals.New(btcPool, time.Duration(conf.NetTotalsInterval)*time.Second)
+		}
+		if conf.Indexer.Enabled {
+			if conf.Indexer.DBFile == "" {
+				conf.Indexer.DBFile = common.DefaultIndexFile
+			}
+			addressIndex, err = indexer.Open(common.CleanAndExpandPath(conf.Indexer.DBFile), btcPool, eventBus,
+				conf.Indexer.StartHeight, time.Duration(conf.Indexer.SyncInterval)*time.Second)
+			if err != nil {
+				panic(err)
+			}
+		}
+		if conf.Electrum.Enabled && addressIndex == nil {
+			panic(errors.New("the Electrum server needs the address index, set 'enabled = true' in [indexer]"))
+		}
+		if conf.TxWatch.Enabled {
+			if conf.AuthScheme != "JWT" || conf.TxWatch.Secret == "" {
+				panic(errors.New("transaction watches need auth-scheme = \"JWT\" and a 'secret' in [txwatch]"))
+			}
+			if len(conf.JWTConfig.Users) == 0 && len(conf.JWTConfig.AdminUsers) == 0 {
+				panic(errors.New("transaction watches need signed in users, set 'users' or 'admin-users' in [jwt]"))
+			}
+			if conf.TxWatch.DBFile == "" {
+				conf.TxWatch.DBFile = common.DefaultWatchFile
+			}
+			txWatcher, err = txwatch.Open(common.CleanAndExpandPath(conf.TxWatch.DBFile), btcPool, eventBus, txwatch.Options{
+				Secret:        conf.TxWatch.Secret,
+				MaxAttempts:   int(conf.TxWatch.MaxAttempts),
+				RetryInterval: time.Duration(conf.TxWatch.RetryInterval) * time.Second,
+				ExpireAfter:   time.Duration(conf.TxWatch.ExpireAfter) * time.Hour,
+				MaxWatches:    int(conf.TxWatch.MaxWatches),
+				MaxPerOwner:   int(conf.TxWatch.MaxWatchesPerUser),
+				AllowedHosts:  conf.TxWatch.AllowedHosts,
+			})
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+// Start the Electrum protocol listeners
+func startElectrum(ctx context.Context) {
+	serverVersion := "golang-httpd-test"
+	if version != "" {
+		serverVersion += " " + version
+	}
+	server := electrum.New(btcClient, addressIndex, eventBus, electrum.Options{
+		ServerVersion:    serverVersion,
+		MaxConnections:   int(conf.Electrum.MaxConnections),
+		MaxSubscriptions: int(conf.Electrum.MaxSubscriptions),
+	})
+	serve := func(listener net.Listener) {
+		log.WithField("address", listener.Addr().String()).Println("electrum server started")
+		if err := server.Serve(ctx, listener); err != nil {
+			log.WithError(err).Errorln("electrum server stopped")
+		}
+	}
+	if conf.Electrum.Listen != "" {
+		listener, err := net.Listen("tcp", conf.Electrum.Listen)
+		if err != nil {
+			panic(fmt.Errorf("electrum: %w", err))
+		}
+		go serve(listener)
+	}
+	if conf.Electrum.TLSListen != "" {
+		cert, err := tls.LoadX509KeyPair(common.CleanAndExpandPath(conf.Electrum.TLSCert), common.CleanAndExpandPath(conf.Electrum.TLSKey))
+		if err != nil {
+			panic(fmt.Errorf("electrum: can't load TLS certificate: %w", err))
+		}
+		listener, err := tls.Listen("tcp", conf.Electrum.TLSListen, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			panic(fmt.Errorf("electrum: %w", err))
+		}
+		go serve(listener)
+	}
+	go server.Run(ctx)
+}
+
+// Start a ZMQ subscriber for every backend with zmqpub* endpoints configured
+func startZMQ(ctx context.Context) {
+	backends := btcPool.Status()
+	for i, bitcoindConf := range conf.Bitcoind {
+		subscriber := zmq.New(backends[i].Name, bitcoindConf, eventBus)
+		if subscriber.Enabled() {
+			go subscriber.Run(ctx)
+		}
+	}
+	go logChainEvents(eventBus.Subscribe(0, events.TopicHashBlock, events.TopicZMQGap))
+}
+
+// Log new blocks and missed notifications
+func logChainEvents(sub *events.Subscription) {
+	for event := range sub.C {
+		switch data := event.Data.(type) {
+		case events.BlockHash:
+			log.WithFields(log.Fields{"hash": data.Hash, "backend": event.Source}).Println("new block")
+		case events.Gap:
+			log.WithFields(log.Fields{
+				"backend":  event.Source,
+				"topic":    data.ZMQTopic,
+				"expected": data.Expected,
+				"received": data.Received,
+			}).Warnln("missed ZMQ notifications")
+		}
 	}
 }
 
@@ -154,8 +386,23 @@ func signin(c *gin.Context) {
 		fmt.Println("No JWT Header set, lets validate username and password")
 	}
 	if c.PostForm("username") != "" && c.PostForm("password") != "" {
-		// todo: validate username and password
-		var signed_key string = jwt.SignKey(conf.JWTConfig.PrivKeyStore, c.PostForm("username"))
+		// todo: validate username and password of other users
+		var role string
+		hash, ok := conf.JWTConfig.AdminUsers[c.PostForm("username")]
+		if ok {
+			role = "admin"
+		} else if hash, ok = conf.JWTConfig.Users[c.PostForm("username")]; ok {
+			role = "user"
+		}
+		if ok && bcrypt.CompareHashAndPassword([]byte(hash), []byte(c.PostForm("password"))) != nil {
+			log.WithFields(log.Fields{"user": c.PostForm("username"), "role": role, "ip": c.ClientIP()}).Warn("sign in failed")
+			c.JSON(401, gin.H{
+				"message": "Invalid username or password",
+				"code":    "unauthorized",
+			})
+			return
+		}
+		var signed_key string = jwt.SignKeyWithRole(conf.JWTConfig.PrivKeyStore, c.PostForm("username"), role)
 		c.JSON(200, gin.H{
 			"message": "OK",
 			"jwt":     signed_key,
@@ -167,14 +414,151 @@ func signin(c *gin.Context) {
 	}
 }
 
+// Only let requests with a valid JWT header through
+func requireJWT(c *gin.Context) {
+	if c.GetHeader("JWT") == "" {
+		c.AbortWithStatusJSON(401, gin.H{
+			"message": "Please sign in and set the 'JWT' header",
+			"code":    "unauthorized",
+		})
+		return
+	}
+	user, role, err := jwt.KeyClaims(conf.JWTConfig.PrivKeyStore, c.GetHeader("JWT"))
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{
+			"message": fmt.Sprintf("Sign in token not valid: %s", err),
+			"code":    "unauthorized",
+		})
+		return
+	}
+	// for handlers that keep things per user
+	c.Set("user", user)
+	c.Set("role", role)
+	c.Next()
+}
+
+// Only let users whose password was checked on sign in through (the user or
+// admin role), goes after requireJWT
+func requireVerifiedUser(c *gin.Context) {
+	if role := c.GetString("role"); role != "user" && role != "admin" {
+		c.AbortWithStatusJSON(403, gin.H{
+			"message": "This endpoint requires a user from 'users' or 'admin-users'",
+			"code":    "forbidden",
+		})
+		return
+	}
+	c.Next()
+}
+
+// Only let requests with a valid JWT header carrying the admin role through,
+// and log every admin request with its outcome
+func requireAdmin(c *gin.Context) {
+	user, role, err := jwt.KeyClaims(conf.JWTConfig.PrivKeyStore, c.GetHeader("JWT"))
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{
+			"message": fmt.Sprintf("Sign in token not valid: %s", err),
+			"code":    "unauthorized",
+		})
+		return
+	}
+	fields := log.Fields{
+		"user":   user,
+		"method": c.Request.Method,
+		"path":   c.Request.URL.Path,
+		"ip":     c.ClientIP(),
+	}
+	if role != "admin" {
+		log.WithFields(fields).Warn("admin request denied")
+		c.AbortWithStatusJSON(403, gin.H{
+			"message": "This endpoint requires the admin role",
+			"code":    "forbidden",
+		})
+		return
+	}
+	c.Next()
+	if c.Request.Method != "GET" {
+		fields["params"] = c.Request.PostForm
+	}
+	fields["status"] = c.Writer.Status()
+	log.WithFields(fields).Info("admin request")
+}
+
 // Bitcoin endpoints
+// Maps an error from the bitcoind package to a HTTP status and a stable
+// machine readable code, so clients don't have to parse 'message'
+func bitcoindErrorStatus(err error) (status int, code string) {
+	var rpcErr *bitcoind.RPCError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &rpcErr):
+		code = rpcErr.Name()
+		switch rpcErr.Code {
+		case bitcoind.RPCInvalidAddressOrKey, bitcoind.RPCWalletNotFound,
+			bitcoind.RPCClientNodeNotAdded, bitcoind.RPCClientNodeNotConnected:
+			status = 404
+		case bitcoind.RPCInvalidParameter, bitcoind.RPCInvalidParams, bitcoind.RPCTypeError,
+			bitcoind.RPCDeserializationError, bitcoind.RPCInvalidRequest, bitcoind.RPCParseError,
+			bitcoind.RPCClientInvalidIPOrSubnet:
+			status = 400
+		case bitcoind.RPCClientNodeAlreadyAdded, bitcoind.RPCWalletAlreadyLoaded, bitcoind.RPCWalletAlreadyExists:
+			status = 409
+		case bitcoind.RPCVerifyError, bitcoind.RPCVerifyRejected, bitcoind.RPCVerifyAlreadyInChain:
+			status = 422
+		case bitcoind.RPCInWarmup, bitcoind.RPCClientNotConnected, bitcoind.RPCClientInInitialDownload:
+			status = 503
+		default:
+			status = 500
+		}
+	case errors.Is(err, context.Canceled):
+		// the client went away (a *url.Error, which is a net.Error too), not
+		// bitcoind. 499 as in nginx, nobody reads it.
+		status, code = 499, "client_closed_request"
+	case errors.Is(err, context.DeadlineExceeded):
+		status, code = 504, "backend_timeout"
+	case errors.Is(err, bitcoind.ErrUnauthorized):
+		status, code = 503, "backend_unauthorized"
+	case errors.As(err, &netErr):
+		status, code = 503, "backend_unavailable"
+	default:
+		status, code = 500, "internal_error"
+	}
+	return
+}
+
+// Reply with the status matching a bitcoind error
+func bitcoindError(c *gin.Context, err error, message string) {
+	status, code := bitcoindErrorStatus(err)
+	c.JSON(status, gin.H{
+		"message": fmt.Sprintf("%s: %s", message, err),
+		"code":    code,
+	})
+}
+
+// Reply with the results of a call made on every backend, with the status of
+// the first error if it failed on any of them
+func backendResults(c *gin.Context, results []bitcoind.BackendResult, message string) {
+	for _, result := range results {
+		if result.Err != nil {
+			status, code := bitcoindErrorStatus(result.Err)
+			c.JSON(status, gin.H{
+				"message":  fmt.Sprintf("%s on %s: %s", message, result.Backend, result.Err),
+				"code":     code,
+				"backends": results,
+			})
+			return
+		}
+	}
+	c.JSON(200, gin.H{
+		"message":  "OK",
+		"backends": results,
+	})
+}
+
 // begin: bitcoin functions
 func blockCount(c *gin.Context) {
-	blockcount, err := btcClient.BlockCount()
+	blockcount, err := btcClient.BlockCount(c.Request.Context())
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": "Can't get blockchain count",
-		})
+		bitcoindError(c, err, "Can't get blockchain count")
 		return
 	}
 	c.JSON(200, gin.H{
@@ -184,11 +568,9 @@ func blockCount(c *gin.Context) {
 }
 
 func blockchainInfo(c *gin.Context) {
-	blockchainInforesp, err := btcClient.BlockchainInfo()
+	blockchainInforesp, err := btcClient.BlockchainInfo(c.Request.Context())
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": "Can't get blockchain info",
-		})
+		bitcoindError(c, err, "Can't get blockchain info")
 		return
 	}
 
@@ -198,11 +580,9 @@ func blockchainInfo(c *gin.Context) {
 	})
 }
 func networkInfo(c *gin.Context) {
-	networkInfoResp, err := btcClient.NetworkInfo()
+	networkInfoResp, err := btcClient.NetworkInfo(c.Request.Context())
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": "Can't get network info",
-		})
+		bitcoindError(c, err, "Can't get network info")
 		return
 	}
 	c.JSON(200, gin.H{
@@ -211,11 +591,9 @@ func networkInfo(c *gin.Context) {
 	})
 }
 func miningInfo(c *gin.Context) {
-	miningInfoResp, err := btcClient.GetMiningInfo()
+	miningInfoResp, err := btcClient.GetMiningInfo(c.Request.Context())
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Can't get mining info: %s", err),
-		})
+		bitcoindError(c, err, "Can't get mining info")
 		return
 	}
 	c.JSON(200, gin.H{
@@ -225,11 +603,9 @@ func miningInfo(c *gin.Context) {
 }
 
 func blockchainTxInfo(c *gin.Context) {
-	txInforesp, err := btcClient.GetTransactionInfo(c.Param("id"))
+	txInforesp, err := btcClient.GetTransactionInfo(c.Request.Context(), c.Param("id"))
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Can't access transaction index: %s", err),
-		})
+		bitcoindError(c, err, "Can't access transaction index")
 		return
 	}
 
@@ -239,12 +615,10 @@ func blockchainTxInfo(c *gin.Context) {
 	})
 }
 func mempoolContents(c *gin.Context) {
-	// GetMempoolContents() (mempoolcontents []string, err error)
-	mempoolInfo, err := btcClient.GetMempoolContents()
+	// GetMempoolContents(ctx context.Context) (mempoolcontents []string, err error)
+	mempoolInfo, err := btcClient.GetMempoolContents(c.Request.Context())
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Can't access mempool: %s", err),
-		})
+		bitcoindError(c, err, "Can't access mempool")
 		return
 	}
 	c.JSON(200, gin.H{
@@ -253,12 +627,18 @@ func mempoolContents(c *gin.Context) {
 	})
 }
 func pushTransaction(c *gin.Context) {
-	// PushTransaction(hex string) (txid string, err error)
-	pushTxRes, err := btcClient.PushTransaction(c.PostForm("hex"))
+	// PushTransaction(ctx context.Context, hex string, maxFeeRate float64) (txid string, err error)
+	if c.PostForm("dry_run") == "true" {
+		testTransaction(c)
+		return
+	}
+	maxFeeRate, ok := maxFeeRateParam(c)
+	if !ok {
+		return
+	}
+	pushTxRes, err := btcClient.PushTransaction(c.Request.Context(), c.PostForm("hex"), maxFeeRate)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Can't broadcast transaction: %s", err),
-		})
+		bitcoindError(c, err, "Can't broadcast transaction")
 		return
 	}
 	c.JSON(200, gin.H{
@@ -266,13 +646,58 @@ func pushTransaction(c *gin.Context) {
 		"txid":    pushTxRes,
 	})
 }
-func getBestBlockHash(c *gin.Context) {
-	// GetBestBlockHash() (blockhash string, err error)
-	bestblock, err := btcClient.GetBestBlockHash()
+
+// validate one or more transactions (a package) without broadcasting them
+func testTransaction(c *gin.Context) {
+	// TestMempoolAccept(ctx context.Context, rawTxs []string, maxFeeRate float64) ([]bitcoind.MempoolAcceptResult, error)
+	rawTxs := c.PostFormArray("hex")
+	if len(rawTxs) == 0 || len(rawTxs) > bitcoind.MaxPackageCount {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Please specify between 1 and %d 'hex' transactions", bitcoind.MaxPackageCount),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	maxFeeRate, ok := maxFeeRateParam(c)
+	if !ok {
+		return
+	}
+	results, err := btcClient.TestMempoolAccept(c.Request.Context(), rawTxs, maxFeeRate)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Error getting the block hash: %s", err),
+		bitcoindError(c, err, "Can't test transaction")
+		return
+	}
+	allowed := len(results) > 0
+	for _, result := range results {
+		allowed = allowed && result.Allowed
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"allowed": allowed,
+		"results": results,
+	})
+}
+
+// optional 'maxfeerate' form value (BTC/kvB, same as bitcoind)
+func maxFeeRateParam(c *gin.Context) (maxFeeRate float64, ok bool) {
+	if c.PostForm("maxfeerate") == "" {
+		return 0, true
+	}
+	maxFeeRate, err := strconv.ParseFloat(c.PostForm("maxfeerate"), 64)
+	if err != nil || maxFeeRate <= 0 {
+		c.JSON(400, gin.H{
+			"message": "'maxfeerate' must be a positive number (BTC/kvB)",
+			"code":    "invalid_parameter",
 		})
+		return 0, false
+	}
+	return maxFeeRate, true
+}
+func getBestBlockHash(c *gin.Context) {
+	// GetBestBlockHash(ctx context.Context) (blockhash string, err error)
+	bestblock, err := btcClient.GetBestBlockHash(c.Request.Context())
+	if err != nil {
+		bitcoindError(c, err, "Error getting the block hash")
 		return
 	}
 	c.JSON(200, gin.H{
@@ -283,19 +708,18 @@ func getBestBlockHash(c *gin.Context) {
 
 // get blockhash by height
 func getBlockHashByHeight(c *gin.Context) {
-	// GetBlockHashByHeight(height int64) (blockhash string, err error)
+	// GetBlockHashByHeight(ctx context.Context, height int64) (blockhash string, err error)
 	heightInt, errtoInt := strconv.ParseInt(c.Param("id"), 10, 64)
 	if errtoInt != nil {
-		c.JSON(500, gin.H{
+		c.JSON(400, gin.H{
 			"message": "Error converting input to integer",
+			"code":    "invalid_parameter",
 		})
 		return
 	}
-	blockhash, err := btcClient.GetBlockHashByHeight(heightInt)
+	blockhash, err := btcClient.GetBlockHashByHeight(c.Request.Context(), heightInt)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Error getting the block hash: %s", err),
-		})
+		bitcoindError(c, err, "Error getting the block hash")
 		return
 	}
 	c.JSON(200, gin.H{
@@ -306,12 +730,10 @@ func getBlockHashByHeight(c *gin.Context) {
 
 // get Block info
 func getBlock(c *gin.Context) {
-	// GetBlock(hash string) (blockinfo BitcoinBlockResponse, err error)
-	bitcoinblock, err := btcClient.GetBlock(c.Param("id"))
+	// GetBlock(ctx context.Context, hash string) (blockinfo BitcoinBlockResponse, err error)
+	bitcoinblock, err := btcClient.GetBlock(c.Request.Context(), c.Param("id"))
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Error getting block: %s", err),
-		})
+		bitcoindError(c, err, "Error getting block")
 		return
 	}
 	c.JSON(200, gin.H{
@@ -319,126 +741,1288 @@ func getBlock(c *gin.Context) {
 		"block":   bitcoinblock,
 	})
 }
-func getBlockStats(c *gin.Context) {
-	// GetBlockStats(int64) (bitcoind.BlockStatsResponse, error)
-	blockHeight, blockIdErr := strconv.ParseInt(c.Param("id"), 10, 64)
-	if blockIdErr != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Error converting param to block height: %s", blockIdErr),
+
+// get decoded transactions of a block (paginated with ?page=&limit=)
+func getBlockTransactions(c *gin.Context) {
+	// GetBlockWithTransactions(ctx context.Context, hash string) (bitcoind.BitcoinBlockVerboseResponse, error)
+	page, pageErr := strconv.ParseInt(c.DefaultQuery("page", "0"), 10, 64)
+	limit, limitErr := strconv.ParseInt(c.DefaultQuery("limit", "25"), 10, 64)
+	if pageErr != nil || limitErr != nil || page < 0 || limit < 1 || limit > 100 {
+		c.JSON(400, gin.H{
+			"message": "'page' must be a positive integer and 'limit' between 1 and 100",
+			"code":    "invalid_parameter",
 		})
 		return
 	}
-	blockstats, err := btcClient.GetBlockStats(blockHeight)
+	block, err := btcClient.GetBlockWithTransactions(c.Request.Context(), c.Param("id"))
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Error getting block stats: %s", err),
-		})
+		bitcoindError(c, err, "Error getting block")
 		return
 	}
+	total := int64(len(block.Transactions))
+	// Pages past the end are empty, checked before multiplying so huge pages can't overflow
+	start, end := total, total
+	if page <= total/limit {
+		start = page * limit
+		end = start + limit
+		if end > total {
+			end = total
+		}
+	}
 	c.JSON(200, gin.H{
-		"message":    "OK",
-		"blockstats": blockstats,
+		"message": "OK",
+		"hash":    block.Hash,
+		"height":  block.Height,
+		"page":    page,
+		"limit":   limit,
+		"total":   total,
+		"txs":     block.Transactions[start:end],
 	})
 }
 
-// mempool info
-func getMempoolInfo(c *gin.Context) {
-	// GetMempoolInfo() (mempoolinfo bitcoind.MempoolInfoResponse, err error)
-	mempool, err := btcClient.GetMempoolInfo()
+// get a serialized block as hex
+func getBlockHex(c *gin.Context) {
+	// GetBlockHex(ctx context.Context, hash string) (string, error)
+	blockhex, err := btcClient.GetBlockHex(c.Request.Context(), c.Param("id"))
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Error getting mempool info: %s", err),
-		})
+		bitcoindError(c, err, "Error getting block")
 		return
 	}
 	c.JSON(200, gin.H{
 		"message": "OK",
-		"mempool": mempool,
+		"hex":     blockhex,
 	})
 }
 
-// peer info
-func getPeerInfo(c *gin.Context) {
-	// GetPeerInfo() ([]bitcoind.PeerInfo, error)
-	peerinfo, err := btcClient.GetPeerInfo()
+// get a serialized block, as hex or binary (Accept: application/octet-stream)
+func getBlockRaw(c *gin.Context) {
+	// StreamBlockHex(ctx context.Context, hash string) (io.ReadCloser, error)
+	streamRaw(c, "Error getting block", func(ctx context.Context) (io.ReadCloser, error) {
+		return btcClient.StreamBlockHex(ctx, c.Param("id"))
+	})
+}
+
+// get a serialized transaction, as hex or binary (Accept: application/octet-stream)
+func getTransactionRaw(c *gin.Context) {
+	// StreamRawTransactionHex(ctx context.Context, txid string) (io.ReadCloser, error)
+	streamRaw(c, "Error getting transaction", func(ctx context.Context) (io.ReadCloser, error) {
+		return btcClient.StreamRawTransactionHex(ctx, c.Param("txid"))
+	})
+}
+
+// streamRaw copies the hex from bitcoind to the client as it comes in, decoded
+// to binary if the client prefers application/octet-stream. Errors after the
+// status has been sent can only be signalled by cutting the connection.
+func streamRaw(c *gin.Context, message string, open func(context.Context) (io.ReadCloser, error)) {
+	format := c.NegotiateFormat("text/plain", "application/octet-stream")
+	if format == "" {
+		c.JSON(406, gin.H{
+			"message": "Supported formats are text/plain (hex) and application/octet-stream",
+			"code":    "not_acceptable",
+		})
+		return
+	}
+	stream, err := open(c.Request.Context())
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Error getting peer info: %s", err),
+		bitcoindError(c, err, message)
+		return
+	}
+	defer stream.Close()
+
+	var body io.Reader = stream
+	if format == "application/octet-stream" {
+		body = hex.NewDecoder(stream)
+		c.Header("Content-Type", "application/octet-stream")
+	} else {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+	}
+	c.Status(200)
+	if _, err := io.Copy(c.Writer, body); err != nil {
+		log.WithFields(log.Fields{"path": c.Request.URL.Path, "error": err}).Warn("raw response cut short")
+		if conn, _, err := c.Writer.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// get a range of blocks (?from=&to=) in a single batch
+func getBlockRange(c *gin.Context) {
+	// GetBlockRange(ctx context.Context, from, to int64) ([]bitcoind.BitcoinBlockResponse, error)
+	from, fromErr := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, toErr := strconv.ParseInt(c.Query("to"), 10, 64)
+	if fromErr != nil || toErr != nil {
+		c.JSON(400, gin.H{
+			"message": "Please specify 'from' and 'to' block heights",
+			"code":    "invalid_parameter",
 		})
 		return
 	}
+	if from < 0 || to < from || to-from+1 > bitcoind.MaxBlockRange {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Invalid range, 'from' must be <= 'to' and at most %d blocks", bitcoind.MaxBlockRange),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	blocks, err := btcClient.GetBlockRange(c.Request.Context(), from, to)
+	if err != nil {
+		bitcoindError(c, err, "Error getting blocks")
+		return
+	}
 	c.JSON(200, gin.H{
-		"peerinfo": peerinfo,
-		"message":  "OK",
+		"message": "OK",
+		"blocks":  blocks,
 	})
 }
 
-// index endpoint
-// PinePhone Endpoints
-func batStatus(c *gin.Context) {
+// get a block header
+func getBlockHeader(c *gin.Context) {
+	// GetBlockHeader(ctx context.Context, hash string) (bitcoind.BlockHeaderResponse, error)
+	header, err := btcClient.GetBlockHeader(c.Request.Context(), c.Param("hash"))
+	if err != nil {
+		bitcoindError(c, err, "Error getting block header")
+		return
+	}
 	c.JSON(200, gin.H{
-		"status": pineclient.GetStatus(),
+		"message": "OK",
+		"header":  header,
 	})
 }
 
-func batCapacity(c *gin.Context) {
+// get a serialized (80 byte) block header as hex
+func getBlockHeaderHex(c *gin.Context) {
+	// GetBlockHeaderHex(ctx context.Context, hash string) (string, error)
+	header, err := btcClient.GetBlockHeaderHex(c.Request.Context(), c.Param("hash"))
+	if err != nil {
+		bitcoindError(c, err, "Error getting block header")
+		return
+	}
 	c.JSON(200, gin.H{
-		"percent": pineclient.GetCapacity(),
+		"message": "OK",
+		"hex":     header,
 	})
 }
-func cpuTemp(c *gin.Context) {
+
+// get consecutive headers (?from=&count=), as hex with ?format=hex
+func getHeaders(c *gin.Context) {
+	// GetHeaders(ctx context.Context, from, count int64) ([]bitcoind.BlockHeaderResponse, error)
+	// GetHeadersHex(ctx context.Context, from, count int64) ([]string, error)
+	from, fromErr := strconv.ParseInt(c.Query("from"), 10, 64)
+	count, countErr := strconv.ParseInt(c.DefaultQuery("count", "100"), 10, 64)
+	format := c.DefaultQuery("format", "json")
+	if fromErr != nil || countErr != nil || from < 0 || count < 1 || count > bitcoind.MaxHeaderCount || (format != "json" && format != "hex") {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Please specify a 'from' height, a 'count' between 1 and %d and a 'format' of json or hex", bitcoind.MaxHeaderCount),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	var headers interface{}
+	var err error
+	if format == "hex" {
+		headers, err = btcClient.GetHeadersHex(c.Request.Context(), from, count)
+	} else {
+		headers, err = btcClient.GetHeaders(c.Request.Context(), from, count)
+	}
+	if err != nil {
+		bitcoindError(c, err, "Error getting headers")
+		return
+	}
 	c.JSON(200, gin.H{
-		"cputemp": pineclient.GetCPUTemp(),
+		"message": "OK",
+		"headers": headers,
 	})
 }
-func gpuTemp(c *gin.Context) {
+
+// get all known chain tips (active chain and forks)
+func getChainTips(c *gin.Context) {
+	// GetChainTips(ctx context.Context) ([]bitcoind.ChainTip, error)
+	tips, err := btcClient.GetChainTips(c.Request.Context())
+	if err != nil {
+		bitcoindError(c, err, "Error getting chain tips")
+		return
+	}
 	c.JSON(200, gin.H{
-		"gputemp": pineclient.GetGPUTemp(),
+		"message":   "OK",
+		"chaintips": tips,
 	})
 }
 
-// querystring test
-func testQueryString(c *gin.Context) {
-	param1 := c.DefaultQuery("param1", "none")
-	if param1 == "none" {
-		c.JSON(200, gin.H{
-			"message": "show this message if there is no query string",
-		})
-	} else {
-		c.JSON(200, gin.H{
-			"message": param1,
+// reorgs of the best chain since the server started, newest first
+func getReorgs(c *gin.Context) {
+	// Reorgs() []events.Reorg
+	c.JSON(200, gin.H{
+		"message":     "OK",
+		"track_depth": chainwatch.TrackDepth,
+		"reorgs":      chainWatcher.Reorgs(),
+	})
+}
+
+func getBlockStats(c *gin.Context) {
+	// GetBlockStats(ctx context.Context, int64) (bitcoind.BlockStatsResponse, error)
+	blockHeight, blockIdErr := strconv.ParseInt(c.Param("id"), 10, 64)
+	if blockIdErr != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Error converting param to block height: %s", blockIdErr),
+			"code":    "invalid_parameter",
 		})
+		return
+	}
+	blockstats, err := btcClient.GetBlockStats(c.Request.Context(), blockHeight)
+	if err != nil {
+		bitcoindError(c, err, "Error getting block stats")
+		return
 	}
+	c.JSON(200, gin.H{
+		"message":    "OK",
+		"blockstats": blockstats,
+	})
 }
 
-// BTC Price
-func getBtcPrice(c *gin.Context) {
-	price, err := btcprice.GetPriceFeed(conf)
+// blockHeightParam resolves a query parameter holding either a block height or
+// a block hash to a height. On failure the reply is sent already: 400 for a
+// value that is neither, the status of the bitcoind error for a failed lookup.
+func blockHeightParam(c *gin.Context, name string) (height int64, ok bool) {
+	value := c.Query(name)
+	if _, err := hex.DecodeString(value); err == nil && len(value) == 64 {
+		header, err := btcClient.GetBlockHeader(c.Request.Context(), value)
+		if err != nil {
+			bitcoindError(c, err, fmt.Sprintf("Error looking up block '%s'", name))
+			return 0, false
+		}
+		return header.Height, true
+	}
+	height, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"message": fmt.Sprintf("Error reading price: %s", err),
+		c.JSON(400, gin.H{
+			"message": "Please specify 'from' and 'to' as block heights or hashes",
+			"code":    "invalid_parameter",
 		})
-	} else {
-		// return string (GetPriceFeed actually returns []byte
-		c.String(200, string(price))
+		return 0, false
 	}
+	return height, true
 }
 
-// Main entrypoint
-func main() {
-	router := gin.Default()
-	router.Use(cors.Default())
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
-	r := router.Group("/api")
-	r.GET("/", func(c *gin.Context) {
-		c.JSON(200, common.FormatRoutes(router.Routes()))
-	})
+// block stats for a range of blocks (?from=&to= as heights or hashes, ?stats=txs,totalfee to select stats)
+func getBlockStatsRange(c *gin.Context) {
+	// GetBlockStatsRange(ctx context.Context, from, to int64, stats []string) ([]bitcoind.BlockStats, error)
+	from, ok := blockHeightParam(c, "from")
+	if !ok {
+		return
+	}
+	to, ok := blockHeightParam(c, "to")
+	if !ok {
+		return
+	}
+	if from < 0 || to < from || to-from+1 > bitcoind.MaxBlockStatsRange {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Invalid range, 'from' must be <= 'to' and at most %d blocks", bitcoind.MaxBlockStatsRange),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	var stats []string
+	if c.Query("stats") != "" {
+		stats = append(stats, "height")
+		for _, stat := range strings.Split(c.Query("stats"), ",") {
+			stat = strings.TrimSpace(stat)
+			known := false
+			for _, field := range bitcoind.BlockStatsFields {
+				known = known || field == stat
+			}
+			if !known {
+				c.JSON(400, gin.H{
+					"message": fmt.Sprintf("Unknown stat '%s'", stat),
+					"code":    "invalid_parameter",
+				})
+				return
+			}
+			if stat != "height" {
+				stats = append(stats, stat)
+			}
+		}
+	}
+	blocks, err := btcClient.GetBlockStatsRange(c.Request.Context(), from, to, stats)
+	if err != nil {
+		bitcoindError(c, err, "Error getting block stats")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message":    "OK",
+		"blocks":     blocks,
+		"aggregates": bitcoind.AggregateBlockStats(blocks, bitcoind.BlockStatsAggregateFields),
+	})
+}
+
+// mempool info
+func getMempoolInfo(c *gin.Context) {
+	// GetMempoolInfo(ctx context.Context) (mempoolinfo bitcoind.MempoolInfoResponse, err error)
+	mempool, err := btcClient.GetMempoolInfo(c.Request.Context())
+	if err != nil {
+		bitcoindError(c, err, "Error getting mempool info")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"mempool": mempool,
+	})
+}
+
+// unspent transaction output (?mempool=false to ignore mempool spends)
+func getUTXO(c *gin.Context) {
+	// GetTxOut(ctx context.Context, txid string, vout int64, includeMempool bool) (*bitcoind.TxOutResponse, error)
+	vout, err := strconv.ParseInt(c.Param("vout"), 10, 64)
+	if err != nil || vout < 0 {
+		c.JSON(400, gin.H{
+			"message": "'vout' must be a positive integer",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	txOut, err := btcClient.GetTxOut(c.Request.Context(), c.Param("txid"), vout, c.DefaultQuery("mempool", "true") != "false")
+	if err != nil {
+		bitcoindError(c, err, "Error getting output")
+		return
+	}
+	if txOut == nil {
+		c.JSON(404, gin.H{
+			"message": "Output is spent or doesn't exist",
+			"code":    "not_found",
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"utxo":    txOut,
+	})
+}
+
+// start a UTXO set scan for 'address' and/or 'descriptor' form values
+func startScan(c *gin.Context) {
+	// StartScan(ctx context.Context, descriptors []string) (bitcoind.ScanStatus, error)
+	descriptors := c.PostFormArray("descriptor")
+	for _, address := range c.PostFormArray("address") {
+		for _, char := range address {
+			if !(char >= '0' && char <= '9' || char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z') {
+				c.JSON(400, gin.H{
+					"message": fmt.Sprintf("Invalid address %q", address),
+					"code":    "invalid_parameter",
+				})
+				return
+			}
+		}
+		descriptors = append(descriptors, fmt.Sprintf("addr(%s)", address))
+	}
+	if len(descriptors) == 0 || len(descriptors) > bitcoind.MaxScanDescriptors {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Please specify between 1 and %d 'address' or 'descriptor' values", bitcoind.MaxScanDescriptors),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	scan, err := btcClient.StartScan(c.Request.Context(), descriptors)
+	if errors.Is(err, bitcoind.ErrScanInProgress) {
+		c.JSON(409, gin.H{
+			"message": "A scan is already running, wait for it or abort it first",
+			"code":    "scan_in_progress",
+			"scan":    scan,
+		})
+		return
+	}
+	var rpcErr *bitcoind.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == bitcoind.RPCInvalidAddressOrKey {
+		// getdescriptorinfo rejected one of them
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Invalid descriptor: %s", err),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	if err != nil {
+		bitcoindError(c, err, "Error starting scan")
+		return
+	}
+	c.JSON(202, gin.H{
+		"message": "OK",
+		"scan":    scan,
+	})
+}
+
+// progress of the running scan, or the result of the last one
+func getScan(c *gin.Context) {
+	// ScanStatus(ctx context.Context) (bitcoind.ScanStatus, bool)
+	scan, ok := btcClient.ScanStatus(c.Request.Context())
+	if !ok {
+		c.JSON(404, gin.H{
+			"message": "No scan has been started",
+			"code":    "not_found",
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"scan":    scan,
+	})
+}
+
+// abort the running scan
+func abortScan(c *gin.Context) {
+	// AbortScan(ctx context.Context) (bitcoind.ScanStatus, error)
+	scan, err := btcClient.AbortScan(c.Request.Context())
+	if errors.Is(err, bitcoind.ErrNoScan) {
+		c.JSON(409, gin.H{
+			"message": "No scan is running",
+			"code":    "no_scan_running",
+		})
+		return
+	}
+	if err != nil {
+		bitcoindError(c, err, "Error aborting scan")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"scan":    scan,
+	})
+}
+
+// check the :addr parameter, addresses are alphanumeric (base58 or bech32)
+func addressParam(c *gin.Context) (address string, ok bool) {
+	address = c.Param("addr")
+	valid := len(address) > 0 && len(address) <= 100
+	for _, char := range address {
+		if !(char >= '0' && char <= '9' || char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z') {
+			valid = false
+		}
+	}
+	if !valid {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Invalid address %q", address),
+			"code":    "invalid_parameter",
+		})
+	}
+	return address, valid
+}
+
+// confirmed transactions of an address, newest first (amounts in satoshis)
+func getAddressTxs(c *gin.Context) {
+	// AddressTxs(address string, skip, limit int) ([]indexer.AddressTx, error)
+	address, ok := addressParam(c)
+	if !ok {
+		return
+	}
+	page, pageErr := strconv.ParseInt(c.DefaultQuery("page", "0"), 10, 64)
+	limit, limitErr := strconv.ParseInt(c.DefaultQuery("limit", "25"), 10, 64)
+	if pageErr != nil || limitErr != nil || page < 0 || limit < 1 || limit > 100 {
+		c.JSON(400, gin.H{
+			"message": "'page' must be a positive integer and 'limit' between 1 and 100",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	status := addressIndex.Status()
+	txs := []indexer.AddressTx{}
+	var err error
+	// No address has that many transactions, and page*limit must not overflow
+	if page <= math.MaxInt32/limit {
+		txs, err = addressIndex.AddressTxs(address, int(page*limit), int(limit))
+	}
+	if err != nil {
+		c.JSON(500, gin.H{
+			"message": "Error reading the address index",
+			"code":    "index_error",
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"address": address,
+		"page":    page,
+		"limit":   limit,
+		"index":   status,
+		"txs":     txs,
+	})
+}
+
+// confirmed unspent outputs of an address, newest first (values in satoshis)
+func getAddressUTXOs(c *gin.Context) {
+	// AddressUTXOs(address string) ([]indexer.UTXO, error)
+	address, ok := addressParam(c)
+	if !ok {
+		return
+	}
+	status := addressIndex.Status()
+	utxos, err := addressIndex.AddressUTXOs(address)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"message": "Error reading the address index",
+			"code":    "index_error",
+		})
+		return
+	}
+	var balance int64
+	for _, utxo := range utxos {
+		balance += utxo.Value
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"address": address,
+		"index":   status,
+		"balance": balance,
+		"utxos":   utxos,
+	})
+}
+
+// /api/mempool/:txid also serves /histogram and /stats, gin can't have static
+// and wildcard routes at the same position
+func mempoolRoute(c *gin.Context) {
+	switch c.Param("txid") {
+	case "histogram":
+		getMempoolHistogram(c)
+	case "stats":
+		getMempoolStats(c)
+	default:
+		getMempoolEntry(c)
+	}
+}
+
+// latest mempool analysis, replies with an error if there is none
+func mempoolSnapshot(c *gin.Context) (snapshot mempool.Snapshot, ok bool) {
+	if mempoolAnalyzer == nil {
+		c.JSON(503, gin.H{
+			"message": "Mempool analytics are disabled (mempool-analytics-interval = 0)",
+			"code":    "not_available",
+		})
+		return
+	}
+	snapshot, ok = mempoolAnalyzer.Snapshot()
+	if !ok {
+		c.JSON(503, gin.H{
+			"message": "Mempool analytics are not available yet",
+			"code":    "not_ready",
+		})
+	}
+	return
+}
+
+// fee rate histogram of the mempool (sat/vB, highest fee rates first)
+func getMempoolHistogram(c *gin.Context) {
+	// Snapshot() (mempool.Snapshot, bool)
+	snapshot, ok := mempoolSnapshot(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, gin.H{
+		"message":   "OK",
+		"updated":   snapshot.Updated,
+		"histogram": snapshot.Histogram,
+	})
+}
+
+// mempool totals, RBF and segwit counts and fee rates
+func getMempoolStats(c *gin.Context) {
+	// Snapshot() (mempool.Snapshot, bool)
+	snapshot, ok := mempoolSnapshot(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"updated": snapshot.Updated,
+		"stats":   snapshot.Stats,
+	})
+}
+
+// network traffic counters and upload target
+func getNetTotals(c *gin.Context) {
+	// GetNetTotals(context.Context) (bitcoind.NetTotalsResponse, error)
+	nettotals, err := btcClient.GetNetTotals(c.Request.Context())
+	if err != nil {
+		bitcoindError(c, err, "Error getting network totals")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message":   "OK",
+		"nettotals": nettotals,
+	})
+}
+
+// bandwidth per minute, oldest first (?minutes=60, 0 for all that's kept)
+func getNetTotalsHistory(c *gin.Context) {
+	// History(minutes int) []nettotals.Minute
+	if netTotalsSampler == nil {
+		c.JSON(503, gin.H{
+			"message": "Network traffic history is disabled (nettotals-interval = 0)",
+			"code":    "not_available",
+		})
+		return
+	}
+	minutes, err := strconv.ParseInt(c.DefaultQuery("minutes", "60"), 10, 64)
+	if err != nil || minutes < 0 || minutes > nettotals.MaxHistory {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Invalid 'minutes', must be between 0 and %d", nettotals.MaxHistory),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"history": netTotalsSampler.History(int(minutes)),
+	})
+}
+
+// mempool entry of a single transaction
+func getMempoolEntry(c *gin.Context) {
+	// GetMempoolEntry(ctx context.Context, txid string) (bitcoind.MempoolEntry, error)
+	entry, err := btcClient.GetMempoolEntry(c.Request.Context(), c.Param("txid"))
+	if err != nil {
+		bitcoindError(c, err, "Error getting mempool entry")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"entry":   entry,
+	})
+}
+
+// in-mempool ancestors of a transaction
+func getMempoolAncestors(c *gin.Context) {
+	// GetMempoolAncestors(ctx context.Context, txid string) (map[string]bitcoind.MempoolEntry, error)
+	ancestors, err := btcClient.GetMempoolAncestors(c.Request.Context(), c.Param("txid"))
+	if err != nil {
+		bitcoindError(c, err, "Error getting mempool ancestors")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message":   "OK",
+		"ancestors": ancestors,
+	})
+}
+
+// in-mempool descendants of a transaction
+func getMempoolDescendants(c *gin.Context) {
+	// GetMempoolDescendants(ctx context.Context, txid string) (map[string]bitcoind.MempoolEntry, error)
+	descendants, err := btcClient.GetMempoolDescendants(c.Request.Context(), c.Param("txid"))
+	if err != nil {
+		bitcoindError(c, err, "Error getting mempool descendants")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message":     "OK",
+		"descendants": descendants,
+	})
+}
+
+// fee estimates (sat/vB) for the configured confirmation targets
+func getFees(c *gin.Context) {
+	// EstimateFees(ctx context.Context, targets []int64) (bitcoind.FeeEstimates, error)
+	fees, err := btcClient.EstimateFees(c.Request.Context(), conf.FeeTargets)
+	if err != nil {
+		bitcoindError(c, err, "Error estimating fees")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"fees":    fees,
+	})
+}
+
+// peer info
+func getPeerInfo(c *gin.Context) {
+	// GetPeerInfo(ctx context.Context) ([]bitcoind.PeerInfo, error)
+	peerinfo, err := btcClient.GetPeerInfo(c.Request.Context())
+	if err != nil {
+		bitcoindError(c, err, "Error getting peer info")
+		return
+	}
+	c.JSON(200, gin.H{
+		"peerinfo": peerinfo,
+		"message":  "OK",
+	})
+}
+
+// Wallet endpoints (watch-only)
+// create wallet
+func createWallet(c *gin.Context) {
+	// CreateWallet(ctx context.Context, name string, descriptors bool) (bitcoind.WalletResponse, error)
+	name := c.PostForm("name")
+	if !bitcoind.ValidWalletName(name) {
+		c.JSON(400, gin.H{
+			"message": "Please specify a wallet 'name' (up to 64 letters, digits, '_' or '-')",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	descriptors := c.DefaultPostForm("descriptors", "true") == "true"
+	wallet, err := btcClient.CreateWallet(c.Request.Context(), name, descriptors)
+	if err != nil {
+		bitcoindError(c, err, "Error creating wallet")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"wallet":  wallet,
+	})
+}
+
+// Reject wallet names in the path that could point outside of the wallets
+// directory of bitcoind (for the /wallet/:name routes)
+func checkWalletName(c *gin.Context) {
+	if name, ok := c.Params.Get("name"); ok && !bitcoind.ValidWalletName(name) {
+		c.AbortWithStatusJSON(400, gin.H{
+			"message": "Invalid wallet name (up to 64 letters, digits, '_' or '-')",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	c.Next()
+}
+
+// load wallet
+func loadWallet(c *gin.Context) {
+	// LoadWallet(ctx context.Context, name string) (bitcoind.WalletResponse, error)
+	wallet, err := btcClient.LoadWallet(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		bitcoindError(c, err, "Error loading wallet")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"wallet":  wallet,
+	})
+}
+
+// import a watch-only address
+func importWalletAddress(c *gin.Context) {
+	// ImportAddress(ctx context.Context, walletName, address, label string, rescan bool) error
+	address := c.PostForm("address")
+	if address == "" {
+		c.JSON(400, gin.H{
+			"message": "Please specify an 'address'",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	rescan := c.DefaultPostForm("rescan", "false") == "true"
+	err := btcClient.ImportAddress(c.Request.Context(), c.Param("name"), address, c.PostForm("label"), rescan)
+	if err != nil {
+		bitcoindError(c, err, "Error importing address")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"address": address,
+	})
+}
+
+// import descriptors (JSON body as per importdescriptors)
+func importWalletDescriptors(c *gin.Context) {
+	// ImportDescriptors(ctx context.Context, walletName string, descriptors []bitcoind.ImportDescriptorRequest) ([]bitcoind.ImportDescriptorResult, error)
+	var descriptors []bitcoind.ImportDescriptorRequest
+	if err := c.ShouldBindJSON(&descriptors); err != nil || len(descriptors) == 0 {
+		c.JSON(400, gin.H{
+			"message": "Please post a JSON array of descriptors ([{\"desc\": ..., \"timestamp\": \"now\"}])",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	results, err := btcClient.ImportDescriptors(c.Request.Context(), c.Param("name"), descriptors)
+	if err != nil {
+		bitcoindError(c, err, "Error importing descriptors")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"results": results,
+	})
+}
+
+// amounts received per address (optionally for a single :address)
+func walletReceived(c *gin.Context) {
+	// ListReceivedByAddress(ctx context.Context, walletName string, minConf int64, includeEmpty bool, address string) ([]bitcoind.ReceivedByAddress, error)
+	minConf, err := strconv.ParseInt(c.DefaultQuery("minconf", "1"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": "Error converting 'minconf' to integer",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	includeEmpty := c.DefaultQuery("include_empty", "false") == "true"
+	received, err := btcClient.ListReceivedByAddress(c.Request.Context(), c.Param("name"), minConf, includeEmpty, c.Param("address"))
+	if err != nil {
+		bitcoindError(c, err, "Error listing received amounts")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message":  "OK",
+		"received": received,
+	})
+}
+
+// wallet transactions, optionally filtered by ?address=
+func walletTransactions(c *gin.Context) {
+	// ListTransactions(ctx context.Context, walletName string, count, skip int64) ([]bitcoind.WalletTransaction, error)
+	count, countErr := strconv.ParseInt(c.DefaultQuery("count", "10"), 10, 64)
+	skip, skipErr := strconv.ParseInt(c.DefaultQuery("skip", "0"), 10, 64)
+	if countErr != nil || skipErr != nil || count < 1 || count > 1000 || skip < 0 {
+		c.JSON(400, gin.H{
+			"message": "'count' must be between 1 and 1000 and 'skip' a positive integer",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	var transactions []bitcoind.WalletTransaction
+	var err error
+	if address := c.Query("address"); address != "" {
+		// count and skip apply to the transactions of that address
+		transactions, err = btcClient.ListAddressTransactions(c.Request.Context(), c.Param("name"), address, count, skip)
+	} else {
+		transactions, err = btcClient.ListTransactions(c.Request.Context(), c.Param("name"), count, skip)
+	}
+	if err != nil {
+		bitcoindError(c, err, "Error listing transactions")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message":      "OK",
+		"transactions": transactions,
+	})
+}
+
+// derive a new address (wallets with active public descriptors only)
+func walletNewAddress(c *gin.Context) {
+	// GetNewAddress(ctx context.Context, walletName, label, addressType string) (string, error)
+	address, err := btcClient.GetNewAddress(c.Request.Context(), c.Param("name"), c.PostForm("label"), c.PostForm("type"))
+	if err != nil {
+		bitcoindError(c, err, "Error getting new address")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"address": address,
+	})
+}
+
+// Decoding endpoints
+// Reads the data to decode from the request body and returns it as bytes.
+// The body is either binary (application/octet-stream), a 'data' form value
+// or plain text, hex or base64 encoded. ?encoding=hex|base64 skips detection.
+func decodeBody(c *gin.Context, maxSize int) (data []byte, ok bool) {
+	// hex is the least dense encoding, anything longer can't be valid
+	limit := int64(maxSize)*2 + 1024
+	raw, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Can't read request body: %s", err),
+			"code":    "invalid_request",
+		})
+		return nil, false
+	}
+	if int64(len(raw)) > limit {
+		c.JSON(413, gin.H{
+			"message": fmt.Sprintf("Request body too large (max %d bytes decoded)", maxSize),
+			"code":    "body_too_large",
+		})
+		return nil, false
+	}
+	body := string(raw)
+	switch c.ContentType() {
+	case "application/octet-stream":
+		data = raw
+	case "application/x-www-form-urlencoded":
+		// also what curl -d sends for a bare body
+		if form, err := url.ParseQuery(body); err == nil && form.Get("data") != "" {
+			body = form.Get("data")
+		}
+	}
+	if data == nil {
+		body = strings.Join(strings.Fields(body), "")
+		encoding := c.Query("encoding")
+		if encoding == "" {
+			encoding = "base64"
+			if _, err := hex.DecodeString(body); err == nil {
+				encoding = "hex"
+			}
+		}
+		switch encoding {
+		case "hex":
+			data, err = hex.DecodeString(body)
+		case "base64":
+			data, err = base64.StdEncoding.DecodeString(body)
+		default:
+			err = fmt.Errorf("'encoding' must be hex or base64")
+		}
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": fmt.Sprintf("Can't decode %s body: %s", encoding, err),
+				"code":    "invalid_encoding",
+			})
+			return nil, false
+		}
+	}
+	if len(data) == 0 || len(data) > maxSize {
+		status, code := 400, "invalid_parameter"
+		if len(data) > maxSize {
+			status, code = 413, "body_too_large"
+		}
+		c.JSON(status, gin.H{
+			"message": fmt.Sprintf("Please send between 1 and %d bytes to decode", maxSize),
+			"code":    code,
+		})
+		return nil, false
+	}
+	return data, true
+}
+
+// decode a raw transaction
+func decodeTransaction(c *gin.Context) {
+	// DecodeRawTransaction(ctx context.Context, hex string) (bitcoind.VerboseTransactionInfo, error)
+	data, ok := decodeBody(c, bitcoind.MaxTransactionSize)
+	if !ok {
+		return
+	}
+	tx, err := btcClient.DecodeRawTransaction(c.Request.Context(), hex.EncodeToString(data))
+	if err != nil {
+		bitcoindError(c, err, "Can't decode transaction")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"txinfo":  tx,
+	})
+}
+
+// decode a script
+func decodeScript(c *gin.Context) {
+	// DecodeScript(ctx context.Context, hex string) (bitcoind.DecodeScriptResponse, error)
+	data, ok := decodeBody(c, bitcoind.MaxScriptSize)
+	if !ok {
+		return
+	}
+	script, err := btcClient.DecodeScript(c.Request.Context(), hex.EncodeToString(data))
+	if err != nil {
+		bitcoindError(c, err, "Can't decode script")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"script":  script,
+	})
+}
+
+// decode a PSBT
+func decodePSBT(c *gin.Context) {
+	// DecodePSBT(ctx context.Context, psbt string) (bitcoind.DecodePSBTResponse, error)
+	data, ok := decodeBody(c, bitcoind.MaxPSBTSize)
+	if !ok {
+		return
+	}
+	psbt, err := btcClient.DecodePSBT(c.Request.Context(), base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		bitcoindError(c, err, "Can't decode PSBT")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"psbt":    psbt,
+	})
+}
+
+// analyze a PSBT (what is missing, fee and size estimates)
+func analyzePSBT(c *gin.Context) {
+	// AnalyzePSBT(ctx context.Context, psbt string) (bitcoind.AnalyzePSBTResponse, error)
+	data, ok := decodeBody(c, bitcoind.MaxPSBTSize)
+	if !ok {
+		return
+	}
+	analysis, err := btcClient.AnalyzePSBT(c.Request.Context(), base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		bitcoindError(c, err, "Can't analyze PSBT")
+		return
+	}
+	c.JSON(200, gin.H{
+		"message":  "OK",
+		"analysis": analysis,
+	})
+}
+
+// bitcoind backends status
+func getBackends(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"message":  "OK",
+		"backends": btcPool.Status(),
+	})
+}
+
+// index endpoint
+// PinePhone Endpoints
+func batStatus(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"status": pineclient.GetStatus(),
+	})
+}
+
+func batCapacity(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"percent": pineclient.GetCapacity(),
+	})
+}
+func cpuTemp(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"cputemp": pineclient.GetCPUTemp(),
+	})
+}
+func gpuTemp(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"gputemp": pineclient.GetGPUTemp(),
+	})
+}
+
+// querystring test
+func testQueryString(c *gin.Context) {
+	param1 := c.DefaultQuery("param1", "none")
+	if param1 == "none" {
+		c.JSON(200, gin.H{
+			"message": "show this message if there is no query string",
+		})
+	} else {
+		c.JSON(200, gin.H{
+			"message": param1,
+		})
+	}
+}
+
+// Peer management (admin), on every backend with a result per backend
+// add, remove or try a node once (node=host:port, command=add|remove|onetry)
+func adminAddNode(c *gin.Context) {
+	// AddNode(ctx context.Context, node, command string) []bitcoind.BackendResult
+	node := c.PostForm("node")
+	command := c.DefaultPostForm("command", bitcoind.AddNodeAdd)
+	if node == "" || (command != bitcoind.AddNodeAdd && command != bitcoind.AddNodeRemove && command != bitcoind.AddNodeOneTry) {
+		c.JSON(400, gin.H{
+			"message": "Please specify a 'node' and a 'command' (add, remove or onetry)",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	backendResults(c, btcClient.AddNode(c.Request.Context(), node, command), "Error adding node")
+}
+
+// disconnect a peer by 'address' or 'nodeid' (see /api/peerinfo)
+func adminDisconnectNode(c *gin.Context) {
+	// DisconnectNode(ctx context.Context, address string, nodeID int64) []bitcoind.BackendResult
+	address := c.PostForm("address")
+	nodeID, err := strconv.ParseInt(c.DefaultPostForm("nodeid", "-1"), 10, 64)
+	if err != nil || (address == "" && nodeID < 0) || (address != "" && nodeID >= 0) {
+		c.JSON(400, gin.H{
+			"message": "Please specify either an 'address' or a 'nodeid'",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	backendResults(c, btcClient.DisconnectNode(c.Request.Context(), address, nodeID), "Error disconnecting node")
+}
+
+// ban or unban a subnet (subnet=ip[/mask], command=add|remove, bantime in seconds or a unix time with absolute=true)
+func adminSetBan(c *gin.Context) {
+	// SetBan(ctx context.Context, subnet, command string, banTime int64, absolute bool) []bitcoind.BackendResult
+	subnet := c.PostForm("subnet")
+	command := c.DefaultPostForm("command", bitcoind.SetBanAdd)
+	banTime, err := strconv.ParseInt(c.DefaultPostForm("bantime", "0"), 10, 64)
+	if subnet == "" || (command != bitcoind.SetBanAdd && command != bitcoind.SetBanRemove) || err != nil || banTime < 0 {
+		c.JSON(400, gin.H{
+			"message": "Please specify a 'subnet', a 'command' (add or remove) and optionally a 'bantime'",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	absolute := c.DefaultPostForm("absolute", "false") == "true"
+	backendResults(c, btcClient.SetBan(c.Request.Context(), subnet, command, banTime, absolute), "Error setting ban")
+}
+
+// list banned subnets
+func adminListBanned(c *gin.Context) {
+	// ListBanned(ctx context.Context) []bitcoind.BackendResult
+	backendResults(c, btcClient.ListBanned(c.Request.Context()), "Error listing banned peers")
+}
+
+// remove all bans
+func adminClearBanned(c *gin.Context) {
+	// ClearBanned(ctx context.Context) []bitcoind.BackendResult
+	backendResults(c, btcClient.ClearBanned(c.Request.Context()), "Error clearing banned peers")
+}
+
+// enable or disable all P2P network activity (active=true|false)
+func adminSetNetworkActive(c *gin.Context) {
+	// SetNetworkActive(ctx context.Context, active bool) []bitcoind.BackendResult
+	active, err := strconv.ParseBool(c.PostForm("active"))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": "Please specify 'active' (true or false)",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	backendResults(c, btcClient.SetNetworkActive(c.Request.Context(), active), "Error setting network activity")
+}
+
+// Transaction watches
+// watch a transaction (txid, url, confirmations = target confirmations, default 1)
+func addTxWatch(c *gin.Context) {
+	// Add(ctx context.Context, owner, txid, callbackURL string, target int64) (txwatch.Watch, error)
+	txid := c.PostForm("txid")
+	if _, err := hex.DecodeString(txid); err != nil || len(txid) != 64 {
+		c.JSON(400, gin.H{
+			"message": "Please specify a 'txid'",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	callback, err := url.Parse(c.PostForm("url"))
+	if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+		c.JSON(400, gin.H{
+			"message": "Please specify a callback 'url' (http or https)",
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	target, err := strconv.ParseInt(c.DefaultPostForm("confirmations", "1"), 10, 64)
+	if err != nil || target < 1 || target > txwatch.MaxTarget {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Invalid 'confirmations', must be between 1 and %d", txwatch.MaxTarget),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+	watch, err := txWatcher.Add(c.Request.Context(), c.GetString("user"), strings.ToLower(txid), callback.String(), target)
+	if errors.Is(err, txwatch.ErrForbiddenHost) || errors.Is(err, txwatch.ErrUnknownHost) {
+		c.JSON(400, gin.H{
+			"message": fmt.Sprintf("Invalid callback 'url': %s", err),
+			"code":    "invalid_callback_host",
+		})
+		return
+	}
+	if errors.Is(err, txwatch.ErrTooManyWatches) {
+		c.JSON(429, gin.H{
+			"message": "Too many transactions are watched already",
+			"code":    "too_many_watches",
+		})
+		return
+	}
+	if errors.Is(err, txwatch.ErrOwnerLimit) {
+		c.JSON(429, gin.H{
+			"message": fmt.Sprintf("You can watch at most %d transactions", conf.TxWatch.MaxWatchesPerUser),
+			"code":    "too_many_watches",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Error adding watch: %s", err),
+			"code":    "internal_error",
+		})
+		return
+	}
+	c.JSON(201, gin.H{
+		"message": "OK",
+		"watch":   watch,
+	})
+}
+
+// all transaction watches of the signed in user
+func listTxWatches(c *gin.Context) {
+	// List(owner string) []txwatch.Watch
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"watches": txWatcher.List(c.GetString("user")),
+	})
+}
+
+// a single transaction watch, with its undelivered callbacks
+func getTxWatch(c *gin.Context) {
+	// Get(owner, id string) (txwatch.Watch, error)
+	watch, err := txWatcher.Get(c.GetString("user"), c.Param("id"))
+	if err != nil {
+		c.JSON(404, gin.H{
+			"message": "Watch not found",
+			"code":    "not_found",
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+		"watch":   watch,
+	})
+}
+
+// stop watching a transaction
+func removeTxWatch(c *gin.Context) {
+	// Remove(owner, id string) error
+	err := txWatcher.Remove(c.GetString("user"), c.Param("id"))
+	if errors.Is(err, txwatch.ErrNotFound) {
+		c.JSON(404, gin.H{
+			"message": "Watch not found",
+			"code":    "not_found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Error removing watch: %s", err),
+			"code":    "internal_error",
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "OK",
+	})
+}
+
+// BTC Price
+func getBtcPrice(c *gin.Context) {
+	price, err := btcprice.GetPriceFeed(conf)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"message": fmt.Sprintf("Error reading price: %s", err),
+		})
+	} else {
+		// return string (GetPriceFeed actually returns []byte
+		c.String(200, string(price))
+	}
+}
+
+// Main entrypoint
+func main() {
+	setup()
+	router := gin.Default()
+	router.Use(cors.Default())
+	router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/api/stream"})))
+	r := router.Group("/api")
+	r.GET("/", func(c *gin.Context) {
+		c.JSON(200, common.FormatRoutes(router.Routes()))
+	})
 	r.GET("/info", info)
 	if conf.BitcoinClient {
 		fmt.Println("Bitcoin client enabled")
+		go btcPool.Run(context.Background())
+		startZMQ(context.Background())
+		go chainWatcher.Run(context.Background())
+		if mempoolAnalyzer != nil {
+			go mempoolAnalyzer.Run(context.Background())
+		}
+		if netTotalsSampler != nil {
+			go netTotalsSampler.Run(context.Background())
+		}
+		streamServer := stream.New(eventBus, stream.Options{
+			MaxConnections:   int(conf.StreamMaxConnections),
+			MaxSubscriptions: int(conf.StreamMaxSubscriptions),
+			Last:             chainWatcher.Last,
+		})
 		r.GET("/test", testQueryString)
 		// Bitcoin Blockchain Querying
 		r.GET("/blocks", blockCount)                    // blockcount
+		r.GET("/blocks/range", getBlockRange)           // blocks from height to height
 		r.GET("/blockchaininfo", blockchainInfo)        // blockchainInfo
 		r.GET("/networkinfo", networkInfo)              // networkInfo
 		r.GET("/mempoolinfo", getMempoolInfo)           // get mempool stats
@@ -447,18 +2031,98 @@ func main() {
 		r.GET("/txid/:id", blockchainTxInfo)            // txid
 		r.GET("/mempool", mempoolContents)              // mempool contents
 		r.POST("/pushtx", pushTransaction)              // Push transaction
+		r.POST("/pushtx/test", testTransaction)         // Test transaction(s) (testmempoolaccept)
 		r.GET("/getblockhash", getBestBlockHash)        // Get best blockhash
 		r.GET("/blockheight/:id", getBlockHashByHeight) // get blockhash by height
 		r.GET("/block/:id", getBlock)                   // getBlock
+		r.GET("/block/:id/txs", getBlockTransactions)   // getBlock (verbosity 2, paginated)
+		r.GET("/block/:id/hex", getBlockHex)            // getBlock (verbosity 0)
+		r.GET("/block/:id/raw", getBlockRaw)            // getBlock (verbosity 0), streamed as hex or binary
+		r.GET("/tx/:txid/raw", getTransactionRaw)       // getrawtransaction, streamed as hex or binary
+		r.GET("/blockstats", getBlockStatsRange)        // getBlockStats over a range, with aggregates
 		r.GET("/blockstats/:id", getBlockStats)         // getBlockStats
+		r.GET("/backends", getBackends)                 // bitcoind backends health
+		// Network traffic
+		r.GET("/nettotals", getNetTotals)                // getnettotals
+		r.GET("/nettotals/history", getNetTotalsHistory) // bandwidth per minute
+		// Headers
+		r.GET("/header/:hash", getBlockHeader)        // getblockheader
+		r.GET("/header/:hash/hex", getBlockHeaderHex) // getblockheader (not verbose)
+		r.GET("/headers", getHeaders)                 // headers from height
+		r.GET("/chaintips", getChainTips)             // getchaintips
+		r.GET("/reorgs", getReorgs)                   // reorgs seen by the chain watcher
+		// UTXO set
+		r.GET("/utxo/:txid/:vout", getUTXO) // gettxout
+		r.POST("/scan", startScan)          // scantxoutset start (in the background)
+		r.GET("/scan", getScan)             // scantxoutset status / result
+		r.DELETE("/scan", abortScan)        // scantxoutset abort
+		if addressIndex != nil {
+			go addressIndex.Run(context.Background())
+			if conf.Electrum.Enabled {
+				startElectrum(context.Background())
+			}
+			// Address index
+			r.GET("/address/:addr/txs", getAddressTxs)    // confirmed history
+			r.GET("/address/:addr/utxo", getAddressUTXOs) // confirmed unspent outputs
+		}
+		// Mempool entries
+		r.GET("/mempool/:txid", mempoolRoute)                      // mempool entry, or /histogram and /stats
+		r.GET("/mempool/:txid/ancestors", getMempoolAncestors)     // mempool ancestors
+		r.GET("/mempool/:txid/descendants", getMempoolDescendants) // mempool descendants
+		// Fee estimation
+		r.GET("/fees", getFees) // estimatesmartfee
+		// Chain events (WebSocket or SSE)
+		r.GET("/stream", streamServer.Handle)
+		// Decoding (hex or base64 body)
+		r.POST("/decode/tx", decodeTransaction)     // decoderawtransaction
+		r.POST("/decode/script", decodeScript)      // decodescript
+		r.POST("/decode/psbt", decodePSBT)          // decodepsbt
+		r.POST("/decode/psbt/analyze", analyzePSBT) // analyzepsbt
 		// BTC Price API
 		r.GET("/btcprice", getBtcPrice)
+		if conf.EsploraAPI {
+			// Esplora compatible API (outside of /api, it has its own response format)
+			esplora.New(btcClient).Register(router.Group("/esplora"))
+		}
 	} else {
 		fmt.Println("Bitcoin client not enabled")
 	}
 	if conf.AuthScheme == "JWT" {
 		fmt.Println("Authentication endpoints")
 		r.POST("login", signin) // Signin Endpoint
+		if conf.BitcoinClient {
+			// Watch-only wallets (signed in users only)
+			w := r.Group("/wallet", requireJWT, checkWalletName)
+			w.POST("", createWallet)                              // createwallet (watch-only)
+			w.POST("/:name/load", loadWallet)                     // loadwallet
+			w.POST("/:name/address", importWalletAddress)         // importaddress
+			w.POST("/:name/descriptors", importWalletDescriptors) // importdescriptors
+			w.POST("/:name/newaddress", walletNewAddress)         // getnewaddress
+			w.GET("/:name/received", walletReceived)              // listreceivedbyaddress
+			w.GET("/:name/received/:address", walletReceived)     // listreceivedbyaddress (single address)
+			w.GET("/:name/transactions", walletTransactions)      // listtransactions
+			if len(conf.JWTConfig.AdminUsers) > 0 {
+				// Peer management (admin role only)
+				a := r.Group("/admin/peers", requireAdmin)
+				a.POST("/node", adminAddNode)              // addnode
+				a.POST("/disconnect", adminDisconnectNode) // disconnectnode
+				a.POST("/ban", adminSetBan)                // setban
+				a.GET("/banned", adminListBanned)          // listbanned
+				a.DELETE("/banned", adminClearBanned)      // clearbanned
+				a.POST("/network", adminSetNetworkActive)  // setnetworkactive
+			}
+			if txWatcher != nil {
+				// Transaction watches with callbacks (verified users only)
+				go txWatcher.Run(context.Background())
+				t := r.Group("/watch/tx", requireJWT, requireVerifiedUser)
+				t.POST("", addTxWatch)          // watch a transaction
+				t.GET("", listTxWatches)        // all watches
+				t.GET("/:id", getTxWatch)       // a single watch
+				t.DELETE("/:id", removeTxWatch) // stop watching
+			}
+		}
+	} else if conf.BitcoinClient {
+		fmt.Println("Wallet and admin endpoints not enabled (requires auth-scheme = \"JWT\")")
 	}
 	// Pinephone stuff
 	r.GET("/batteryStatus", batStatus)
@@ -0,0 +1,419 @@
+package main
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+	"gitlab.com/nolim1t/golang-httpd-test/common"
+)
+
+func TestWatchesNeedVerifiedUsers(t *testing.T) {
+	key, err := ioutil.TempFile("", "jwt-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(key.Name())
+	key.WriteString("test key")
+	key.Close()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	conf.JWTConfig = common.JwtConfig{PrivKeyStore: key.Name(), Users: map[string]string{"alice": string(hash)}}
+	defer func() { conf.JWTConfig = common.JwtConfig{} }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", signin)
+	r.GET("/watch/tx", requireJWT, requireVerifiedUser, func(c *gin.Context) { c.String(200, c.GetString("user")) })
+	login := func(username, password string) (status int, token string) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{"username": {username}, "password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(w, req)
+		var reply struct{ JWT string }
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		return w.Code, reply.JWT
+	}
+	watches := func(token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/watch/tx", nil)
+		req.Header.Set("JWT", token)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status, _ := login("alice", "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("alice with a wrong password: got %d, want 401", status)
+	}
+	status, token := login("alice", "pw")
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("alice: got %d, want a token", status)
+	}
+	if got := watches(token); got != http.StatusOK {
+		t.Errorf("alice's watches: got %d, want 200", got)
+	}
+	// anyone else gets a token, but not for the watches
+	status, token = login("mallory", "anything")
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("mallory: got %d, want a token", status)
+	}
+	if got := watches(token); got != http.StatusForbidden {
+		t.Errorf("mallory's watches: got %d, want 403", got)
+	}
+}
+
+func TestCheckWalletName(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	w := r.Group("/wallet", checkWalletName)
+	w.POST("", func(c *gin.Context) { c.Status(200) })
+	w.POST("/:name/load", func(c *gin.Context) { c.Status(200) })
+	for path, want := range map[string]int{
+		"/wallet":                                      200, // the name is checked by createWallet
+		"/wallet/watch-1/load":                         200,
+		"/wallet/..%5Cother/load":                      400,
+		"/wallet/wallet.dat/load":                      400,
+		"/wallet/" + strings.Repeat("a", 65) + "/load": 400,
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: got %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+// A stream of hex that fails with err once it's read
+type failingStream struct {
+	io.Reader
+	err error
+}
+
+func (s *failingStream) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err == io.EOF && s.err != nil {
+		err = s.err
+	}
+	return n, err
+}
+
+func (s *failingStream) Close() error { return nil }
+
+func rawRouter(err, streamErr error) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/raw", func(c *gin.Context) {
+		streamRaw(c, "Error getting block", func(context.Context) (io.ReadCloser, error) {
+			if err != nil {
+				return nil, err
+			}
+			return &failingStream{Reader: strings.NewReader("00ff41"), err: streamErr}, nil
+		})
+	})
+	return r
+}
+
+func TestStreamRaw(t *testing.T) {
+	for _, test := range []struct {
+		accept      string
+		err         error
+		status      int
+		contentType string
+		body        string // prefix
+	}{
+		{"", nil, 200, "text/plain; charset=utf-8", "00ff41"},
+		{"text/plain", nil, 200, "text/plain; charset=utf-8", "00ff41"},
+		{"application/octet-stream", nil, 200, "application/octet-stream", "\x00\xffA"},
+		{"application/json", nil, 406, "application/json; charset=utf-8", `{"code":"not_acceptable"`},
+		{"", &bitcoind.RPCError{Code: bitcoind.RPCInvalidAddressOrKey, Message: "Block not found"}, 404, "application/json; charset=utf-8", `{"code":"invalid_address_or_key"`},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/raw", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		rawRouter(test.err, nil).ServeHTTP(w, req)
+		body := w.Body.String()
+		if w.Code != test.status || w.Header().Get("Content-Type") != test.contentType || !strings.HasPrefix(body, test.body) {
+			t.Errorf("Accept %q, error %v: got %d %s %q, want %d %s %q", test.accept, test.err, w.Code, w.Header().Get("Content-Type"), body, test.status, test.contentType, test.body)
+		}
+	}
+}
+
+func TestStreamRawCutShort(t *testing.T) {
+	server := httptest.NewServer(rawRouter(nil, io.ErrUnexpectedEOF))
+	defer server.Close()
+	res, err := http.Get(server.URL + "/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	// the status is sent already, the connection is cut instead of ending the body
+	body, err := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 200 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %d %q, %v, want a body that ends early", res.StatusCode, body, err)
+	}
+}
+
+// A node answering the calls under test, the others aren't implemented
+type fakeNode struct {
+	BitcoinClient
+	err     error // returned by every call
+	mempool map[string]bitcoind.MempoolEntry
+}
+
+func (f *fakeNode) GetBlockHeader(_ context.Context, hash string) (bitcoind.BlockHeaderResponse, error) {
+	return bitcoind.BlockHeaderResponse{Height: 100}, f.err
+}
+
+func (f *fakeNode) GetBlockStatsRange(_ context.Context, from, to int64, stats []string) ([]bitcoind.BlockStats, error) {
+	var blocks []bitcoind.BlockStats
+	for height := from; height <= to; height++ {
+		blocks = append(blocks, bitcoind.BlockStats{"height": float64(height)})
+	}
+	return blocks, f.err
+}
+
+func TestBlockStatsRangeParams(t *testing.T) {
+	defer func(client BitcoinClient) { btcClient = client }(btcClient)
+	hash := strings.Repeat("ab", 32)
+	for _, test := range []struct {
+		query  string
+		err    error
+		status int
+		code   string
+	}{
+		{"from=99&to=" + hash, nil, 200, ""},
+		{"from=" + hash + "&to=" + hash, nil, 200, ""},
+		{"from=99&to=x", nil, 400, "invalid_parameter"},
+		{"from=99&to=" + strings.Repeat("zz", 32), nil, 400, "invalid_parameter"},
+		{"from=99&to=" + hash, &bitcoind.RPCError{Code: bitcoind.RPCInvalidAddressOrKey, Message: "Block not found"}, 404, "invalid_address_or_key"},
+		{"from=99&to=" + hash, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, 503, "backend_unavailable"},
+		{"from=99&to=" + hash, context.DeadlineExceeded, 504, "backend_timeout"},
+		{"from=101&to=" + hash, nil, 400, "invalid_parameter"},
+	} {
+		btcClient = &fakeNode{err: test.err}
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.GET("/blockstats", getBlockStatsRange)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/blockstats?"+test.query, nil))
+		var reply struct{ Code string }
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		if w.Code != test.status || reply.Code != test.code {
+			t.Errorf("%s with %v: got %d %q, want %d %q", test.query, test.err, w.Code, reply.Code, test.status, test.code)
+		}
+	}
+}
+
+func TestBitcoindErrorStatus(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://localhost:8332", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	for _, test := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&bitcoind.RPCError{Code: bitcoind.RPCInvalidAddressOrKey}, 404, "invalid_address_or_key"},
+		{&bitcoind.RPCError{Code: bitcoind.RPCInvalidParameter}, 400, "invalid_parameter"},
+		{&bitcoind.RPCError{Code: bitcoind.RPCWalletAlreadyLoaded}, 409, "wallet_already_loaded"},
+		{&bitcoind.RPCError{Code: bitcoind.RPCVerifyRejected}, 422, "verify_rejected"},
+		{&bitcoind.RPCError{Code: bitcoind.RPCInWarmup}, 503, "in_warmup"},
+		{fmt.Errorf("block 5: %w", &bitcoind.RPCError{Code: bitcoind.RPCMiscError}), 500, "misc_error"},
+		{refused, 503, "backend_unavailable"},
+		{&url.Error{Op: "Post", URL: "http://localhost:8332", Err: context.DeadlineExceeded}, 504, "backend_timeout"},
+		// the HTTP client went away
+		{&url.Error{Op: "Post", URL: "http://localhost:8332", Err: context.Canceled}, 499, "client_closed_request"},
+		{bitcoind.ErrUnauthorized, 503, "backend_unauthorized"},
+		{errors.New("unexpected end of JSON input"), 500, "internal_error"},
+	} {
+		if status, code := bitcoindErrorStatus(test.err); status != test.status || code != test.code {
+			t.Errorf("%v: got %d %s, want %d %s", test.err, status, code, test.status, test.code)
+		}
+	}
+}
+
+func (f *fakeNode) GetMempoolEntry(_ context.Context, txid string) (bitcoind.MempoolEntry, error) {
+	entry, ok := f.mempool[txid]
+	if !ok {
+		return entry, &bitcoind.RPCError{Code: bitcoind.RPCInvalidAddressOrKey, Message: "Transaction not in mempool"}
+	}
+	return entry, f.err
+}
+
+func (f *fakeNode) GetMempoolAncestors(_ context.Context, txid string) (map[string]bitcoind.MempoolEntry, error) {
+	if _, err := f.GetMempoolEntry(context.Background(), txid); err != nil {
+		return nil, err
+	}
+	return f.mempool, f.err
+}
+
+func get(handlers map[string]gin.HandlerFunc, path string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	for route, handler := range handlers {
+		r.GET(route, handler)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestMempoolEndpoints(t *testing.T) {
+	defer func(client BitcoinClient) { btcClient = client }(btcClient)
+	btcClient = &fakeNode{mempool: map[string]bitcoind.MempoolEntry{
+		"t1": {VSize: 141, Fees: bitcoind.MempoolEntryFees{Base: 0.00000282}},
+	}}
+	routes := map[string]gin.HandlerFunc{
+		"/mempool/:txid":           mempoolRoute,
+		"/mempool/:txid/ancestors": getMempoolAncestors,
+	}
+	for _, test := range []struct {
+		path   string
+		status int
+		body   string // contained
+	}{
+		{"/mempool/t1", 200, `"entry":{"vsize":141,`},
+		{"/mempool/t2", 404, `"code":"invalid_address_or_key"`},
+		{"/mempool/t1/ancestors", 200, `"ancestors":{"t1":{"vsize":141,`},
+		{"/mempool/t2/ancestors", 404, `"code":"invalid_address_or_key"`},
+		// not a txid, analytics are disabled
+		{"/mempool/histogram", 503, `"code":"not_available"`},
+		{"/mempool/stats", 503, `"code":"not_available"`},
+	} {
+		w := get(routes, test.path)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: got %d %s, want %d with %s", test.path, w.Code, w.Body.String(), test.status, test.body)
+		}
+	}
+}
+
+func (f *fakeNode) TestMempoolAccept(_ context.Context, rawTxs []string, maxFeeRate float64) ([]bitcoind.MempoolAcceptResult, error) {
+	var results []bitcoind.MempoolAcceptResult
+	for _, rawTx := range rawTxs {
+		result := bitcoind.MempoolAcceptResult{TransactionID: rawTx, Allowed: rawTx != "bad"}
+		if maxFeeRate > 0 && maxFeeRate < 0.1 {
+			result.Allowed, result.RejectReason = false, "max-fee-exceeded"
+		}
+		results = append(results, result)
+	}
+	return results, f.err
+}
+
+func TestTestTransaction(t *testing.T) {
+	defer func(client BitcoinClient) { btcClient = client }(btcClient)
+	btcClient = &fakeNode{}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/pushtx", pushTransaction)
+	r.POST("/pushtx/test", testTransaction)
+
+	tooMany := url.Values{}
+	for i := 0; i <= bitcoind.MaxPackageCount; i++ {
+		tooMany.Add("hex", "aa")
+	}
+	for _, test := range []struct {
+		path    string
+		form    url.Values
+		status  int
+		allowed bool
+	}{
+		{"/pushtx/test", url.Values{"hex": {"aa"}}, 200, true},
+		{"/pushtx/test", url.Values{"hex": {"aa", "bad"}}, 200, false},
+		{"/pushtx/test", url.Values{"hex": {"aa"}, "maxfeerate": {"0.01"}}, 200, false},
+		{"/pushtx/test", url.Values{"hex": {"aa"}, "maxfeerate": {"-1"}}, 400, false},
+		{"/pushtx/test", url.Values{}, 400, false},
+		{"/pushtx/test", tooMany, 400, false},
+		{"/pushtx/test", url.Values{"hex": tooMany["hex"][1:]}, 200, true},
+		// a dry run of pushtx is a test
+		{"/pushtx", url.Values{"hex": {"aa", "bad"}, "dry_run": {"true"}}, 200, false},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", test.path, strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(w, req)
+		var reply struct {
+			Allowed bool
+			Results []bitcoind.MempoolAcceptResult
+		}
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		if w.Code != test.status || reply.Allowed != test.allowed {
+			t.Errorf("%s %v: got %d %s, want %d allowed %v", test.path, test.form, w.Code, w.Body.String(), test.status, test.allowed)
+		}
+		if w.Code == 200 && len(reply.Results) != len(test.form["hex"]) {
+			t.Errorf("%s %v: %d results", test.path, test.form, len(reply.Results))
+		}
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/decode", func(c *gin.Context) {
+		if data, ok := decodeBody(c, 6); ok {
+			c.String(200, hex.EncodeToString(data))
+		}
+	})
+	for _, test := range []struct {
+		query, contentType string
+		body               io.Reader
+		status             int
+		want               string
+	}{
+		{"", "text/plain", strings.NewReader("00ff41"), 200, "00ff41"},
+		{"", "text/plain", strings.NewReader("00 ff\n41\n"), 200, "00ff41"},
+		// not hex, so base64
+		{"", "text/plain", strings.NewReader("AP9B"), 200, "00ff41"},
+		{"", "application/x-www-form-urlencoded", strings.NewReader("data=AP9B"), 200, "00ff41"},
+		{"", "application/x-www-form-urlencoded", strings.NewReader("00ff41"), 200, "00ff41"},
+		{"", "application/octet-stream", strings.NewReader("\x00\xffA"), 200, "00ff41"},
+		// valid hex, read as base64 on request
+		{"?encoding=base64", "text/plain", strings.NewReader("00ff4100"), 200, "d347dfe35d34"},
+		{"?encoding=hex", "text/plain", strings.NewReader("AP9B"), 400, "invalid_encoding"},
+		{"?encoding=bech32", "text/plain", strings.NewReader("00ff41"), 400, "invalid_encoding"},
+		{"", "text/plain", strings.NewReader("AP9B!"), 400, "invalid_encoding"},
+		{"", "text/plain", strings.NewReader(""), 400, "invalid_parameter"},
+		{"", "text/plain", strings.NewReader("00112233445566"), 413, "body_too_large"},
+		{"", "text/plain", strings.NewReader(strings.Repeat("0", 6*2+1025)), 413, "body_too_large"},
+		// a broken upload isn't a large one
+		{"", "text/plain", &failingStream{Reader: strings.NewReader("00ff"), err: io.ErrUnexpectedEOF}, 400, "invalid_request"},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/decode"+test.query, test.body)
+		req.Header.Set("Content-Type", test.contentType)
+		r.ServeHTTP(w, req)
+		got := w.Body.String()
+		if w.Code != 200 {
+			var reply struct{ Code string }
+			json.Unmarshal(w.Body.Bytes(), &reply)
+			got = reply.Code
+		}
+		if w.Code != test.status || got != test.want {
+			t.Errorf("%s %s: got %d %s, want %d %s", test.query, test.contentType, w.Code, got, test.status, test.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package mempool
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Mempool analytics
+
+Pulls the whole mempool (getrawmempool, verbose) on an interval and sums it up:
+a fee rate histogram weighted by vsize, and counts of RBF signaling and segwit
+transactions. Fee rates are the base fee over vsize in sat/vB, amounts are in
+satoshis. The verbose mempool can be large, so keep the interval reasonable on
+busy nodes.
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/getrawmempool.html
+*/
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+const (
+	DefaultInterval = 60 * time.Second
+
+	// Virtual size of a block, used to express the mempool in blocks
+	BlockVSize = 1000000
+)
+
+var (
+	// Lower bounds (sat/vB) of the histogram buckets
+	FeeLevels = []float64{0, 1, 2, 3, 4, 5, 6, 8, 10, 12, 15, 20, 30, 40, 50, 60, 70, 80, 90, 100,
+		125, 150, 175, 200, 250, 300, 350, 400, 500, 600, 700, 800, 900, 1000, 1200, 1400, 1700, 2000}
+)
+
+type (
+	// The calls the analyzer needs (implemented by bitcoind.Pool)
+	Client interface {
+		GetRawMempoolVerbose(context.Context) (map[string]bitcoind.MempoolEntry, error)
+	}
+
+	Analyzer struct {
+		client   Client
+		interval time.Duration
+
+		mu       sync.RWMutex
+		snapshot *Snapshot
+	}
+
+	// Mempool transactions paying between MinFeeRate and MaxFeeRate (exclusive)
+	Bucket struct {
+		MinFeeRate float64 `json:"min_fee_rate"`
+		MaxFeeRate float64 `json:"max_fee_rate,omitempty"` // not set for the highest bucket
+		Count      int64   `json:"count"`
+		VSize      int64   `json:"vsize"`
+		TotalFee   int64   `json:"total_fee"`
+		// vsize of all transactions paying at least MaxFeeRate, which get mined first
+		VSizeAhead int64 `json:"vsize_ahead"`
+	}
+
+	Stats struct {
+		Count       int64   `json:"count"`
+		VSize       int64   `json:"vsize"`
+		TotalFee    int64   `json:"total_fee"`
+		Blocks      float64 `json:"blocks"` // vsize in full blocks
+		RBFCount    int64   `json:"rbf_count"`
+		SegwitCount int64   `json:"segwit_count"`
+		// sat/vB, the median is weighted by vsize
+		MinFeeRate     float64 `json:"min_fee_rate"`
+		MedianFeeRate  float64 `json:"median_fee_rate"`
+		AverageFeeRate float64 `json:"average_fee_rate"`
+		MaxFeeRate     float64 `json:"max_fee_rate"`
+	}
+
+	Snapshot struct {
+		Updated   time.Time `json:"updated"`
+		Stats     Stats     `json:"stats"`
+		Histogram []Bucket  `json:"histogram"` // highest fee rates first
+	}
+)
+
+func New(client Client, interval time.Duration) *Analyzer {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Analyzer{
+		client:   client,
+		interval: interval,
+	}
+}
+
+// Run refreshes the snapshot on every interval until ctx is done
+func (a *Analyzer) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Refresh(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("Mempool analytics: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Snapshot returns the latest analysis, false before the first one succeeded
+func (a *Analyzer) Snapshot() (Snapshot, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.snapshot == nil {
+		return Snapshot{}, false
+	}
+	return *a.snapshot, true
+}
+
+// Refresh pulls the mempool and replaces the snapshot
+func (a *Analyzer) Refresh(ctx context.Context) error {
+	entries, err := a.client.GetRawMempoolVerbose(ctx)
+	if err != nil {
+		return err
+	}
+	snapshot := Analyze(entries)
+	a.mu.Lock()
+	a.snapshot = &snapshot
+	a.mu.Unlock()
+	return nil
+}
+
+// Analyze builds the stats and histogram of a verbose mempool
+func Analyze(entries map[string]bitcoind.MempoolEntry) Snapshot {
+	type feeRate struct {
+		rate  float64
+		vsize int64
+	}
+	rates := make([]feeRate, 0, len(entries))
+	buckets := make([]Bucket, len(FeeLevels))
+	for i, level := range FeeLevels {
+		buckets[i].MinFeeRate = level
+		if i+1 < len(FeeLevels) {
+			buckets[i].MaxFeeRate = FeeLevels[i+1]
+		}
+	}
+
+	var stats Stats
+	for txid, entry := range entries {
+		fee := int64(math.Round(entry.Fees.Base * 1e8))
+		stats.Count++
+		stats.VSize += entry.VSize
+		stats.TotalFee += fee
+		if entry.Replaceable {
+			stats.RBFCount++
+		}
+		// the witness txid only differs from the txid when there is witness data
+		if entry.WitnessTxID != "" && entry.WitnessTxID != txid {
+			stats.SegwitCount++
+		}
+		if entry.VSize <= 0 {
+			continue
+		}
+		rate := float64(fee) / float64(entry.VSize)
+		rates = append(rates, feeRate{rate, entry.VSize})
+		i := sort.SearchFloat64s(FeeLevels, rate)
+		if i == len(FeeLevels) || FeeLevels[i] > rate {
+			i--
+		}
+		buckets[i].Count++
+		buckets[i].VSize += entry.VSize
+		buckets[i].TotalFee += fee
+	}
+
+	if len(rates) > 0 {
+		sort.Slice(rates, func(i, j int) bool { return rates[i].rate < rates[j].rate })
+		stats.MinFeeRate = round(rates[0].rate)
+		stats.MaxFeeRate = round(rates[len(rates)-1].rate)
+		var weight int64
+		for _, r := range rates {
+			weight += r.vsize
+			if weight*2 >= stats.VSize {
+				stats.MedianFeeRate = round(r.rate)
+				break
+			}
+		}
+	}
+	if stats.VSize > 0 {
+		stats.AverageFeeRate = round(float64(stats.TotalFee) / float64(stats.VSize))
+	}
+	stats.Blocks = round(float64(stats.VSize) / BlockVSize)
+
+	// highest first, everything in higher buckets is ahead
+	histogram := make([]Bucket, len(buckets))
+	var ahead int64
+	for i := range buckets {
+		bucket := buckets[len(buckets)-1-i]
+		bucket.VSizeAhead = ahead
+		ahead += bucket.VSize
+		histogram[i] = bucket
+	}
+
+	return Snapshot{
+		Updated:   time.Now(),
+		Stats:     stats,
+		Histogram: histogram,
+	}
+}
+
+// rounded to 3 decimals
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
@@ -0,0 +1,94 @@
+package mempool
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"testing"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+func entry(vsize int64, fee float64) bitcoind.MempoolEntry {
+	return bitcoind.MempoolEntry{VSize: vsize, Fees: bitcoind.MempoolEntryFees{Base: fee}}
+}
+
+func TestAnalyze(t *testing.T) {
+	entries := map[string]bitcoind.MempoolEntry{
+		"t1": entry(100, 0.000001),   // 1 sat/vB, on the lower bound of a bucket
+		"t2": entry(200, 0.000003),   // 1.5
+		"t3": entry(250, 0.00005),    // 20
+		"t4": entry(150, 0.00000015), // 0.1
+		"t5": entry(100, 0.003),      // 3000, above the highest level
+		"t6": entry(0, 0.0001),       // no vsize, counted but not bucketed
+	}
+	rbf, segwit := entries["t1"], entries["t2"]
+	rbf.Replaceable, rbf.WitnessTxID = true, "t1"
+	segwit.WitnessTxID = "w2"
+	entries["t1"], entries["t2"] = rbf, segwit
+
+	snapshot := Analyze(entries)
+	want := Stats{
+		Count:          6,
+		VSize:          800,
+		TotalFee:       100 + 300 + 5000 + 15 + 300000 + 10000,
+		Blocks:         0.001,
+		RBFCount:       1,
+		SegwitCount:    1,
+		MinFeeRate:     0.1,
+		MedianFeeRate:  1.5, // 150 + 100 + 200 vbytes reach half of 800
+		AverageFeeRate: 394.269,
+		MaxFeeRate:     3000,
+	}
+	if snapshot.Stats != want {
+		t.Errorf("stats:\ngot  %+v\nwant %+v", snapshot.Stats, want)
+	}
+
+	if len(snapshot.Histogram) != len(FeeLevels) {
+		t.Fatalf("%d buckets, want %d", len(snapshot.Histogram), len(FeeLevels))
+	}
+	for i := 1; i < len(snapshot.Histogram); i++ {
+		if snapshot.Histogram[i].MinFeeRate >= snapshot.Histogram[i-1].MinFeeRate {
+			t.Fatal("histogram not sorted highest fee rate first")
+		}
+	}
+	buckets := make(map[float64]Bucket)
+	var count int64
+	for _, bucket := range snapshot.Histogram {
+		buckets[bucket.MinFeeRate] = bucket
+		count += bucket.Count
+	}
+	if count != 5 {
+		t.Errorf("%d transactions in the histogram, want 5", count)
+	}
+	for _, want := range []Bucket{
+		{MinFeeRate: 2000, Count: 1, VSize: 100, TotalFee: 300000, VSizeAhead: 0},
+		{MinFeeRate: 20, MaxFeeRate: 30, Count: 1, VSize: 250, TotalFee: 5000, VSizeAhead: 100},
+		{MinFeeRate: 10, MaxFeeRate: 12, VSizeAhead: 350},
+		{MinFeeRate: 1, MaxFeeRate: 2, Count: 2, VSize: 300, TotalFee: 400, VSizeAhead: 350},
+		{MinFeeRate: 0, MaxFeeRate: 1, Count: 1, VSize: 150, TotalFee: 15, VSizeAhead: 650},
+	} {
+		if got := buckets[want.MinFeeRate]; got != want {
+			t.Errorf("bucket %g:\ngot  %+v\nwant %+v", want.MinFeeRate, got, want)
+		}
+	}
+}
+
+func TestAnalyzeEmptyMempool(t *testing.T) {
+	snapshot := Analyze(nil)
+	if snapshot.Stats != (Stats{}) {
+		t.Errorf("stats %+v, want zeros", snapshot.Stats)
+	}
+	for _, bucket := range snapshot.Histogram {
+		if bucket.Count != 0 || bucket.VSize != 0 || bucket.VSizeAhead != 0 {
+			t.Errorf("bucket %+v, want empty", bucket)
+		}
+	}
+}
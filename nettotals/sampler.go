@@ -0,0 +1,151 @@
+package nettotals
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Bandwidth history
+
+Samples getnettotals on an interval and turns the deltas between samples into
+per-minute traffic. bitcoind's counters start at zero when it restarts, so a
+sample with lower counters than the previous one only starts a new baseline,
+and so does a sample from another backend after the pool switched nodes.
+The elapsed time comes from the node's own clock (timemillis), so slow RPC
+calls don't skew the rates. The history is kept in memory only.
+
+Reference:
+https://developer.bitcoin.org/reference/rpc/getnettotals.html
+*/
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+const (
+	DefaultInterval = 10 * time.Second
+
+	// Minutes of history kept (24 hours)
+	MaxHistory = 24 * 60
+)
+
+type (
+	// The calls the sampler needs (implemented by bitcoind.Pool)
+	Client interface {
+		BackendNetTotals(context.Context) (backend string, totals bitcoind.NetTotalsResponse, err error)
+	}
+
+	Sampler struct {
+		client   Client
+		interval time.Duration
+
+		mu          sync.RWMutex
+		last        *bitcoind.NetTotalsResponse
+		lastBackend string   // that answered last
+		history     []Minute // oldest first
+	}
+
+	// Traffic during one minute. Rates are in bytes per second over the part of
+	// the minute that was sampled (Seconds), so gaps don't show up as drops.
+	Minute struct {
+		Time        time.Time `json:"time"` // start of the minute
+		BytesRecv   int64     `json:"bytes_recv"`
+		BytesSent   int64     `json:"bytes_sent"`
+		Seconds     float64   `json:"seconds"`
+		RecvPerSec  float64   `json:"recv_per_sec"`
+		SentPerSec  float64   `json:"sent_per_sec"`
+		totalMillis int64
+	}
+)
+
+func New(client Client, interval time.Duration) *Sampler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Sampler{
+		client:   client,
+		interval: interval,
+	}
+}
+
+// Run samples on every interval until ctx is done
+func (s *Sampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Sample(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("Net totals: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample gets the counters and adds the traffic since the previous sample to the history
+func (s *Sampler) Sample(ctx context.Context) error {
+	backend, totals, err := s.client.BackendNetTotals(ctx)
+	if err != nil {
+		return err
+	}
+	s.add(backend, totals)
+	return nil
+}
+
+func (s *Sampler) add(backend string, totals bitcoind.NetTotalsResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, lastBackend := s.last, s.lastBackend
+	s.last, s.lastBackend = &totals, backend
+	if last == nil || backend != lastBackend {
+		// nothing to compare with yet, or the counters of another node
+		return
+	}
+	millis := totals.TimeMillis - last.TimeMillis
+	recv := totals.TotalBytesRecv - last.TotalBytesRecv
+	sent := totals.TotalBytesSent - last.TotalBytesSent
+	if millis <= 0 || recv < 0 || sent < 0 {
+		// bitcoind restarted, start over from this sample
+		return
+	}
+
+	minute := time.Unix(0, totals.TimeMillis*int64(time.Millisecond)).UTC().Truncate(time.Minute)
+	if n := len(s.history); n == 0 || !s.history[n-1].Time.Equal(minute) {
+		s.history = append(s.history, Minute{Time: minute})
+		if len(s.history) > MaxHistory {
+			s.history = s.history[len(s.history)-MaxHistory:]
+		}
+	}
+	m := &s.history[len(s.history)-1]
+	m.BytesRecv += recv
+	m.BytesSent += sent
+	m.totalMillis += millis
+	m.Seconds = float64(m.totalMillis) / 1000
+	m.RecvPerSec = float64(m.BytesRecv) / m.Seconds
+	m.SentPerSec = float64(m.BytesSent) / m.Seconds
+}
+
+// History returns the traffic of the last `minutes` sampled minutes, oldest first
+func (s *Sampler) History(minutes int) []Minute {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if minutes <= 0 || minutes > len(s.history) {
+		minutes = len(s.history)
+	}
+	history := make([]Minute, minutes)
+	copy(history, s.history[len(s.history)-minutes:])
+	return history
+}
@@ -0,0 +1,113 @@
+package nettotals
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+)
+
+// A sample of backend, seconds after 12:00 UTC
+type sample struct {
+	backend    string
+	seconds    int64
+	recv, sent int64
+}
+
+// A client answering with the samples in order
+type fakeNode struct {
+	samples []sample
+}
+
+var noon = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func (f *fakeNode) BackendNetTotals(context.Context) (string, bitcoind.NetTotalsResponse, error) {
+	s := f.samples[0]
+	f.samples = f.samples[1:]
+	return s.backend, bitcoind.NetTotalsResponse{
+		TotalBytesRecv: s.recv,
+		TotalBytesSent: s.sent,
+		TimeMillis:     noon.Unix()*1000 + s.seconds*1000,
+	}, nil
+}
+
+func history(t *testing.T, samples ...sample) []Minute {
+	s := New(&fakeNode{samples: samples}, 0)
+	for range samples {
+		if err := s.Sample(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s.History(0)
+}
+
+func TestRestartStartsNewBaseline(t *testing.T) {
+	got := history(t,
+		sample{"node1", 0, 1000, 100},
+		sample{"node1", 10, 2000, 300},
+		sample{"node1", 20, 100, 10}, // restarted
+		sample{"node1", 30, 600, 110},
+	)
+	if len(got) != 1 {
+		t.Fatalf("%d minutes, want 1", len(got))
+	}
+	if m := got[0]; m.BytesRecv != 1500 || m.BytesSent != 300 || m.Seconds != 20 || m.RecvPerSec != 75 || m.SentPerSec != 15 {
+		t.Errorf("got %+v, want 1500 received and 300 sent in 20 seconds", m)
+	}
+}
+
+func TestBackendSwitchStartsNewBaseline(t *testing.T) {
+	got := history(t,
+		sample{"node1", 0, 1000, 100},
+		sample{"node1", 10, 2000, 200},
+		// node2 was up for longer, its counters are higher but can't be compared
+		sample{"node2", 20, 900000, 90000},
+		sample{"node2", 30, 901000, 90100},
+		// and back, the samples of node1 from before the switch are stale
+		sample{"node1", 40, 5000, 500},
+		sample{"node1", 50, 6000, 600},
+	)
+	if len(got) != 1 {
+		t.Fatalf("%d minutes, want 1", len(got))
+	}
+	if m := got[0]; m.BytesRecv != 3000 || m.BytesSent != 300 || m.Seconds != 30 {
+		t.Errorf("got %+v, want 3000 received and 300 sent in 30 seconds", m)
+	}
+}
+
+func TestMinuteRollover(t *testing.T) {
+	got := history(t,
+		sample{"node1", 40, 0, 0},
+		sample{"node1", 50, 1000, 100},
+		// traffic goes to the minute of the sample that ends the interval
+		sample{"node1", 60, 3000, 200},
+		sample{"node1", 70, 4000, 300},
+		// a gap of a minute without samples
+		sample{"node1", 190, 10000, 900},
+	)
+	want := []Minute{
+		{Time: noon, BytesRecv: 1000, BytesSent: 100, Seconds: 10, RecvPerSec: 100, SentPerSec: 10},
+		{Time: noon.Add(time.Minute), BytesRecv: 3000, BytesSent: 200, Seconds: 20, RecvPerSec: 150, SentPerSec: 10},
+		{Time: noon.Add(3 * time.Minute), BytesRecv: 6000, BytesSent: 600, Seconds: 120, RecvPerSec: 50, SentPerSec: 5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d minutes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		got[i].totalMillis = 0
+		if got[i] != want[i] {
+			t.Errorf("minute %d:\ngot  %+v\nwant %+v", i, got[i], want[i])
+		}
+	}
+}
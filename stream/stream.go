@@ -0,0 +1,331 @@
+package stream
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Event stream
+
+Pushes bus events to clients as JSON, either over a WebSocket or as
+Server-Sent Events (SSE). Topics are picked with ?topics=block,tip and
+WebSocket clients can change them later by sending
+{"action": "subscribe"|"unsubscribe", "topics": [...]}.
+
+Every message is {"topic": ..., "time": ..., "data": ...}. Besides the event
+topics there are "subscribed" (the current topics), "error" and "dropped".
+Events are never queued without bound: a connection that can't keep up loses
+events (reported with a "dropped" message holding the count) and a WebSocket
+that can't be written to for writeTimeout is closed.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+)
+
+const (
+	TopicBlock        = "block"         // a block connected to the best chain
+	TopicTip          = "tip"           // the best chain has a new tip
+	TopicMempoolTx    = "mempool_tx"    // a new transaction (needs ZMQ rawtx and sequence)
+	TopicMempoolStats = "mempool_stats" // mempool size and fees
+	TopicReorg        = "reorg"         // blocks of the best chain were replaced
+
+	DefaultHeartbeat        = 15 * time.Second
+	DefaultMaxConnections   = 100
+	DefaultMaxSubscriptions = 4
+
+	// Events buffered per connection before they get dropped
+	bufferSize   = 256
+	writeTimeout = 10 * time.Second
+	maxCommand   = 4096
+)
+
+var (
+	// Stream topic => bus topic
+	busTopics = map[string]string{
+		TopicBlock:        events.TopicBlock,
+		TopicTip:          events.TopicTip,
+		TopicMempoolTx:    events.TopicMempoolTx,
+		TopicMempoolStats: events.TopicMempoolStats,
+		TopicReorg:        events.TopicReorg,
+	}
+	// Bus topic => stream topic
+	streamTopics = map[string]string{}
+
+	upgrader = websocket.Upgrader{
+		// Same as the CORS setup of the rest of the API
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+func init() {
+	for topic, busTopic := range busTopics {
+		streamTopics[busTopic] = topic
+	}
+}
+
+type (
+	Options struct {
+		MaxConnections   int
+		MaxSubscriptions int // topics per connection
+		Heartbeat        time.Duration
+		// Optional, the last event of a bus topic is sent right after subscribing
+		Last func(topic string) (events.Event, bool)
+	}
+
+	Server struct {
+		bus         *events.Bus
+		opts        Options
+		connections int64
+	}
+
+	// Message sent to clients
+	Message struct {
+		Topic string      `json:"topic"`
+		Time  time.Time   `json:"time"`
+		Data  interface{} `json:"data"`
+	}
+
+	// Sent by WebSocket clients
+	command struct {
+		Action string   `json:"action"`
+		Topics []string `json:"topics"`
+	}
+
+	// A transport (SSE or WebSocket)
+	sender interface {
+		send(Message) error
+		heartbeat() error
+	}
+)
+
+func New(bus *events.Bus, opts Options) *Server {
+	if opts.MaxConnections <= 0 {
+		opts.MaxConnections = DefaultMaxConnections
+	}
+	if opts.MaxSubscriptions <= 0 {
+		opts.MaxSubscriptions = DefaultMaxSubscriptions
+	}
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = DefaultHeartbeat
+	}
+	return &Server{bus: bus, opts: opts}
+}
+
+// Connections returns the amount of open streams
+func (s *Server) Connections() int {
+	return int(atomic.LoadInt64(&s.connections))
+}
+
+// Handle serves GET /stream, as a WebSocket if the client asks for an upgrade and as SSE otherwise
+func (s *Server) Handle(c *gin.Context) {
+	if atomic.AddInt64(&s.connections, 1) > int64(s.opts.MaxConnections) {
+		atomic.AddInt64(&s.connections, -1)
+		c.JSON(503, gin.H{
+			"message": "Too many open streams, try again later",
+			"code":    "too_many_streams",
+		})
+		return
+	}
+	defer atomic.AddInt64(&s.connections, -1)
+
+	var topics []string
+	if c.Query("topics") != "" {
+		topics = strings.Split(c.Query("topics"), ",")
+	}
+	topics, err := s.validTopics(topics)
+	if err == nil && len(topics) == 0 && !websocket.IsWebSocketUpgrade(c.Request) {
+		err = fmt.Errorf("please specify ?topics= (%s)", strings.Join(allTopics(), ","))
+	}
+	if err != nil {
+		c.JSON(400, gin.H{
+			"message": err.Error(),
+			"code":    "invalid_parameter",
+		})
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		s.serveWebSocket(c, topics)
+		return
+	}
+	s.serveSSE(c, topics)
+}
+
+// validTopics removes duplicates and checks against the known topics and the subscription limit
+func (s *Server) validTopics(topics []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var valid []string
+	for _, topic := range topics {
+		topic = strings.TrimSpace(topic)
+		if topic == "" || seen[topic] {
+			continue
+		}
+		if _, ok := busTopics[topic]; !ok {
+			return nil, fmt.Errorf("unknown topic %q (%s)", topic, strings.Join(allTopics(), ","))
+		}
+		seen[topic] = true
+		valid = append(valid, topic)
+	}
+	if len(valid) > s.opts.MaxSubscriptions {
+		return nil, fmt.Errorf("too many topics (max %d per connection)", s.opts.MaxSubscriptions)
+	}
+	sort.Strings(valid)
+	return valid, nil
+}
+
+func allTopics() (topics []string) {
+	for topic := range busTopics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return
+}
+
+// subscribe returns nil for no topics (a nil channel never delivers)
+func (s *Server) subscribe(topics []string) *events.Subscription {
+	if len(topics) == 0 {
+		return nil
+	}
+	subscribed := make([]string, len(topics))
+	for i, topic := range topics {
+		subscribed[i] = busTopics[topic]
+	}
+	return s.bus.Subscribe(bufferSize, subscribed...)
+}
+
+// loop forwards events to the client until ctx is done, the client is gone or
+// a write fails. Commands (WebSocket only) change the topics on the fly.
+func (s *Server) loop(ctx context.Context, conn sender, topics []string, commands <-chan command) error {
+	sub := s.subscribe(topics)
+	defer func() {
+		if sub != nil {
+			sub.Unsubscribe()
+		}
+	}()
+	if err := s.sendSubscribed(conn, topics, topics); err != nil {
+		return err
+	}
+
+	heartbeat := time.NewTicker(s.opts.Heartbeat)
+	defer heartbeat.Stop()
+	var dropped uint64
+	for {
+		var eventC <-chan events.Event
+		if sub != nil {
+			eventC = sub.C
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if err := conn.heartbeat(); err != nil {
+				return err
+			}
+		case cmd, ok := <-commands:
+			if !ok {
+				return nil
+			}
+			newTopics, err := s.apply(topics, cmd)
+			if err != nil {
+				if err := conn.send(Message{Topic: "error", Time: time.Now(), Data: gin.H{"message": err.Error()}}); err != nil {
+					return err
+				}
+				continue
+			}
+			if sub != nil {
+				sub.Unsubscribe()
+			}
+			added := difference(newTopics, topics)
+			topics, sub, dropped = newTopics, s.subscribe(newTopics), 0
+			if err := s.sendSubscribed(conn, topics, added); err != nil {
+				return err
+			}
+		case event, ok := <-eventC:
+			if !ok {
+				return nil
+			}
+			err := conn.send(Message{Topic: streamTopics[event.Topic], Time: event.Time, Data: event.Data})
+			if err != nil {
+				return err
+			}
+			if n := sub.Dropped(); n > dropped {
+				err = conn.send(Message{Topic: "dropped", Time: time.Now(), Data: gin.H{"count": n - dropped}})
+				if err != nil {
+					return err
+				}
+				dropped = n
+			}
+		}
+	}
+}
+
+// sendSubscribed confirms the topics and sends the last known event of the new ones
+func (s *Server) sendSubscribed(conn sender, topics, added []string) error {
+	if topics == nil {
+		topics = []string{}
+	}
+	err := conn.send(Message{Topic: "subscribed", Time: time.Now(), Data: gin.H{"topics": topics}})
+	if err != nil || s.opts.Last == nil {
+		return err
+	}
+	for _, topic := range added {
+		event, ok := s.opts.Last(busTopics[topic])
+		if !ok {
+			continue
+		}
+		err = conn.send(Message{Topic: topic, Time: event.Time, Data: event.Data})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply returns the topics after a subscribe or unsubscribe command
+func (s *Server) apply(topics []string, cmd command) ([]string, error) {
+	switch cmd.Action {
+	case "subscribe":
+		return s.validTopics(append(append([]string{}, topics...), cmd.Topics...))
+	case "unsubscribe":
+		for _, topic := range cmd.Topics {
+			if _, ok := busTopics[strings.TrimSpace(topic)]; !ok {
+				return nil, fmt.Errorf("unknown topic %q (%s)", topic, strings.Join(allTopics(), ","))
+			}
+		}
+		return difference(topics, cmd.Topics), nil
+	default:
+		return nil, fmt.Errorf(`unknown action %q, send {"action": "subscribe" or "unsubscribe", "topics": [...]}`, cmd.Action)
+	}
+}
+
+// difference returns the topics in a that are not in b
+func difference(a, b []string) (diff []string) {
+	remove := make(map[string]bool, len(b))
+	for _, topic := range b {
+		remove[strings.TrimSpace(topic)] = true
+	}
+	for _, topic := range a {
+		if !remove[topic] {
+			diff = append(diff, topic)
+		}
+	}
+	return
+}
@@ -0,0 +1,132 @@
+package stream
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Transports
+
+SSE: every message is an `event: <topic>` with the JSON as `data:`, the
+heartbeat is a comment line. Reference:
+https://html.spec.whatwg.org/multipage/server-sent-events.html
+
+WebSocket: every message is a JSON text frame, the heartbeat is a ping. A
+client that doesn't answer pings within two heartbeats is disconnected.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type (
+	sseSender struct {
+		c *gin.Context
+	}
+
+	wsSender struct {
+		conn *websocket.Conn
+	}
+)
+
+func (s *Server) serveSSE(c *gin.Context, topics []string) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // don't let nginx buffer the stream
+	c.Status(200)
+	c.Writer.Flush()
+
+	_ = s.loop(c.Request.Context(), sseSender{c: c}, topics, nil)
+}
+
+func (s sseSender) send(m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.c.Writer, "event: %s\ndata: %s\n\n", m.Topic, data)
+	if err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+func (s sseSender) heartbeat() error {
+	_, err := fmt.Fprint(s.c.Writer, ": heartbeat\n\n")
+	if err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+func (s *Server) serveWebSocket(c *gin.Context, topics []string) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade already replied with an error
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	// Reader: commands and pongs. Closing the connection ends it.
+	commands := make(chan command)
+	go func() {
+		defer cancel()
+		conn.SetReadLimit(maxCommand)
+		deadline := 2 * s.opts.Heartbeat
+		_ = conn.SetReadDeadline(time.Now().Add(deadline))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(deadline))
+		})
+		for {
+			_, r, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			var cmd command
+			if err := json.NewDecoder(r).Decode(&cmd); err != nil {
+				// answered with an error message instead of hanging up
+				cmd = command{}
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(deadline))
+			select {
+			case commands <- cmd:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	err = s.loop(ctx, wsSender{conn: conn}, topics, commands)
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err != nil {
+		message = websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error())
+	}
+	_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
+
+func (w wsSender) send(m Message) error {
+	_ = w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return w.conn.WriteJSON(m)
+}
+
+func (w wsSender) heartbeat() error {
+	return w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
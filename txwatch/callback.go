@@ -0,0 +1,162 @@
+package txwatch
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Callbacks
+
+Every callback is a POST of a JSON Callback. The body is signed with
+HMAC-SHA256 using the configured secret, the hex digest is sent in the
+X-Signature-256 header as "sha256=<digest>". Receivers should check it against
+the raw body and use id + event + time to drop duplicates, as a callback is
+sent again when the response got lost. Any 2xx status counts as delivered.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Signature-256"
+	EventHeader     = "X-Watch-Event"
+
+	deliveryTimeout  = 10 * time.Second
+	maxRetryInterval = time.Hour
+)
+
+type (
+	// Body of a callback
+	Callback struct {
+		ID            string    `json:"id"` // of the watch
+		Event         string    `json:"event"`
+		Time          time.Time `json:"time"` // when the milestone was seen
+		TxID          string    `json:"txid"`
+		Status        string    `json:"status"` // of the watch when sending
+		Confirmations int64     `json:"confirmations"`
+		Target        int64     `json:"target_confirmations"`
+		BlockHash     string    `json:"block_hash,omitempty"`
+		BlockHeight   int64     `json:"block_height,omitempty"`
+		Attempt       int       `json:"attempt"`
+	}
+)
+
+// Sign returns the X-Signature-256 header value for a body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver sends the oldest due callback of every watch, each watch in its own goroutine
+func (w *Watcher) deliver(ctx context.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	for id, watch := range w.watches {
+		if len(watch.Outbox) == 0 {
+			if watch.Status == StatusCompleted || watch.Status == StatusExpired {
+				if err := w.remove(id); err != nil {
+					fmt.Printf("Transaction watches: %s\n", err)
+				}
+			}
+			continue
+		}
+		if w.delivering[id] || watch.Outbox[0].NextAttempt.After(now) {
+			continue
+		}
+		w.delivering[id] = true
+		go w.send(ctx, copyWatch(watch))
+	}
+}
+
+// send posts the first callback in the outbox of watch (a copy) and records the outcome
+func (w *Watcher) send(ctx context.Context, watch Watch) {
+	notification := watch.Outbox[0]
+	err := w.post(ctx, watch.URL, Callback{
+		ID:            watch.ID,
+		Event:         notification.Event,
+		Time:          notification.Time,
+		TxID:          watch.TxID,
+		Status:        watch.Status,
+		Confirmations: notification.Confirmations,
+		Target:        watch.Target,
+		BlockHash:     notification.BlockHash,
+		BlockHeight:   notification.BlockHeight,
+		Attempt:       notification.Attempts + 1,
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.delivering, watch.ID)
+	current, ok := w.watches[watch.ID]
+	if !ok || len(current.Outbox) == 0 || !current.Outbox[0].Time.Equal(notification.Time) || current.Outbox[0].Event != notification.Event {
+		// removed in the meantime
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	switch {
+	case err == nil:
+		current.Outbox = current.Outbox[1:]
+		current.LastError = ""
+	case notification.Attempts+1 >= w.opts.MaxAttempts:
+		current.Outbox = current.Outbox[1:]
+		current.LastError = fmt.Sprintf("%s callback dropped after %d attempts: %s", notification.Event, notification.Attempts+1, err)
+		fmt.Printf("Transaction watches: %s: %s\n", watch.ID, current.LastError)
+	default:
+		next := &current.Outbox[0]
+		next.Attempts++
+		retry := w.opts.RetryInterval << uint(next.Attempts-1)
+		if retry > maxRetryInterval || retry <= 0 {
+			retry = maxRetryInterval
+		}
+		next.NextAttempt = time.Now().Add(retry)
+		current.LastError = err.Error()
+	}
+	if err := w.save(current); err != nil {
+		fmt.Printf("Transaction watches: %s\n", err)
+	}
+}
+
+func (w *Watcher) post(ctx context.Context, url string, callback Callback) error {
+	body, err := json.Marshal(callback)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(w.opts.Secret, body))
+	req.Header.Set(EventHeader, callback.Event)
+	res, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("callback returned %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package txwatch
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '{"id":"w1"}' | openssl dgst -sha256 -hmac s3cret
+	want := "sha256=14d814e5bcf8ced66619242ea9c9bebff05c9fad80c53fde897b9348c58381e9"
+	if got := Sign("s3cret", []byte(`{"id":"w1"}`)); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// A receiver recording the callbacks it got, answering with status
+type receiver struct {
+	mu        sync.Mutex
+	status    int
+	callbacks []Callback
+	bad       []string // requests with a wrong signature or event header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	var callback Callback
+	json.Unmarshal(body, &callback)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.Header.Get(SignatureHeader) != Sign("secret", body) || req.Header.Get(EventHeader) != callback.Event {
+		r.bad = append(r.bad, string(body))
+	}
+	r.callbacks = append(r.callbacks, callback)
+	w.WriteHeader(r.status)
+}
+
+func openWatcher(t *testing.T, opts Options) (w *Watcher, cleanup func()) {
+	dir, err := ioutil.TempDir("", "txwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Secret = "secret"
+	opts.AllowedHosts = []string{"127.0.0.1"}
+	w, err = Open(filepath.Join(dir, "watches.db"), nil, nil, opts)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return w, func() {
+		w.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// addWatch stores a watch with one pending callback
+func addWatch(t *testing.T, w *Watcher, url string) *Watch {
+	watch := &Watch{ID: "w1", Owner: "alice", TxID: "aa", URL: url, Target: 1, Status: StatusMempool}
+	w.notify(watch, EventMempool)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watches[watch.ID] = watch
+	if err := w.save(watch); err != nil {
+		t.Fatal(err)
+	}
+	return watch
+}
+
+func TestRetryBackoff(t *testing.T) {
+	rcv := &receiver{status: 500}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	w, cleanup := openWatcher(t, Options{MaxAttempts: 4, RetryInterval: time.Minute})
+	defer cleanup()
+	watch := addWatch(t, w, server.URL)
+	ctx := context.Background()
+
+	// the interval doubles after every failed attempt
+	for attempt, wait := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		before := time.Now()
+		w.send(ctx, copyWatch(watch))
+		next := watch.Outbox[0]
+		if next.Attempts != attempt+1 {
+			t.Fatalf("attempt %d: %d attempts recorded", attempt+1, next.Attempts)
+		}
+		if next.NextAttempt.Before(before.Add(wait)) || next.NextAttempt.After(time.Now().Add(wait)) {
+			t.Errorf("attempt %d: next attempt in %s, want %s", attempt+1, next.NextAttempt.Sub(before), wait)
+		}
+		if !strings.Contains(watch.LastError, "500") {
+			t.Errorf("attempt %d: last error %q", attempt+1, watch.LastError)
+		}
+	}
+
+	// the last attempt drops the callback
+	w.send(ctx, copyWatch(watch))
+	if len(watch.Outbox) != 0 {
+		t.Fatalf("callback not dropped after %d attempts", 4)
+	}
+	if !strings.Contains(watch.LastError, "dropped after 4 attempts") {
+		t.Errorf("last error %q", watch.LastError)
+	}
+	if len(rcv.callbacks) != 4 || len(rcv.bad) != 0 {
+		t.Fatalf("%d callbacks received, %d badly signed", len(rcv.callbacks), len(rcv.bad))
+	}
+	for i, callback := range rcv.callbacks {
+		if callback.Attempt != i+1 || callback.ID != "w1" || callback.Event != EventMempool {
+			t.Errorf("callback %d: %+v", i+1, callback)
+		}
+	}
+}
+
+func TestRetryBackoffIsCapped(t *testing.T) {
+	server := httptest.NewServer(&receiver{status: 503})
+	defer server.Close()
+	w, cleanup := openWatcher(t, Options{MaxAttempts: 10, RetryInterval: 40 * time.Minute})
+	defer cleanup()
+	watch := addWatch(t, w, server.URL)
+
+	w.send(context.Background(), copyWatch(watch))
+	w.send(context.Background(), copyWatch(watch))
+	if wait := time.Until(watch.Outbox[0].NextAttempt); wait > maxRetryInterval {
+		t.Errorf("second retry in %s, want at most %s", wait, maxRetryInterval)
+	}
+}
+
+func TestDeliveredCallbackLeavesTheOutbox(t *testing.T) {
+	rcv := &receiver{status: 204}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	w, cleanup := openWatcher(t, Options{})
+	defer cleanup()
+	watch := addWatch(t, w, server.URL)
+
+	w.send(context.Background(), copyWatch(watch))
+	if len(watch.Outbox) != 0 || watch.LastError != "" {
+		t.Fatalf("outbox %+v, last error %q after a 204", watch.Outbox, watch.LastError)
+	}
+	if len(rcv.callbacks) != 1 || len(rcv.bad) != 0 {
+		t.Fatalf("%d callbacks received, %d badly signed", len(rcv.callbacks), len(rcv.bad))
+	}
+}
@@ -0,0 +1,172 @@
+package txwatch
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Callback hosts
+
+Callback URLs come from users, so without a check this server would send
+signed requests into its own network for anyone who can sign in. Callbacks to
+loopback, private, link-local and other non-public addresses are refused when a
+watch is added, and again when connecting, as a name can resolve differently by
+then (and redirects are followed). Hosts in AllowedHosts skip the check.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// Addresses callbacks can't go to unless allowed
+	nonPublicNets = mustParseCIDRs(
+		"0.0.0.0/8",      // this network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier-grade NAT
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local (and cloud metadata services)
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // IETF protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"224.0.0.0/4",    // multicast
+		"240.0.0.0/4",    // reserved, broadcast
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+		"ff00::/8",       // multicast
+	)
+)
+
+type (
+	// Which callback hosts are allowed besides public addresses
+	hostPolicy struct {
+		names map[string]bool
+		nets  []*net.IPNet
+	}
+)
+
+// newHostPolicy parses allowed hosts: names, IPs or CIDRs
+func newHostPolicy(allowed []string) (p hostPolicy, err error) {
+	p.names = make(map[string]bool)
+	for _, host := range allowed {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if _, network, err := net.ParseCIDR(host); err == nil {
+			p.nets = append(p.nets, network)
+		} else if ip := net.ParseIP(host); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else if host != "" {
+			p.names[host] = true
+		} else {
+			return p, fmt.Errorf("empty callback host in allowed hosts")
+		}
+	}
+	return p, nil
+}
+
+func (p hostPolicy) allowedIP(ip net.IP) bool {
+	for _, network := range p.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, network := range nonPublicNets {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkURL fails with ErrForbiddenHost if the host of rawURL is (or resolves to) a non-public address
+func (p hostPolicy) checkURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+	if p.names[host] {
+		return nil
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrUnknownHost, err)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !p.allowedIP(ip) {
+			return fmt.Errorf("%w: %s (%s)", ErrForbiddenHost, host, ip)
+		}
+	}
+	return nil
+}
+
+// httpClient checks the address of every connection it makes, without a proxy
+// (a proxy would be the only address checked)
+func (p hostPolicy) httpClient() *http.Client {
+	dialer := &net.Dialer{Timeout: deliveryTimeout}
+	checked := *dialer
+	checked.Control = func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !p.allowedIP(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenHost, host)
+		}
+		return nil
+	}
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				host, _, err := net.SplitHostPort(address)
+				if err == nil && p.names[strings.ToLower(host)] {
+					return dialer.DialContext(ctx, network, address)
+				}
+				return checked.DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout: deliveryTimeout,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConns:        100,
+		},
+	}
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = network
+	}
+	return nets
+}
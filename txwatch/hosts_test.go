@@ -0,0 +1,90 @@
+package txwatch
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+	policy, err := newHostPolicy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, callback := range []string{
+		"http://127.0.0.1/",
+		"http://127.1.2.3:8080/x",
+		"http://[::1]/",
+		"http://[::ffff:127.0.0.1]/",
+		"http://10.0.0.1/",
+		"http://172.16.5.4/",
+		"http://192.168.1.1/",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.64.0.1/",
+		"http://[fd00::1]/",
+		"http://[fe80::1]/",
+		"http://0.0.0.0/",
+	} {
+		if err := policy.checkURL(ctx, callback); !errors.Is(err, ErrForbiddenHost) {
+			t.Errorf("%s: got %v, want ErrForbiddenHost", callback, err)
+		}
+	}
+	for _, callback := range []string{
+		"http://1.1.1.1/",
+		"https://[2606:4700:4700::1111]/hook",
+	} {
+		if err := policy.checkURL(ctx, callback); err != nil {
+			t.Errorf("%s: %v", callback, err)
+		}
+	}
+
+	allowing, err := newHostPolicy([]string{"10.1.0.0/16", "127.0.0.1", "Internal.Example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, callback := range []string{
+		"http://10.1.2.3/",
+		"http://127.0.0.1:18090/hook",
+		"http://internal.example/hook", // not resolved when allowed by name
+	} {
+		if err := allowing.checkURL(ctx, callback); err != nil {
+			t.Errorf("allowed %s: %v", callback, err)
+		}
+	}
+	if err := allowing.checkURL(ctx, "http://10.2.0.1/"); !errors.Is(err, ErrForbiddenHost) {
+		t.Errorf("10.2.0.1 outside the allowed CIDR: got %v", err)
+	}
+}
+
+// The address is checked again when connecting, a name could resolve
+// differently by then and redirects are followed
+func TestCallbackClientChecksConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	policy, _ := newHostPolicy(nil)
+	_, err := policy.httpClient().Get(server.URL)
+	if !errors.Is(err, ErrForbiddenHost) {
+		t.Fatalf("connecting to %s: got %v, want ErrForbiddenHost", server.URL, err)
+	}
+
+	allowing, _ := newHostPolicy([]string{"127.0.0.1"})
+	res, err := allowing.httpClient().Get(server.URL)
+	if err != nil {
+		t.Fatalf("connecting to allowed %s: %v", server.URL, err)
+	}
+	res.Body.Close()
+}
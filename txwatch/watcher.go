@@ -0,0 +1,562 @@
+package txwatch
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+Transaction watches
+
+Follows registered transactions until they reach a target number of
+confirmations and calls back a URL at every milestone: seen in the mempool,
+confirmed, reorged out of the best chain, completed (target reached) or
+expired (never confirmed within ExpireAfter). Watches are checked on every new
+tip and block, and every DefaultCheckInterval to notice mempool transactions.
+
+Transactions are looked up with getrawtransaction, which only finds mempool
+transactions unless bitcoind runs with -txindex. Without it confirmations are
+found by looking through the blocks the chain watcher reports, so blocks mined
+while this server was down are missed.
+
+Watches, and the callbacks that still have to be delivered, are kept in a bbolt
+database so they survive restarts. Callbacks are retried with a doubling
+interval and delivered at least once and in order per watch.
+
+Every watch belongs to the user that added it, other users can't see or remove
+it. Callbacks only go to public addresses unless a host is in AllowedHosts.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"gitlab.com/nolim1t/golang-httpd-test/bitcoind"
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+)
+
+const (
+	StatusPending   = "pending"   // not seen yet (or dropped from the mempool)
+	StatusMempool   = "mempool"   // unconfirmed
+	StatusConfirmed = "confirmed" // in the best chain, below the target
+	StatusCompleted = "completed" // reached the target, removed once the callbacks are delivered
+	StatusExpired   = "expired"   // not confirmed in time, removed once the callbacks are delivered
+
+	EventMempool   = "mempool"
+	EventConfirmed = "confirmed"
+	EventReorged   = "reorged"
+	EventCompleted = "completed"
+	EventExpired   = "expired"
+
+	DefaultCheckInterval = 30 * time.Second
+	DefaultRetryInterval = 10 * time.Second
+	DefaultMaxAttempts   = 8
+	DefaultExpireAfter   = 72 * time.Hour
+	DefaultMaxWatches    = 10000
+	DefaultMaxPerOwner   = 100
+
+	// Highest target confirmations
+	MaxTarget = 100
+)
+
+var (
+	ErrTooManyWatches = errors.New("too many watches")
+	ErrOwnerLimit     = errors.New("too many watches of this owner")
+	ErrNotFound       = errors.New("watch not found")
+	ErrForbiddenHost  = errors.New("callback host is not a public address")
+	ErrUnknownHost    = errors.New("callback host can't be resolved")
+
+	bucketWatches = []byte("watches")
+)
+
+type (
+	// The calls the watcher needs (implemented by bitcoind.Pool)
+	Client interface {
+		GetTransactions(ctx context.Context, txids []string) (map[string]bitcoind.VerboseTransactionInfo, error)
+		GetBlock(ctx context.Context, hash string) (bitcoind.BitcoinBlockResponse, error)
+		GetBlockHeader(ctx context.Context, hash string) (bitcoind.BlockHeaderResponse, error)
+	}
+
+	Options struct {
+		// Key for the HMAC-SHA256 signature of callbacks
+		Secret string
+		// Delivery attempts per callback, the first retry is after RetryInterval
+		MaxAttempts   int
+		RetryInterval time.Duration
+		// How long a transaction may stay unconfirmed
+		ExpireAfter time.Duration
+		MaxWatches  int
+		// Watches a single owner may have
+		MaxPerOwner int
+		// Callback hosts (names, IPs or CIDRs) that may be private or loopback addresses
+		AllowedHosts []string
+	}
+
+	Watcher struct {
+		db         *bolt.DB
+		client     Client
+		bus        *events.Bus
+		opts       Options
+		httpClient *http.Client
+		hosts      hostPolicy
+		checkNow   chan struct{}
+
+		mu         sync.Mutex
+		watches    map[string]*Watch
+		delivering map[string]bool
+	}
+
+	Watch struct {
+		ID            string    `json:"id"`
+		Owner         string    `json:"owner"` // user that added the watch
+		TxID          string    `json:"txid"`
+		URL           string    `json:"url"`
+		Target        int64     `json:"target_confirmations"`
+		Created       time.Time `json:"created"`
+		Status        string    `json:"status"`
+		Confirmations int64     `json:"confirmations"`
+		BlockHash     string    `json:"block_hash,omitempty"`
+		BlockHeight   int64     `json:"block_height,omitempty"`
+		// Callbacks that still have to be delivered, oldest first
+		Outbox []Notification `json:"outbox"`
+		// Last failed delivery
+		LastError string `json:"last_error,omitempty"`
+	}
+
+	// A milestone of a watch, with the state of the transaction at that moment
+	Notification struct {
+		Event         string    `json:"event"`
+		Time          time.Time `json:"time"`
+		Confirmations int64     `json:"confirmations"`
+		BlockHash     string    `json:"block_hash,omitempty"` // for reorged: the disconnected block (and its confirmations)
+		BlockHeight   int64     `json:"block_height,omitempty"`
+		Attempts      int       `json:"attempts"`
+		NextAttempt   time.Time `json:"next_attempt"`
+	}
+
+	// What is known about a transaction in one check
+	observation struct {
+		found         bool // in the mempool or the best chain
+		confirmations int64
+		blockHash     string
+		blockHeight   int64
+	}
+)
+
+// Open loads the watches from the database at path (created if needed)
+func Open(path string, client Client, bus *events.Bus, opts Options) (*Watcher, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultRetryInterval
+	}
+	if opts.ExpireAfter <= 0 {
+		opts.ExpireAfter = DefaultExpireAfter
+	}
+	if opts.MaxWatches <= 0 {
+		opts.MaxWatches = DefaultMaxWatches
+	}
+	if opts.MaxPerOwner <= 0 {
+		opts.MaxPerOwner = DefaultMaxPerOwner
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	hosts, err := newHostPolicy(opts.AllowedHosts)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can't open watches %s: %w", path, err)
+	}
+	w := &Watcher{
+		db:         db,
+		client:     client,
+		bus:        bus,
+		opts:       opts,
+		httpClient: hosts.httpClient(),
+		hosts:      hosts,
+		checkNow:   make(chan struct{}, 1),
+		watches:    make(map[string]*Watch),
+		delivering: make(map[string]bool),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketWatches)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(id, value []byte) error {
+			var watch Watch
+			if err := json.Unmarshal(value, &watch); err != nil {
+				return fmt.Errorf("watch %s: %w", id, err)
+			}
+			w.watches[watch.ID] = &watch
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Watcher) Close() error {
+	return w.db.Close()
+}
+
+// Run checks the watches and delivers callbacks until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	var chain <-chan events.Event
+	if w.bus != nil {
+		sub := w.bus.Subscribe(events.DefaultBufferSize, events.TopicBlock, events.TopicTip)
+		defer sub.Unsubscribe()
+		chain = sub.C
+	}
+	checkTicker := time.NewTicker(DefaultCheckInterval)
+	defer checkTicker.Stop()
+	deliveryTicker := time.NewTicker(time.Second)
+	defer deliveryTicker.Stop()
+
+	w.check(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-chain:
+			if block, ok := event.Data.(events.Block); ok {
+				w.checkBlock(ctx, block.Hash)
+			} else {
+				w.check(ctx)
+			}
+		case <-checkTicker.C:
+			w.check(ctx)
+		case <-w.checkNow:
+			w.check(ctx)
+		case <-deliveryTicker.C:
+			w.deliver(ctx)
+		}
+	}
+}
+
+// Add registers a new watch of owner, the transaction is looked up right away.
+// Fails with ErrForbiddenHost if the callback goes to a private address, and
+// with ErrOwnerLimit if owner has MaxPerOwner watches already.
+func (w *Watcher) Add(ctx context.Context, owner, txid, callbackURL string, target int64) (Watch, error) {
+	if err := w.hosts.checkURL(ctx, callbackURL); err != nil {
+		return Watch{}, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Watch{}, err
+	}
+	watch := &Watch{
+		ID:      hex.EncodeToString(id),
+		Owner:   owner,
+		TxID:    txid,
+		URL:     callbackURL,
+		Target:  target,
+		Created: time.Now().UTC(),
+		Status:  StatusPending,
+		Outbox:  []Notification{},
+	}
+
+	w.mu.Lock()
+	if len(w.watches) >= w.opts.MaxWatches {
+		w.mu.Unlock()
+		return Watch{}, ErrTooManyWatches
+	}
+	if w.ownerWatches(owner) >= w.opts.MaxPerOwner {
+		w.mu.Unlock()
+		return Watch{}, ErrOwnerLimit
+	}
+	if err := w.save(watch); err != nil {
+		w.mu.Unlock()
+		return Watch{}, err
+	}
+	w.watches[watch.ID] = watch
+	w.mu.Unlock()
+
+	select {
+	case w.checkNow <- struct{}{}:
+	default:
+	}
+	return *watch, nil
+}
+
+// Get returns a watch of owner by ID
+func (w *Watcher) Get(owner, id string) (Watch, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	watch, ok := w.watches[id]
+	if !ok || watch.Owner != owner {
+		return Watch{}, ErrNotFound
+	}
+	return copyWatch(watch), nil
+}
+
+// List returns all watches of owner
+func (w *Watcher) List(owner string) []Watch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	watches := []Watch{}
+	for _, watch := range w.watches {
+		if watch.Owner == owner {
+			watches = append(watches, copyWatch(watch))
+		}
+	}
+	return watches
+}
+
+// ownerWatches counts the watches of owner (w.mu must be held)
+func (w *Watcher) ownerWatches(owner string) (n int) {
+	for _, watch := range w.watches {
+		if watch.Owner == owner {
+			n++
+		}
+	}
+	return
+}
+
+// Remove stops a watch of owner, callbacks that weren't delivered yet are dropped
+func (w *Watcher) Remove(owner, id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if watch, ok := w.watches[id]; !ok || watch.Owner != owner {
+		return ErrNotFound
+	}
+	return w.remove(id)
+}
+
+// check looks up every active watch: confirmed ones by their block, the others by txid
+func (w *Watcher) check(ctx context.Context) {
+	w.mu.Lock()
+	var active []Watch
+	for _, watch := range w.watches {
+		if watch.Status != StatusCompleted && watch.Status != StatusExpired {
+			active = append(active, copyWatch(watch))
+		}
+	}
+	w.mu.Unlock()
+	if len(active) == 0 {
+		return
+	}
+
+	headers := map[string]bitcoind.BlockHeaderResponse{}
+	header := func(hash string) (bitcoind.BlockHeaderResponse, error) {
+		if h, ok := headers[hash]; ok {
+			return h, nil
+		}
+		h, err := w.client.GetBlockHeader(ctx, hash)
+		var rpcErr *bitcoind.RPCError
+		if errors.As(err, &rpcErr) && rpcErr.Code == bitcoind.RPCInvalidAddressOrKey {
+			// unknown block, same as not in the best chain
+			h, err = bitcoind.BlockHeaderResponse{Hash: hash, Confirmations: -1}, nil
+		}
+		if err == nil {
+			headers[hash] = h
+		}
+		return h, err
+	}
+
+	observations := make(map[string]observation, len(active))
+	var lookup []string
+	for _, watch := range active {
+		if watch.BlockHash != "" {
+			h, err := header(watch.BlockHash)
+			if err != nil {
+				fmt.Printf("Transaction watches: %s\n", err)
+				return
+			}
+			if h.Confirmations > 0 {
+				observations[watch.ID] = observation{true, h.Confirmations, h.Hash, h.Height}
+				continue
+			}
+		}
+		lookup = append(lookup, watch.TxID)
+	}
+
+	txs := map[string]bitcoind.VerboseTransactionInfo{}
+	for start := 0; start < len(lookup); start += bitcoind.MaxTransactions {
+		end := start + bitcoind.MaxTransactions
+		if end > len(lookup) {
+			end = len(lookup)
+		}
+		found, err := w.client.GetTransactions(ctx, lookup[start:end])
+		if err != nil {
+			fmt.Printf("Transaction watches: %s\n", err)
+			return
+		}
+		for txid, tx := range found {
+			txs[txid] = tx
+		}
+	}
+	for _, watch := range active {
+		if _, ok := observations[watch.ID]; ok {
+			continue
+		}
+		tx, found := txs[watch.TxID]
+		switch {
+		case !found:
+			observations[watch.ID] = observation{}
+		case tx.Blockhash == "":
+			observations[watch.ID] = observation{found: true}
+		default:
+			h, err := header(tx.Blockhash)
+			if err != nil {
+				fmt.Printf("Transaction watches: %s\n", err)
+				return
+			}
+			observations[watch.ID] = observation{h.Confirmations > 0, h.Confirmations, h.Hash, h.Height}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, seen := range observations {
+		if watch, ok := w.watches[id]; ok {
+			w.update(watch, seen)
+		}
+	}
+}
+
+// checkBlock looks for unconfirmed watches in a new block, needed to see confirmations without -txindex
+func (w *Watcher) checkBlock(ctx context.Context, hash string) {
+	w.mu.Lock()
+	waiting := map[string][]string{} // txid => watch IDs
+	for _, watch := range w.watches {
+		if watch.Status == StatusPending || watch.Status == StatusMempool {
+			waiting[watch.TxID] = append(waiting[watch.TxID], watch.ID)
+		}
+	}
+	w.mu.Unlock()
+	if len(waiting) == 0 {
+		return
+	}
+	block, err := w.client.GetBlock(ctx, hash)
+	if err != nil {
+		fmt.Printf("Transaction watches: %s\n", err)
+		return
+	}
+	if block.Confirmations < 1 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, txid := range block.Transactions {
+		for _, id := range waiting[txid] {
+			if watch, ok := w.watches[id]; ok {
+				w.update(watch, observation{true, block.Confirmations, block.Hash, block.Height})
+			}
+		}
+	}
+}
+
+// update moves a watch to the state of an observation and queues callbacks for
+// the milestones passed. Called with w.mu held.
+func (w *Watcher) update(watch *Watch, seen observation) {
+	if watch.Status == StatusCompleted || watch.Status == StatusExpired {
+		return
+	}
+	before := copyWatch(watch)
+	switch {
+	case seen.confirmations > 0:
+		if watch.Status == StatusConfirmed && watch.BlockHash != seen.blockHash {
+			// confirmed again, in a block that replaced the old one
+			w.notify(watch, EventReorged)
+		}
+		if watch.Status != StatusConfirmed || watch.BlockHash != seen.blockHash {
+			watch.Status = StatusConfirmed
+			watch.BlockHash, watch.BlockHeight = seen.blockHash, seen.blockHeight
+			watch.Confirmations = seen.confirmations
+			w.notify(watch, EventConfirmed)
+		}
+		watch.Confirmations = seen.confirmations
+		if watch.Confirmations >= watch.Target {
+			watch.Status = StatusCompleted
+			w.notify(watch, EventCompleted)
+		}
+	case watch.Status == StatusConfirmed:
+		// the block is no longer part of the best chain
+		w.notify(watch, EventReorged)
+		watch.Status = StatusPending
+		if seen.found {
+			watch.Status = StatusMempool
+		}
+		watch.Confirmations, watch.BlockHash, watch.BlockHeight = 0, "", 0
+	case seen.found:
+		if watch.Status == StatusPending {
+			watch.Status = StatusMempool
+			w.notify(watch, EventMempool)
+		}
+	case watch.Status == StatusMempool:
+		// evicted or replaced, or mined without -txindex (then checkBlock finds it)
+	}
+	if (watch.Status == StatusPending || watch.Status == StatusMempool) && time.Since(watch.Created) > w.opts.ExpireAfter {
+		watch.Status = StatusExpired
+		w.notify(watch, EventExpired)
+	}
+
+	if watch.Status == before.Status && watch.Confirmations == before.Confirmations && len(watch.Outbox) == len(before.Outbox) {
+		return
+	}
+	if err := w.save(watch); err != nil {
+		fmt.Printf("Transaction watches: %s\n", err)
+	}
+}
+
+// notify queues a callback with the current state of the watch
+func (w *Watcher) notify(watch *Watch, event string) {
+	watch.Outbox = append(watch.Outbox, Notification{
+		Event:         event,
+		Time:          time.Now().UTC(),
+		Confirmations: watch.Confirmations,
+		BlockHash:     watch.BlockHash,
+		BlockHeight:   watch.BlockHeight,
+		NextAttempt:   time.Now(),
+	})
+}
+
+// save writes a watch to the database. Called with w.mu held.
+func (w *Watcher) save(watch *Watch) error {
+	value, err := json.Marshal(watch)
+	if err != nil {
+		return err
+	}
+	return w.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWatches).Put([]byte(watch.ID), value)
+	})
+}
+
+// remove deletes a watch. Called with w.mu held.
+func (w *Watcher) remove(id string) error {
+	delete(w.watches, id)
+	return w.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWatches).Delete([]byte(id))
+	})
+}
+
+func copyWatch(watch *Watch) Watch {
+	c := *watch
+	c.Outbox = append([]Notification{}, watch.Outbox...)
+	return c
+}
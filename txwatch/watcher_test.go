@@ -0,0 +1,53 @@
+package txwatch
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOwnerLimit(t *testing.T) {
+	w, cleanup := openWatcher(t, Options{MaxWatches: 3, MaxPerOwner: 2})
+	defer cleanup()
+	ctx := context.Background()
+	txid := strings.Repeat("aa", 32)
+	add := func(owner string) error {
+		_, err := w.Add(ctx, owner, txid, "http://127.0.0.1:8080/callback", 1)
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := add("alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := add("alice"); !errors.Is(err, ErrOwnerLimit) {
+		t.Fatalf("alice's third watch: got %v, want ErrOwnerLimit", err)
+	}
+	// other owners aren't affected, until the global limit
+	if err := add("bob"); err != nil {
+		t.Fatalf("bob's first watch: %v", err)
+	}
+	if err := add("bob"); !errors.Is(err, ErrTooManyWatches) {
+		t.Fatalf("fourth watch: got %v, want ErrTooManyWatches", err)
+	}
+
+	// a removed watch frees a slot
+	if err := w.Remove("alice", w.List("alice")[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := add("alice"); err != nil {
+		t.Fatalf("after removing a watch: %v", err)
+	}
+}
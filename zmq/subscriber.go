@@ -0,0 +1,371 @@
+package zmq
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+/*
+ZMQ notifications
+
+Subscribes to the endpoints bitcoind publishes on with its -zmqpub* options and
+turns every notification into a typed event on the bus. Topics sharing an
+endpoint share a connection. Every notification carries a sequence number per
+topic, a jump in it means notifications were lost (or bitcoind restarted) and
+is published as a Gap event.
+
+bitcoind publishes a rawtx both when a transaction enters the mempool and for
+every transaction of a connected block. Only the first comes with a tx_added
+sequence notification, so with both rawtx and sequence set up a rawtx is also
+published as a mempool_tx once its tx_added (which can arrive before or after
+it) is seen. Unmatched notifications are forgotten after one to two pairWindows.
+
+Reference: https://github.com/bitcoin/bitcoin/blob/master/doc/zmq.md
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/gozmq"
+
+	"gitlab.com/nolim1t/golang-httpd-test/common"
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+)
+
+const (
+	// ZMQ topics as published by bitcoind
+	TopicHashBlock = "hashblock"
+	TopicRawBlock  = "rawblock"
+	TopicRawTx     = "rawtx"
+	TopicSequence  = "sequence"
+
+	// Wait between frames of a single message
+	readTimeout = 10 * time.Second
+	// Wait before connecting again after a failure
+	retryInterval = 10 * time.Second
+	// Wait for the tx_added of a rawtx (or the other way around)
+	pairWindow = 30 * time.Second
+)
+
+type (
+	Subscriber struct {
+		source    string
+		endpoints map[string][]string // endpoint => topics
+		bus       *events.Bus
+
+		mu        sync.Mutex
+		sequences map[string]uint32 // "endpoint topic" => last sequence number
+
+		pairs *mempoolPairs // nil unless both rawtx and sequence are set up
+	}
+
+	// rawtx and tx_added notifications by txid, waiting for each other.
+	// Index 0 is the current window, 1 the previous one.
+	mempoolPairs struct {
+		mu      sync.Mutex
+		rawTxs  [2]map[string]events.RawTx
+		added   [2]map[string]bool
+		rotated time.Time
+	}
+)
+
+// New sets up a subscriber for the zmqpub* endpoints of a [bitcoind] section.
+// Events are published with source as their Source.
+func New(source string, conf common.Bitcoind, bus *events.Bus) *Subscriber {
+	s := &Subscriber{
+		source:    source,
+		endpoints: make(map[string][]string),
+		bus:       bus,
+		sequences: make(map[string]uint32),
+	}
+	for topic, endpoint := range map[string]string{
+		TopicHashBlock: conf.ZMQPubHashBlock,
+		TopicRawBlock:  conf.ZMQPubRawBlock,
+		TopicRawTx:     conf.ZMQPubRawTx,
+		TopicSequence:  conf.ZMQPubSequence,
+	} {
+		if endpoint != "" {
+			s.endpoints[endpoint] = append(s.endpoints[endpoint], topic)
+		}
+	}
+	if conf.ZMQPubRawTx != "" && conf.ZMQPubSequence != "" {
+		s.pairs = newMempoolPairs()
+	}
+	return s
+}
+
+// Enabled is false if no zmqpub* endpoint is configured
+func (s *Subscriber) Enabled() bool {
+	return len(s.endpoints) > 0
+}
+
+// Run keeps a connection to every endpoint until ctx is done
+func (s *Subscriber) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for endpoint, topics := range s.endpoints {
+		wg.Add(1)
+		go func(endpoint string, topics []string) {
+			defer wg.Done()
+			for {
+				err := s.subscribe(ctx, endpoint, topics)
+				if ctx.Err() != nil {
+					return
+				}
+				fmt.Printf("ZMQ %s (%s): %s, retrying in %s\n", endpoint, s.source, err, retryInterval)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryInterval):
+				}
+			}
+		}(endpoint, topics)
+	}
+	wg.Wait()
+}
+
+// subscribe receives from a single endpoint until an error that isn't a timeout.
+// gozmq reconnects by itself on a dropped connection.
+func (s *Subscriber) subscribe(ctx context.Context, endpoint string, topics []string) error {
+	conn, err := gozmq.Subscribe(endpoint, topics, readTimeout)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+			conn.Close()
+		}
+	}()
+	fmt.Printf("ZMQ subscribed to %v on %s (%s)\n", topics, endpoint, s.source)
+
+	for {
+		msg, err := conn.Receive(nil)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+				continue
+			}
+			if errors.Is(err, io.EOF) && ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		err = s.handle(endpoint, msg)
+		if err != nil {
+			fmt.Printf("ZMQ %s (%s): %s\n", endpoint, s.source, err)
+		}
+	}
+}
+
+// handle decodes a [topic, body, sequence] message and publishes it
+func (s *Subscriber) handle(endpoint string, msg [][]byte) error {
+	if len(msg) != 3 || len(msg[2]) != 4 {
+		return fmt.Errorf("unexpected message with %d parts", len(msg))
+	}
+	topic, body := string(msg[0]), msg[1]
+	s.checkSequence(endpoint, topic, binary.LittleEndian.Uint32(msg[2]))
+
+	switch topic {
+	case TopicHashBlock:
+		if len(body) != 32 {
+			return fmt.Errorf("invalid %s body of %d bytes", topic, len(body))
+		}
+		s.bus.Publish(events.TopicHashBlock, s.source, events.BlockHash{Hash: hex.EncodeToString(body)})
+	case TopicRawBlock:
+		block, err := decodeBlock(body)
+		if err != nil {
+			return err
+		}
+		s.bus.Publish(events.TopicRawBlock, s.source, block)
+	case TopicRawTx:
+		tx, err := decodeTx(body)
+		if err != nil {
+			return err
+		}
+		s.bus.Publish(events.TopicRawTx, s.source, tx)
+		if s.pairs != nil && s.pairs.rawTx(tx) {
+			s.bus.Publish(events.TopicMempoolTx, s.source, tx)
+		}
+	case TopicSequence:
+		seq, err := decodeSequence(body)
+		if err != nil {
+			return err
+		}
+		s.bus.Publish(events.TopicSequence, s.source, seq)
+		if s.pairs == nil {
+			break
+		}
+		switch seq.Label {
+		case events.SequenceTxAdded:
+			if tx, ok := s.pairs.txAdded(seq.Hash); ok {
+				s.bus.Publish(events.TopicMempoolTx, s.source, tx)
+			}
+		case events.SequenceTxRemoved:
+			s.pairs.txRemoved(seq.Hash)
+		}
+	default:
+		return fmt.Errorf("unknown topic %q", topic)
+	}
+	return nil
+}
+
+// checkSequence publishes a Gap when a sequence number was skipped
+func (s *Subscriber) checkSequence(endpoint, topic string, sequence uint32) {
+	key := endpoint + " " + topic
+	s.mu.Lock()
+	last, seen := s.sequences[key]
+	s.sequences[key] = sequence
+	s.mu.Unlock()
+
+	if !seen || sequence == last+1 {
+		return
+	}
+	gap := events.Gap{
+		Endpoint: endpoint,
+		ZMQTopic: topic,
+		Expected: last + 1,
+		Received: sequence,
+	}
+	fmt.Printf("ZMQ %s (%s): %s expected sequence %d, got %d\n", endpoint, s.source, topic, gap.Expected, gap.Received)
+	s.bus.Publish(events.TopicZMQGap, s.source, gap)
+}
+
+func newMempoolPairs() *mempoolPairs {
+	p := &mempoolPairs{rotated: time.Now()}
+	for i := range p.rawTxs {
+		p.rawTxs[i] = make(map[string]events.RawTx)
+		p.added[i] = make(map[string]bool)
+	}
+	return p
+}
+
+// rotate starts a new window once the current one is over, dropping the previous one.
+// Must be called with p.mu held.
+func (p *mempoolPairs) rotate() {
+	if time.Since(p.rotated) < pairWindow {
+		return
+	}
+	p.rawTxs = [2]map[string]events.RawTx{make(map[string]events.RawTx), p.rawTxs[0]}
+	p.added = [2]map[string]bool{make(map[string]bool), p.added[0]}
+	p.rotated = time.Now()
+}
+
+// forget removes a transaction from both windows, with p.mu held
+func (p *mempoolPairs) forget(txid string) {
+	for i := range p.rawTxs {
+		delete(p.rawTxs[i], txid)
+		delete(p.added[i], txid)
+	}
+}
+
+// rawTx returns true if the transaction was added to the mempool, otherwise it waits for that
+func (p *mempoolPairs) rawTx(tx events.RawTx) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rotate()
+	if p.added[0][tx.TxID] || p.added[1][tx.TxID] {
+		p.forget(tx.TxID)
+		return true
+	}
+	p.rawTxs[0][tx.TxID] = tx
+	return false
+}
+
+// txAdded returns the rawtx of a transaction added to the mempool if it was
+// received already, otherwise it waits for it
+func (p *mempoolPairs) txAdded(txid string) (events.RawTx, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rotate()
+	for _, rawTxs := range p.rawTxs {
+		if tx, ok := rawTxs[txid]; ok {
+			p.forget(txid)
+			return tx, true
+		}
+	}
+	p.added[0][txid] = true
+	return events.RawTx{}, false
+}
+
+// txRemoved forgets a transaction that left the mempool (not by being mined)
+func (p *mempoolPairs) txRemoved(txid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forget(txid)
+}
+
+func decodeBlock(body []byte) (block events.RawBlock, err error) {
+	var msgBlock wire.MsgBlock
+	err = msgBlock.Deserialize(bytes.NewReader(body))
+	if err != nil {
+		return block, fmt.Errorf("can't decode rawblock: %w", err)
+	}
+	return events.RawBlock{
+		Hash:         msgBlock.BlockHash().String(),
+		PreviousHash: msgBlock.Header.PrevBlock.String(),
+		Time:         msgBlock.Header.Timestamp.Unix(),
+		Size:         len(body),
+		TxCount:      len(msgBlock.Transactions),
+		Raw:          body,
+	}, nil
+}
+
+func decodeTx(body []byte) (tx events.RawTx, err error) {
+	var msgTx wire.MsgTx
+	err = msgTx.Deserialize(bytes.NewReader(body))
+	if err != nil {
+		return tx, fmt.Errorf("can't decode rawtx: %w", err)
+	}
+	// weight = stripped size * 3 + total size, vsize rounds up
+	weight := msgTx.SerializeSizeStripped()*3 + len(body)
+	return events.RawTx{
+		TxID:  msgTx.TxHash().String(),
+		WTxID: msgTx.WitnessHash().String(),
+		Size:  len(body),
+		VSize: (weight + 3) / 4,
+		Raw:   body,
+	}, nil
+}
+
+// decodeSequence decodes <32 byte hash><label>[<8 byte mempool sequence>]
+func decodeSequence(body []byte) (seq events.Sequence, err error) {
+	if len(body) != 33 && len(body) != 41 {
+		return seq, fmt.Errorf("invalid sequence body of %d bytes", len(body))
+	}
+	seq.Hash = hex.EncodeToString(body[:32])
+	switch body[32] {
+	case 'C':
+		seq.Label = events.SequenceBlockConnected
+	case 'D':
+		seq.Label = events.SequenceBlockDisconnected
+	case 'A':
+		seq.Label = events.SequenceTxAdded
+	case 'R':
+		seq.Label = events.SequenceTxRemoved
+	default:
+		return seq, fmt.Errorf("unknown sequence label %q", body[32])
+	}
+	if len(body) == 41 {
+		seq.MempoolSequence = binary.LittleEndian.Uint64(body[33:])
+	}
+	return seq, nil
+}
@@ -0,0 +1,336 @@
+package zmq
+
+/*
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"gitlab.com/nolim1t/golang-httpd-test/common"
+	"gitlab.com/nolim1t/golang-httpd-test/events"
+)
+
+// A ZMQ PUB socket speaking just enough ZMTP 3.0 (NULL mechanism) for one subscriber
+type publisher struct {
+	t        *testing.T
+	listener net.Listener
+	conn     net.Conn
+}
+
+func newPublisher(t *testing.T) *publisher {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &publisher{t: t, listener: listener}
+}
+
+func (p *publisher) endpoint() string {
+	return "tcp://" + p.listener.Addr().String()
+}
+
+// accept does the handshake and waits for the given amount of subscriptions
+func (p *publisher) accept(subscriptions int) {
+	conn, err := p.listener.Accept()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	p.conn = conn
+
+	greeting := make([]byte, 64)
+	copy(greeting, []byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0x7f, 3, 0})
+	copy(greeting[12:], "NULL")
+	p.write(greeting)
+	if _, err := io.ReadFull(conn, make([]byte, 64)); err != nil {
+		p.t.Fatalf("reading greeting: %s", err)
+	}
+
+	ready := append([]byte{5}, "READY"...)
+	ready = append(ready, 11)
+	ready = append(ready, "Socket-Type"...)
+	ready = append(ready, 0, 0, 0, 3)
+	ready = append(ready, "PUB"...)
+	p.frame(4, ready)
+	if flag, _ := p.readFrame(); flag&4 == 0 {
+		p.t.Fatal("expected the READY command")
+	}
+	for i := 0; i < subscriptions; i++ {
+		if _, body := p.readFrame(); len(body) == 0 || body[0] != 1 {
+			p.t.Fatalf("expected a subscription, got %q", body)
+		}
+	}
+}
+
+// publish sends a [topic, body, sequence] message like bitcoind does
+func (p *publisher) publish(topic string, body []byte, sequence uint32) {
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, sequence)
+	p.frame(1, []byte(topic))
+	p.frame(1, body)
+	p.frame(0, seq)
+}
+
+func (p *publisher) frame(flag byte, body []byte) {
+	if len(body) > 255 {
+		header := make([]byte, 9)
+		header[0] = flag | 2
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+		p.write(header)
+	} else {
+		p.write([]byte{flag, byte(len(body))})
+	}
+	p.write(body)
+}
+
+func (p *publisher) readFrame() (flag byte, body []byte) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(p.conn, header); err != nil {
+		p.t.Fatalf("reading frame: %s", err)
+	}
+	body = make([]byte, header[1])
+	if _, err := io.ReadFull(p.conn, body); err != nil {
+		p.t.Fatalf("reading frame: %s", err)
+	}
+	return header[0], body
+}
+
+func (p *publisher) write(data []byte) {
+	if _, err := p.conn.Write(data); err != nil {
+		p.t.Fatalf("writing: %s", err)
+	}
+}
+
+func (p *publisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.listener.Close()
+}
+
+func testTx() (raw []byte, txid string) {
+	return testTxSpending(1)
+}
+
+// testTxSpending returns a different transaction for every n
+func testTxSpending(n byte) (raw []byte, txid string) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{n}, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(50000, []byte{0x00, 0x14, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}))
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	return buf.Bytes(), tx.TxHash().String()
+}
+
+func nextEvent(t *testing.T, sub *events.Subscription) events.Event {
+	select {
+	case event := <-sub.C:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return events.Event{}
+	}
+}
+
+func TestSubscriberDecodesNotificationsAndGaps(t *testing.T) {
+	pub := newPublisher(t)
+	defer pub.close()
+	bus := events.NewBus()
+	sub := bus.Subscribe(16)
+	defer sub.Unsubscribe()
+
+	s := New("node1", common.Bitcoind{
+		ZMQPubHashBlock: pub.endpoint(),
+		ZMQPubRawTx:     pub.endpoint(),
+		ZMQPubSequence:  pub.endpoint(),
+	}, bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	// the three topics share the endpoint, so a single connection
+	pub.accept(3)
+
+	blockHash := bytes.Repeat([]byte{0xab}, 32)
+	pub.publish(TopicHashBlock, blockHash, 7)
+	event := nextEvent(t, sub)
+	if hash, ok := event.Data.(events.BlockHash); event.Topic != events.TopicHashBlock || !ok || hash.Hash != hex.EncodeToString(blockHash) {
+		t.Fatalf("hashblock: got %+v", event)
+	}
+	if event.Source != "node1" {
+		t.Errorf("source %q, want node1", event.Source)
+	}
+
+	// 8 is skipped
+	pub.publish(TopicHashBlock, blockHash, 9)
+	event = nextEvent(t, sub)
+	gap, ok := event.Data.(events.Gap)
+	if event.Topic != events.TopicZMQGap || !ok {
+		t.Fatalf("expected a gap, got %+v", event)
+	}
+	if gap.ZMQTopic != TopicHashBlock || gap.Expected != 8 || gap.Received != 9 || gap.Endpoint != pub.endpoint() {
+		t.Errorf("gap: got %+v", gap)
+	}
+	if event = nextEvent(t, sub); event.Topic != events.TopicHashBlock {
+		t.Fatalf("expected the hashblock after the gap, got %+v", event)
+	}
+
+	// sequence numbers are per topic, the first rawtx isn't a gap
+	raw, txid := testTx()
+	pub.publish(TopicRawTx, raw, 100)
+	event = nextEvent(t, sub)
+	tx, ok := event.Data.(events.RawTx)
+	if event.Topic != events.TopicRawTx || !ok {
+		t.Fatalf("expected rawtx, got %+v", event)
+	}
+	if tx.TxID != txid || tx.Size != len(raw) || tx.VSize != len(raw) {
+		t.Errorf("rawtx: got txid %s size %d vsize %d, want %s %d %d", tx.TxID, tx.Size, tx.VSize, txid, len(raw), len(raw))
+	}
+
+	txHash := bytes.Repeat([]byte{0xcd}, 32)
+	body := append(append([]byte{}, txHash...), 'A')
+	body = append(body, 5, 0, 0, 0, 0, 0, 0, 0)
+	pub.publish(TopicSequence, body, 0)
+	event = nextEvent(t, sub)
+	seq, ok := event.Data.(events.Sequence)
+	if event.Topic != events.TopicSequence || !ok {
+		t.Fatalf("expected sequence, got %+v", event)
+	}
+	if seq.Hash != hex.EncodeToString(txHash) || seq.Label != events.SequenceTxAdded || seq.MempoolSequence != 5 {
+		t.Errorf("sequence: got %+v", seq)
+	}
+
+	// undecodable bodies are dropped, the connection stays up
+	pub.publish(TopicRawTx, []byte{1, 2, 3}, 101)
+	pub.publish(TopicHashBlock, blockHash, 10)
+	if event = nextEvent(t, sub); event.Topic != events.TopicHashBlock {
+		t.Fatalf("expected hashblock after an invalid rawtx, got %+v", event)
+	}
+}
+
+func TestDecodeSequence(t *testing.T) {
+	hash := bytes.Repeat([]byte{1}, 32)
+	for label, want := range map[byte]string{
+		'C': events.SequenceBlockConnected,
+		'D': events.SequenceBlockDisconnected,
+		'R': events.SequenceTxRemoved,
+	} {
+		seq, err := decodeSequence(append(append([]byte{}, hash...), label))
+		if err != nil || seq.Label != want || seq.MempoolSequence != 0 {
+			t.Errorf("label %c: got %+v, %v", label, seq, err)
+		}
+	}
+	if _, err := decodeSequence(append(append([]byte{}, hash...), 'X')); err == nil {
+		t.Error("unknown label: no error")
+	}
+	if _, err := decodeSequence(hash); err == nil {
+		t.Error("short body: no error")
+	}
+}
+
+// sequenceBody returns the body of a tx_added ('A') or tx_removed ('R') notification
+func sequenceBody(txid string, label byte, mempoolSequence byte) []byte {
+	body, _ := hex.DecodeString(txid)
+	return append(body, label, mempoolSequence, 0, 0, 0, 0, 0, 0, 0)
+}
+
+func TestMempoolTxNeedsTxAdded(t *testing.T) {
+	pub := newPublisher(t)
+	defer pub.close()
+	bus := events.NewBus()
+	sub := bus.Subscribe(16, events.TopicMempoolTx)
+	defer sub.Unsubscribe()
+
+	s := New("node1", common.Bitcoind{ZMQPubRawTx: pub.endpoint(), ZMQPubSequence: pub.endpoint()}, bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	pub.accept(2)
+
+	var rawSeq, seqSeq uint32
+	rawTx := func(n byte) string {
+		raw, txid := testTxSpending(n)
+		pub.publish(TopicRawTx, raw, rawSeq)
+		rawSeq++
+		return txid
+	}
+	sequence := func(txid string, label byte) {
+		pub.publish(TopicSequence, sequenceBody(txid, label, byte(seqSeq)), seqSeq)
+		seqSeq++
+	}
+	expect := func(txid, what string) {
+		event := nextEvent(t, sub)
+		if tx, ok := event.Data.(events.RawTx); !ok || tx.TxID != txid {
+			t.Fatalf("%s: got %+v, want mempool_tx %s", what, event.Data, txid)
+		}
+	}
+
+	// rawtx first
+	txid := rawTx(1)
+	sequence(txid, 'A')
+	expect(txid, "rawtx before tx_added")
+
+	// tx_added first (separate endpoints don't keep the order)
+	_, txid = testTxSpending(2)
+	sequence(txid, 'A')
+	rawTx(2)
+	expect(txid, "tx_added before rawtx")
+
+	// a rawtx from a connected block has no tx_added
+	rawTx(3)
+	// added and removed before its rawtx arrived
+	_, txid = testTxSpending(4)
+	sequence(txid, 'A')
+	sequence(txid, 'R')
+	rawTx(4)
+	txid = rawTx(5)
+	sequence(txid, 'A')
+	expect(txid, "after a block transaction and a removed one")
+}
+
+func TestMempoolPairsExpire(t *testing.T) {
+	pairs := newMempoolPairs()
+	nextWindow := func() {
+		pairs.rotated = pairs.rotated.Add(-pairWindow)
+	}
+
+	// kept in the next window
+	pairs.rawTx(events.RawTx{TxID: "aa"})
+	pairs.txAdded("bb")
+	nextWindow()
+	if got, ok := pairs.txAdded("aa"); !ok || got.TxID != "aa" {
+		t.Fatalf("rawtx of the previous window: got %+v, %v", got, ok)
+	}
+
+	// gone after two, "bb" was added two windows ago
+	pairs.rawTx(events.RawTx{TxID: "cc"})
+	nextWindow()
+	pairs.txAdded("dd")
+	nextWindow()
+	if _, ok := pairs.txAdded("cc"); ok {
+		t.Error("rawtx kept for more than two windows")
+	}
+	if pairs.rawTx(events.RawTx{TxID: "bb"}) {
+		t.Error("tx_added kept for more than two windows")
+	}
+	if !pairs.rawTx(events.RawTx{TxID: "dd"}) {
+		t.Error("tx_added of the previous window not paired")
+	}
+}